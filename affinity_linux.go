@@ -0,0 +1,66 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// setCPUAffinity привязывает все потоки процесса к списку CPU ("4-7" или
+// "0,2,4-5") и возвращает число ядер в нём. Новые потоки рантайма
+// наследуют маску от создающего потока, поэтому достаточно пройтись по
+// /proc/self/task.
+func setCPUAffinity(list string) (int, error) {
+	var set unix.CPUSet
+	for _, part := range strings.Split(list, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		lo, hi, isRange := strings.Cut(part, "-")
+		from, err := strconv.Atoi(lo)
+		if err != nil {
+			return 0, fmt.Errorf("bad CPU %q", part)
+		}
+		to := from
+		if isRange {
+			if to, err = strconv.Atoi(hi); err != nil || to < from {
+				return 0, fmt.Errorf("bad CPU range %q", part)
+			}
+		}
+		for c := from; c <= to; c++ {
+			set.Set(c)
+		}
+	}
+	if set.Count() == 0 {
+		return 0, fmt.Errorf("empty CPU list %q", list)
+	}
+
+	tasks, err := os.ReadDir("/proc/self/task")
+	if err != nil {
+		return 0, err
+	}
+	for _, t := range tasks {
+		tid, err := strconv.Atoi(t.Name())
+		if err != nil {
+			continue
+		}
+		if err := unix.SchedSetaffinity(tid, &set); err != nil {
+			return 0, fmt.Errorf("sched_setaffinity(%d): %w", tid, err)
+		}
+	}
+
+	// GOMAXPROCS считается при старте по исходной маске — подгоняем,
+	// если пользователь не задал его явно.
+	if os.Getenv("GOMAXPROCS") == "" {
+		runtime.GOMAXPROCS(set.Count())
+	}
+	return set.Count(), nil
+}
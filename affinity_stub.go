@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "errors"
+
+// заглушка: sched_setaffinity есть только в Linux.
+func setCPUAffinity(string) (int, error) {
+	return 0, errors.New("CPU affinity not supported on this platform")
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/******************** PG_BASEBACKUP LAYOUT ********************/
+
+// baseBackupSuffix — каталог --pgbasebackup-compatible внутри daily/….
+const baseBackupSuffix = "_basebackup"
+
+// Как в pg_basebackup: каталоги, содержимое которых не копируется (сами
+// каталоги в base.tar есть), и файлы, которые не копируются никогда.
+var (
+	bbSkipContents = map[string]bool{
+		"pg_wal": true, "pg_dynshmem": true, "pg_notify": true, "pg_replslot": true,
+		"pg_serial": true, "pg_snapshots": true, "pg_stat_tmp": true, "pg_subtrans": true,
+	}
+	bbSkipFiles = map[string]bool{
+		"postmaster.pid": true, "postmaster.opts": true, "pg_internal.init": true,
+		"backup_label": true, "tablespace_map": true, "backup_manifest": true,
+		"postgresql.auto.conf.tmp": true, "current_logfiles.tmp": true,
+	}
+	crc32c = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type manifestFile struct {
+	Path  string
+	Size  int64
+	Mtime time.Time
+	CRC   uint32
+}
+
+// bbTar — один tar-архив набора (base или табличное пространство).
+type bbTar struct {
+	f      *os.File
+	gw     io.WriteCloser
+	tw     *tar.Writer
+	closed bool
+}
+
+func createBBTar(path string) (*bbTar, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	gw, err := newArchiveWriter(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &bbTar{f: f, gw: gw, tw: tar.NewWriter(gw)}, nil
+}
+
+func (t *bbTar) Close() error {
+	if t.closed {
+		return nil
+	}
+	t.closed = true
+	err := t.tw.Close()
+	if e := t.gw.Close(); err == nil {
+		err = e
+	}
+	if e := t.f.Sync(); err == nil {
+		err = e
+	}
+	if e := t.f.Close(); err == nil {
+		err = e
+	}
+	return err
+}
+
+// writeBaseBackup пишет в каталог dst то же, что pg_basebackup -Ft -z -X none:
+// base.tar.gz (с backup_label и tablespace_map в конце), <oid>.tar.gz на
+// каждое табличное пространство и backup_manifest с CRC32C каждого файла.
+// WAL не включается — для восстановления нужен архив WAL (restore_command).
+func writeBaseBackup(dst, dataDir string, opts archiveOpts) (*archiveStats, error) {
+	st := &archiveStats{}
+	if err := makeBackupDir(dst); err != nil {
+		return st, err
+	}
+	base, err := createBBTar(filepath.Join(dst, "base"+archiveExt()))
+	if err != nil {
+		return st, err
+	}
+	defer base.Close()
+
+	// глобальный флаг только читаем: кластеры архивируются параллельно
+	bufSize := max(int(readBufferSize), minReadBufferSize)
+	buf := make([]byte, bufSize)
+	var manifest []manifestFile
+	prog := startProgress(opts.Cluster)
+	defer prog.stop()
+
+	addFile := func(t *bbTar, path, name, manifestPath string, info fs.FileInfo) error {
+		if opts.Cancelled != nil {
+			if err := opts.Cancelled(); err != nil {
+				return err
+			}
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			if bestEffort {
+				log.Printf("%s⚠️  Skipping %s (%s): %v%s", yellow, manifestPath, skipReason(err), err, reset)
+				st.Skipped = append(st.Skipped, skippedFile{manifestPath, skipReason(err), err.Error()})
+				return nil
+			}
+			return err
+		}
+		defer f.Close()
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = name
+		if err := t.tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		adviseSequential(f)
+		crc := crc32.New(crc32c)
+		if _, err := copyBounded(prog.writer(io.MultiWriter(t.tw, crc)), f, hdr.Size, buf); err != nil {
+			return err
+		}
+		manifest = append(manifest, manifestFile{manifestPath, hdr.Size, info.ModTime(), crc.Sum32()})
+		st.Files++
+		st.Bytes += hdr.Size
+		prog.fileDone()
+		return nil
+	}
+	addDir := func(t *bbTar, name string, info fs.FileInfo) error {
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Typeflag, hdr.Linkname = tar.TypeDir, ""
+		hdr.Name = name + "/"
+		return t.tw.WriteHeader(hdr)
+	}
+
+	// табличные пространства: pg_tblspc/<oid> → цель симлинка
+	var tablespaces []string
+	err = filepath.Walk(dataDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(dataDir, path)
+		if rel == "." {
+			return nil
+		}
+		name := filepath.ToSlash(rel)
+		parent := filepath.ToSlash(filepath.Dir(rel))
+		if isExcluded(rel, info.IsDir()) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if parent == "pg_tblspc" && info.Mode()&os.ModeSymlink != 0 {
+			tablespaces = append(tablespaces, filepath.Base(rel))
+			return nil
+		}
+		if bbSkipContents[parent] {
+			if !info.IsDir() {
+				return nil
+			}
+			if parent == "pg_wal" { // archive_status, summaries — пустыми
+				if err := addDir(base, name, info); err != nil {
+					return err
+				}
+			}
+			return filepath.SkipDir
+		}
+		if rel == "pg_wal" && info.Mode()&os.ModeSymlink != 0 {
+			// pg_wal на другом диске: в архиве обычный пустой каталог
+			if err := addDir(base, "pg_wal", info); err != nil {
+				return err
+			}
+			return addDir(base, "pg_wal/archive_status", info)
+		}
+		if info.IsDir() {
+			if excludedDBDir(rel, opts.IncludeOIDs) {
+				return filepath.SkipDir
+			}
+			if err := addDir(base, name, info); err != nil {
+				return err
+			}
+			if strings.HasPrefix(info.Name(), "pgsql_tmp") || isExcludedDir(rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() || bbSkipFiles[info.Name()] || strings.HasPrefix(info.Name(), "pgsql_tmp") {
+			return nil
+		}
+		return addFile(base, path, name, name, info)
+	})
+	if err != nil {
+		return st, err
+	}
+
+	for _, oid := range tablespaces {
+		link := filepath.Join(dataDir, "pg_tblspc", oid)
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			return st, fmt.Errorf("tablespace %s: %w", oid, err)
+		}
+		t, err := createBBTar(filepath.Join(dst, oid+archiveExt()))
+		if err != nil {
+			return st, err
+		}
+		err = filepath.Walk(target, func(path string, info fs.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(target, path)
+			if rel == "." {
+				return nil
+			}
+			name := filepath.ToSlash(rel)
+			if isExcluded("pg_tblspc/"+oid+"/"+name, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if info.IsDir() {
+				if excludedDBDir(rel, opts.IncludeOIDs) {
+					return filepath.SkipDir
+				}
+				if err := addDir(t, name, info); err != nil {
+					return err
+				}
+				if strings.HasPrefix(info.Name(), "pgsql_tmp") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if !info.Mode().IsRegular() || strings.HasPrefix(info.Name(), "pgsql_tmp") {
+				return nil
+			}
+			return addFile(t, path, name, "pg_tblspc/"+oid+"/"+name, info)
+		})
+		if e := t.Close(); err == nil {
+			err = e
+		}
+		if err != nil {
+			return st, fmt.Errorf("tablespace %s: %w", oid, err)
+		}
+	}
+
+	// стоп бэкапа — только теперь известен backup_label
+	if opts.Stop == nil {
+		return st, fmt.Errorf("internal: no backup stop callback")
+	}
+	stopLSN, label, spcmap, err := opts.Stop()
+	if err != nil {
+		return st, fmt.Errorf("stop backup: %w", err)
+	}
+	now := time.Now()
+	for _, e := range []struct{ name, body string }{{"backup_label", label}, {"tablespace_map", spcmap}} {
+		if e.body == "" {
+			continue
+		}
+		if err := writeTarEntry(base.tw, e.name, e.body); err != nil {
+			return st, err
+		}
+		manifest = append(manifest, manifestFile{e.name, int64(len(e.body)), now,
+			crc32.Checksum([]byte(e.body), crc32c)})
+	}
+	if err := base.Close(); err != nil {
+		return st, err
+	}
+
+	body, err := buildManifest(manifest, label, stopLSN)
+	if err != nil {
+		return st, err
+	}
+	if err := os.WriteFile(filepath.Join(dst, "backup_manifest"), body, 0o600); err != nil {
+		return st, err
+	}
+	if err := syncFile(filepath.Join(dst, "backup_manifest")); err != nil {
+		return st, err
+	}
+	if err := syncDir(dst); err != nil {
+		return st, err
+	}
+	if err := syncDir(filepath.Dir(dst)); err != nil {
+		return st, err
+	}
+	for _, name := range append([]string{"base", "backup_manifest"}, tablespaces...) {
+		if name != "backup_manifest" {
+			name += archiveExt()
+		}
+		chownBackup(filepath.Join(dst, name))
+	}
+	return st, nil
+}
+
+var (
+	labelStartRe    = regexp.MustCompile(`(?m)^START WAL LOCATION: ([0-9A-Fa-f]+/[0-9A-Fa-f]+)`)
+	labelTimelineRe = regexp.MustCompile(`(?m)^START TIMELINE: (\d+)`)
+)
+
+// buildManifest собирает backup_manifest версии 1 (формат PostgreSQL 13+).
+// Manifest-Checksum — SHA-256 всего текста до строки с ним самим.
+func buildManifest(files []manifestFile, label, stopLSN string) ([]byte, error) {
+	start := labelStartRe.FindStringSubmatch(label)
+	tli := labelTimelineRe.FindStringSubmatch(label)
+	if start == nil || tli == nil {
+		return nil, fmt.Errorf("cannot parse backup_label for the WAL range")
+	}
+	startLSN, err := parseLSN(start[1])
+	if err != nil {
+		return nil, err
+	}
+	endLSN, err := parseLSN(stopLSN)
+	if err != nil {
+		return nil, fmt.Errorf("stop LSN %q: %w", stopLSN, err)
+	}
+
+	var b strings.Builder
+	b.WriteString(`{ "PostgreSQL-Backup-Manifest-Version": 1,` + "\n" + `"Files": [`)
+	for i, f := range files {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		path, _ := json.Marshal(f.Path)
+		// pg_verifybackup хранит CRC32C в порядке байт little-endian
+		crc := binary.LittleEndian.AppendUint32(nil, f.CRC)
+		fmt.Fprintf(&b, "\n"+`{ "Path": %s, "Size": %d, "Last-Modified": "%s", "Checksum-Algorithm": "CRC32C", "Checksum": "%x" }`,
+			path, f.Size, f.Mtime.UTC().Format("2006-01-02 15:04:05 GMT"), crc)
+	}
+	fmt.Fprintf(&b, "\n],\n"+`"WAL-Ranges": [`+"\n"+`{ "Timeline": %s, "Start-LSN": "%s", "End-LSN": "%s" }`+"\n],\n",
+		tli[1], formatLSN(startLSN), formatLSN(endLSN))
+	sum := sha256.Sum256([]byte(b.String()))
+	fmt.Fprintf(&b, `"Manifest-Checksum": "%x"}`+"\n", sum)
+	return []byte(b.String()), nil
+}
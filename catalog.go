@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+/******************** CATALOG ********************/
+
+// catalogFile — индекс всех локальных архивов в корне бэкапов хоста:
+// хранит то, чего не восстановить по файлам (LSN, итоги загрузок).
+const catalogFile = "catalog.json"
+
+var catalogTiers = []string{"daily", "weekly", "monthly", "yearly"}
+
+type catalogEntry struct {
+	Cluster  string          `json:"cluster"`
+	Tier     string          `json:"tier"`
+	Path     string          `json:"path"` // относительно корня каталога
+	Time     time.Time       `json:"time"`
+	StartLSN string          `json:"start_lsn,omitempty"`
+	StopLSN  string          `json:"stop_lsn,omitempty"`
+	Size     int64           `json:"size"`
+	SHA256   string          `json:"sha256,omitempty"`  // у каталогов --pgbasebackup-compatible нет
+	Uploads  map[string]bool `json:"uploads,omitempty"` // user@host → загружен ли
+}
+
+type catalog struct {
+	Updated time.Time      `json:"updated"`
+	Backups []catalogEntry `json:"backups"`
+}
+
+// catalogMu: кластеры одного прогона обновляют индекс по очереди.
+var catalogMu sync.Mutex
+
+func catalogRoot() string {
+	host, _ := os.Hostname()
+	return filepath.Join(backupPath, host, backupSubdir)
+}
+
+func loadCatalog() (*catalog, error) {
+	data, err := os.ReadFile(filepath.Join(catalogRoot(), catalogFile))
+	if err != nil {
+		return nil, err
+	}
+	var c catalog
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", catalogFile, err)
+	}
+	return &c, nil
+}
+
+// save пишет индекс атомарно: временный файл и rename.
+func (c *catalog) save() error {
+	c.Updated = time.Now()
+	sort.Slice(c.Backups, func(i, j int) bool {
+		a, b := c.Backups[i], c.Backups[j]
+		if a.Cluster != b.Cluster {
+			return a.Cluster < b.Cluster
+		}
+		if a.Tier != b.Tier {
+			return a.Tier < b.Tier
+		}
+		return a.Time.Before(b.Time)
+	})
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(catalogRoot(), catalogFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o600); err != nil {
+		return err
+	}
+	chownBackup(tmp)
+	return os.Rename(tmp, path)
+}
+
+// prune выкидывает записи об архивах, удалённых ротацией.
+func (c *catalog) prune() {
+	root := catalogRoot()
+	kept := c.Backups[:0]
+	for _, e := range c.Backups {
+		if _, err := os.Stat(filepath.Join(root, e.Path)); err == nil {
+			kept = append(kept, e)
+		}
+	}
+	c.Backups = kept
+}
+
+// updateCatalog вносит свежий архив (и его копии в weekly/monthly/yearly)
+// и синхронизирует индекс с ротацией. Ошибки только логируются: индекс
+// восстанавливается через --reindex.
+func updateCatalog(cl cluster, archive string, e catalogEntry) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	c, err := loadCatalog()
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("%s⚠️  %v — starting a new catalog (run --reindex to recover)%s", yellow, err, reset)
+		}
+		c = &catalog{}
+	}
+	root := catalogRoot()
+	e.Cluster = cl.Name
+	e.Size = archiveSize(archive)
+	e.SHA256 = fileSHA256(archive)
+	for _, tier := range catalogTiers {
+		p := filepath.Join(root, cl.Name, tier, filepath.Base(archive))
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		e.Tier = tier
+		e.Path, _ = filepath.Rel(root, p)
+		c.Backups = append(removeCatalogPath(c.Backups, e.Path), e)
+	}
+	c.prune()
+	if err := c.save(); err != nil {
+		log.Printf("%sCannot write %s: %v%s", red, catalogFile, err, reset)
+	}
+}
+
+// setCatalogUpload записывает итог повторной загрузки (--reconcile); rel —
+// путь от корня каталога через "/". Архивов вне индекса не добавляет.
+func setCatalogUpload(rel, target string, ok bool) {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	c, err := loadCatalog()
+	if err != nil {
+		return
+	}
+	for i := range c.Backups {
+		if c.Backups[i].Path != filepath.FromSlash(rel) {
+			continue
+		}
+		if c.Backups[i].Uploads == nil {
+			c.Backups[i].Uploads = map[string]bool{}
+		}
+		c.Backups[i].Uploads[target] = ok
+		if err := c.save(); err != nil {
+			log.Printf("%sCannot write %s: %v%s", red, catalogFile, err, reset)
+		}
+		return
+	}
+}
+
+func removeCatalogPath(list []catalogEntry, path string) []catalogEntry {
+	out := list[:0]
+	for _, e := range list {
+		if e.Path != path {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// fileSHA256 — контрольная сумма архива-файла ("" для каталога или при
+// ошибке); готовый sidecar .sha256 избавляет от повторного чтения.
+func fileSHA256(path string) string {
+	if info, err := os.Stat(path); err != nil || info.IsDir() {
+		return ""
+	}
+	if sum, err := readChecksum(path); err == nil {
+		return sum
+	}
+	sum, _ := hashFile(path)
+	return sum
+}
+
+// reindex пересобирает индекс по файловой системе. LSN и статус загрузок
+// берутся из старого индекса, если он читается, иначе LSN — из
+// sidecar-файла backup_label.
+func reindex() int {
+	catalogMu.Lock()
+	defer catalogMu.Unlock()
+	root := catalogRoot()
+	old := map[string]catalogEntry{}
+	if c, err := loadCatalog(); err == nil {
+		for _, e := range c.Backups {
+			old[e.Path] = e
+		}
+	}
+	c := &catalog{}
+	dirs, _ := os.ReadDir(root)
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		for _, tier := range catalogTiers {
+			for _, a := range localArchives(filepath.Join(root, d.Name(), tier)) {
+				rel, _ := filepath.Rel(root, a)
+				e := catalogEntry{Cluster: d.Name(), Tier: tier, Path: rel, Time: archiveTime(a), Size: archiveSize(a), SHA256: fileSHA256(a)}
+				if o, ok := old[rel]; ok {
+					e.StartLSN, e.StopLSN, e.Uploads = o.StartLSN, o.StopLSN, o.Uploads
+				} else if label, err := os.ReadFile(a + ".backup_label"); err == nil {
+					if m := labelStartRe.FindStringSubmatch(string(label)); m != nil {
+						e.StartLSN = m[1]
+					}
+				}
+				c.Backups = append(c.Backups, e)
+			}
+		}
+	}
+	if err := c.save(); err != nil {
+		log.Printf("%sCannot write %s: %v%s", red, catalogFile, err, reset)
+		return exitFailure
+	}
+	log.Printf("%s📇 Catalog rebuilt: %d archive(s) in %s%s", green, len(c.Backups), filepath.Join(root, catalogFile), reset)
+	return 0
+}
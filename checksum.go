@@ -0,0 +1,87 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/******************** CHECKSUM ********************/
+
+// <archive>.sha256 — в формате sha256sum («hash  имя»), так что
+// `sha256sum -c` работает и без этой программы, в том числе на FTP.
+const checksumSuffix = ".sha256"
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	return hashReader(f)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum считает SHA-256 готового архива и пишет sidecar.
+// Каталог --pgbasebackup-compatible пропускается: у него backup_manifest.
+func writeChecksum(archive string) (string, error) {
+	if info, err := os.Stat(archive); err != nil || info.IsDir() {
+		return "", err
+	}
+	sum, err := hashFile(archive)
+	if err != nil {
+		return "", err
+	}
+	line := sum + "  " + filepath.Base(archive) + "\n"
+	if err := os.WriteFile(archive+checksumSuffix, []byte(line), 0o600); err != nil {
+		return "", err
+	}
+	chownBackup(archive + checksumSuffix)
+	return sum, nil
+}
+
+// readChecksum — hash из sidecar архива.
+func readChecksum(archive string) (string, error) {
+	data, err := os.ReadFile(archive + checksumSuffix)
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 || len(fields[0]) != sha256.Size*2 {
+		return "", fmt.Errorf("%s: malformed", archive+checksumSuffix)
+	}
+	return strings.ToLower(fields[0]), nil
+}
+
+// verifyChecksum — --verify: пересчитывает SHA-256 и сверяет с sidecar.
+func verifyChecksum(archive string) int {
+	archive = strings.TrimSuffix(archive, checksumSuffix)
+	want, err := readChecksum(archive)
+	if err != nil {
+		log.Printf("%s%v%s", red, err, reset)
+		return exitFailure
+	}
+	got, err := hashFile(archive)
+	if err != nil {
+		log.Printf("%s%v%s", red, err, reset)
+		return exitFailure
+	}
+	if got != want {
+		log.Printf("%s❌ %s: SHA-256 mismatch (sidecar %s, file %s)%s", red, archive, want, got, reset)
+		return exitFailure
+	}
+	log.Printf("%s✅ %s: SHA-256 OK%s", green, archive, reset)
+	return 0
+}
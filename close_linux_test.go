@@ -0,0 +1,64 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+// withFileSizeLimit — RLIMIT_FSIZE на время теста: запись за предел
+// получает EFBIG, как на кончившемся диске (SIGXFSZ рантайм Go игнорирует).
+func withFileSizeLimit(t *testing.T, limit uint64) {
+	t.Helper()
+	var old syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_FSIZE, &old); err != nil {
+		t.Skip(err)
+	}
+	if err := syscall.Setrlimit(syscall.RLIMIT_FSIZE, &syscall.Rlimit{Cur: limit, Max: old.Max}); err != nil {
+		t.Skip(err)
+	}
+	t.Cleanup(func() { _ = syscall.Setrlimit(syscall.RLIMIT_FSIZE, &old) })
+}
+
+func TestCreateTarGzFromDirFileReturnsCloseError(t *testing.T) {
+	dir := testDataDir(t, 100)
+	dst := filepath.Join(t.TempDir(), "a.tar.gz")
+	withFileSizeLimit(t, 16) // заголовок gzip влезает, остальное пишет Close
+	_, err := createTarGzFromDir(dst, dir, archiveOpts{})
+	if !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("err = %v, want EFBIG", err)
+	}
+	for _, p := range []string{dst, dst + partialSuffix} {
+		if _, err := os.Stat(p); err == nil {
+			t.Errorf("%s left behind", p)
+		}
+	}
+}
+
+func TestCreateTarGzReturnsCloseError(t *testing.T) {
+	dir := testDataDir(t, 100)
+	dst := filepath.Join(t.TempDir(), "a.tar.gz")
+	withFileSizeLimit(t, 16)
+	err := createTarGz(dst, []string{filepath.Join(dir, "PG_VERSION")})
+	if !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("err = %v, want EFBIG", err)
+	}
+}
+
+func TestCopyFileReturnsWriteError(t *testing.T) {
+	dir := testDataDir(t, 1<<20)
+	dst := filepath.Join(t.TempDir(), "copy")
+	withFileSizeLimit(t, 4096)
+	err := copyFile(filepath.Join(dir, "base", "1", "1234"), dst)
+	if !errors.Is(err, syscall.EFBIG) {
+		t.Fatalf("err = %v, want EFBIG", err)
+	}
+	if _, err := os.Stat(dst); err == nil {
+		t.Error("partial copy left behind")
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var errDiskFull = errors.New("disk full")
+
+// limitedWriter принимает n байт, дальше — errDiskFull (короткая запись).
+type limitedWriter struct{ n int }
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) <= w.n {
+		w.n -= len(p)
+		return len(p), nil
+	}
+	n := w.n
+	w.n = 0
+	return n, errDiskFull
+}
+
+// testDataDir — маленький data directory: PG_VERSION и файл отношения.
+func testDataDir(t *testing.T, size int) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "PG_VERSION"), []byte("16\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	data := make([]byte, size)
+	_, _ = rand.Read(data) // несжимаемое: сжатие не спрячет объём
+	if err := os.MkdirAll(filepath.Join(dir, "base", "1"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "base", "1", "1234"), data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+// Ошибка записи в хвосте архива — при сбросе сжатия в Close — должна
+// вернуться из createTarGzFromDir, а не потеряться в defer.
+func TestCreateTarGzFromDirReturnsCloseError(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		size, lim int
+	}{
+		{"fails on close", 100, 10}, // заголовок gzip пишется сразу, остальное — в Close
+		{"fails mid-stream", 4 << 20, 64 << 10},
+		{"fails at the tail", 4 << 20, 4<<20 - 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := testDataDir(t, tc.size)
+			_, err := createTarGzFromDir("", dir, archiveOpts{Output: &limitedWriter{tc.lim}})
+			if !errors.Is(err, errDiskFull) {
+				t.Fatalf("err = %v, want %v", err, errDiskFull)
+			}
+		})
+	}
+}
+
+func TestCreateTarGzFromDirCompletes(t *testing.T) {
+	dir := testDataDir(t, 100)
+	if _, err := createTarGzFromDir("", dir, archiveOpts{Output: &limitedWriter{1 << 20}}); err != nil {
+		t.Fatal(err)
+	}
+}
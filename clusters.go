@@ -0,0 +1,240 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************** CLUSTERS ********************/
+
+// defaultCluster — имя кластера из --dsn (исторический каталог cluster/).
+const defaultCluster = "cluster"
+
+// cluster — один кластер PostgreSQL: свой DSN, свой каталог и свой lock.
+type cluster struct{ Name, DSN string }
+
+// clusterList — повторяемый флаг --cluster name=DSN.
+type clusterList []cluster
+
+var clusterNameRe = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func (l *clusterList) String() string {
+	var names []string
+	for _, c := range *l {
+		names = append(names, c.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (l *clusterList) Set(v string) error {
+	name, dsn, ok := strings.Cut(v, "=")
+	if !ok || dsn == "" || !clusterNameRe.MatchString(name) {
+		return fmt.Errorf("want <name>=<DSN>, got %q", v)
+	}
+	// «host=/var/run/...» без имени — частая опечатка
+	switch name {
+	case "host", "port", "user", "dbname", "password", "sslmode":
+		return fmt.Errorf("missing cluster name in %q (want <name>=<DSN>)", v)
+	}
+	for _, c := range *l {
+		if c.Name == name {
+			return fmt.Errorf("duplicate cluster name %q", name)
+		}
+	}
+	*l = append(*l, cluster{Name: name, DSN: dsn})
+	return nil
+}
+
+// loadClustersFile дополняет список кластерами из файла: по строке
+// <name>=<DSN>, пустые строки и # комментарии пропускаются.
+func loadClustersFile(path string, l *clusterList) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := l.Set(line); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+	}
+	return nil
+}
+
+type clusterResult struct {
+	Cluster  cluster
+	Archive  string
+	Skipped  int // --best-effort: файлы, не попавшие в архив
+	Err      error
+	Duration time.Duration
+}
+
+// runClusters бэкапит все кластеры (не больше parallelClusters за раз),
+// печатает общую сводку и возвращает итоговый код выхода — самый
+// серьёзный из кодов кластеров (см. severity).
+func runClusters(list clusterList) int {
+	if parallelClusters < 1 {
+		parallelClusters = 1
+	}
+	markRunning()
+	results := make([]clusterResult, len(list))
+	sem := make(chan struct{}, parallelClusters)
+	var wg sync.WaitGroup
+	for i, cl := range list {
+		wg.Add(1)
+		go func(i int, cl cluster) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i] = runClusterLocked(cl)
+		}(i, cl)
+	}
+	wg.Wait()
+	recordResults(results)
+	if metricsFile != "" {
+		writeMetricsFile(metricsFile)
+	}
+	publishEvents(results)
+	notifyResults(results)
+	emailResults(results)
+
+	code := 0
+	for _, r := range results {
+		c := exitCode(r.Err)
+		if c == 0 && r.Skipped > 0 {
+			c = exitWarnings
+		}
+		if severity(c) > severity(code) {
+			code = c
+		}
+	}
+	if len(list) > 1 {
+		printClusterSummary(results)
+	}
+	return code
+}
+
+func exitCode(err error) int {
+	switch {
+	case err == nil:
+		return 0
+	case errors.Is(err, errUploadFailed):
+		return exitUpload
+	case errors.Is(err, errDBConnect):
+		return exitDBConnect
+	case errors.Is(err, errArchive):
+		return exitArchive
+	case isLockHeld(err):
+		return exitLockHeld
+	case errors.Is(err, errTooSoon):
+		return exitTooSoon
+	}
+	return exitFailure
+}
+
+// severity: итоговый код нескольких кластеров — самый серьёзный из них.
+func severity(code int) int {
+	order := []int{exitFailure, exitArchive, exitDBConnect, exitUpload, exitWarnings, exitLockHeld, exitTooSoon}
+	for i, c := range order {
+		if c == code {
+			return len(order) - i
+		}
+	}
+	return 0
+}
+
+func isLockHeld(err error) bool { return errors.Is(err, errLockHeld) }
+
+// errTooSoon — прошлый бэкап свежее --min-backup-interval.
+var errTooSoon = errors.New("last backup is too recent")
+
+// isSkipped: прогон не делался намеренно — это не успех и не ошибка.
+func isSkipped(err error) bool { return isLockHeld(err) || errors.Is(err, errTooSoon) }
+
+// checkMinInterval не даёт серии случайных запусков вытеснить из окна
+// --copies нормальные архивы.
+func checkMinInterval(cl cluster) error {
+	if minBackupInterval <= 0 || forceBackup {
+		return nil
+	}
+	host, _ := os.Hostname()
+	var newest time.Time
+	for _, a := range localArchives(filepath.Join(backupPath, host, backupSubdir, cl.Name, "daily")) {
+		if info, err := os.Stat(a); err == nil && info.ModTime().After(newest) {
+			newest = info.ModTime()
+		}
+	}
+	if age := time.Since(newest); age < minBackupInterval {
+		log.Printf("%s⏭  %s: last backup %s ago, --min-backup-interval is %s — skipping (use --force)%s",
+			yellow, cl.Name, age.Round(time.Second), minBackupInterval, reset)
+		return fmt.Errorf("%w (%s ago)", errTooSoon, age.Round(time.Second))
+	}
+	return nil
+}
+
+func runClusterLocked(cl cluster) clusterResult {
+	res := clusterResult{Cluster: cl}
+	start := time.Now()
+	lock := lockPath(cl)
+	if res.Err = acquireLock(lock); res.Err != nil {
+		return res
+	}
+	defer releaseLock(lock)
+	setPhase(cl.Name, "connecting")
+	defer setPhase(cl.Name, "idle")
+	if logicalDump {
+		res.Archive, res.Err = runLogicalAll(cl)
+		res.Duration = time.Since(start)
+		return res
+	}
+	if res.Err = checkMinInterval(cl); res.Err != nil {
+		return res
+	}
+	if len(clusters) > 1 {
+		log.Printf("%s▶ Cluster %s%s", cyan, cl.Name, reset)
+	}
+	res.Archive, res.Skipped, res.Err = runBackup(cl)
+	res.Duration = time.Since(start)
+	if res.Err != nil {
+		logEvent(red, map[string]any{"cluster": cl.Name, "duration_seconds": res.Duration.Seconds(), "error": res.Err.Error()},
+			"Backup of %s failed: %v", cl.Name, res.Err)
+		return res
+	}
+	if res.Skipped > 0 {
+		logEvent(yellow, map[string]any{"cluster": cl.Name, "archive": res.Archive, "skipped_files": res.Skipped,
+			"size_bytes": archiveSize(res.Archive), "duration_seconds": res.Duration.Seconds()},
+			"🏁 %s backed up with warnings in %s: %d file(s) skipped", cl.Name, res.Duration.Round(time.Second), res.Skipped)
+		return res
+	}
+	logEvent(green, map[string]any{"cluster": cl.Name, "archive": res.Archive,
+		"size_bytes": archiveSize(res.Archive), "duration_seconds": res.Duration.Seconds()},
+		"🏁 %s backed up in %s", cl.Name, res.Duration.Round(time.Second))
+	return res
+}
+
+func printClusterSummary(results []clusterResult) {
+	log.Printf("%s📋 Summary:%s", cyan, reset)
+	for _, r := range results {
+		switch {
+		case r.Err == nil && r.Skipped > 0:
+			log.Printf("%s  ⚠️  %-20s %8s  %s (%d file(s) skipped)%s", yellow, r.Cluster.Name,
+				r.Duration.Round(time.Second), r.Archive, r.Skipped, reset)
+		case r.Err == nil:
+			log.Printf("%s  ✅ %-20s %8s  %s%s", green, r.Cluster.Name, r.Duration.Round(time.Second), r.Archive, reset)
+		case isSkipped(r.Err):
+			log.Printf("%s  ⏭  %-20s skipped: %v%s", yellow, r.Cluster.Name, r.Err, reset)
+		default:
+			log.Printf("%s  ❌ %-20s %v%s", red, r.Cluster.Name, r.Err, reset)
+		}
+	}
+}
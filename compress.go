@@ -0,0 +1,200 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/klauspost/pgzip"
+)
+
+/******************** COMPRESSION ********************/
+
+// Compressor — алгоритм сжатия архивов. tar пишется один раз, а каждый
+// формат — маленькая самостоятельная реализация.
+type Compressor interface {
+	NewWriter(w io.Writer) io.WriteCloser
+	NewReader(r io.Reader) (io.ReadCloser, error)
+	Extension() string // после ".tar", например ".gz"
+}
+
+// compressors — известные форматы; по ним же распознаются существующие
+// архивы, так что после смены --compression старые тоже ротируются.
+var compressors = []Compressor{gzipCompressor{}, zstdCompressor{}, noneCompressor{}}
+
+// compressor — формат новых архивов (--compression).
+var compressor Compressor = gzipCompressor{}
+
+// newCompressor разбирает --compression, --compression-level (0 — уровень
+// по умолчанию у алгоритма) и --compress-threads.
+func newCompressor(name string, level, threads int) (Compressor, error) {
+	if threads < 1 {
+		return nil, fmt.Errorf("--compress-threads must be at least 1")
+	}
+	switch name {
+	case "gzip":
+		if level != 0 && (level < gzip.BestSpeed || level > gzip.BestCompression) {
+			return nil, fmt.Errorf("gzip level must be 1..9")
+		}
+		return gzipCompressor{level, threads}, nil
+	case "zstd":
+		if level < 0 || level > 22 {
+			return nil, fmt.Errorf("zstd level must be 1..22")
+		}
+		return zstdCompressor{level, threads}, nil
+	case "none":
+		return noneCompressor{}, nil
+	}
+	return nil, fmt.Errorf("unknown compression %q (want gzip, zstd or none)", name)
+}
+
+// gzipCompressor: при threads > 1 поток режется на блоки по 1 МиБ, которые
+// сжимаются параллельно (pgzip); результат — обычный gzip. threads = 1 —
+// прежний однопоточный compress/gzip байт в байт.
+type gzipCompressor struct{ level, threads int }
+
+// pgzipBlock — размер блока параллельного gzip.
+const pgzipBlock = 1 << 20
+
+func (c gzipCompressor) NewWriter(w io.Writer) io.WriteCloser {
+	level := c.level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	if c.threads > 1 {
+		pw, _ := pgzip.NewWriterLevel(w, level) // уровень проверен в newCompressor
+		_ = pw.SetConcurrency(pgzipBlock, c.threads)
+		return pw
+	}
+	gw, _ := gzip.NewWriterLevel(w, level)
+	return gw
+}
+func (gzipCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+func (gzipCompressor) Extension() string { return ".gz" }
+
+type zstdCompressor struct{ level, threads int }
+
+func (c zstdCompressor) NewWriter(w io.Writer) io.WriteCloser {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(max(c.threads, 1))}
+	if c.level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(c.level)))
+	}
+	zw, _ := zstd.NewWriter(w, opts...) // ошибка только на неверных опциях
+	return zw
+}
+func (zstdCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	zr, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return zr.IOReadCloser(), nil
+}
+func (zstdCompressor) Extension() string { return ".zst" }
+
+// noneCompressor — голый tar.
+type noneCompressor struct{}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func (noneCompressor) NewWriter(w io.Writer) io.WriteCloser { return nopWriteCloser{w} }
+func (noneCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return io.NopCloser(r), nil
+}
+func (noneCompressor) Extension() string { return "" }
+
+// archiveKinds — содержимое архива: tar физического бэкапа или SQL-дамп
+// (--logical).
+var archiveKinds = []string{".tar", dumpKind, zipKind}
+
+const dumpKind = ".sql"
+
+// archiveExt — суффикс новых архивов: ".tar.gz", ".tar.zst" или ".tar",
+// с --encrypt-key-file плюс ".enc"; с --format zip — ".zip".
+func archiveExt() string {
+	if archiveContainer == "zip" {
+		return zipKind
+	}
+	return kindExt(".tar")
+}
+
+// dumpExt — то же для логических дампов: ".sql.gz" и т. д.
+func dumpExt() string { return kindExt(dumpKind) }
+
+func kindExt(kind string) string {
+	if encryptKeyFile != "" {
+		return kind + compressor.Extension() + encSuffix
+	}
+	if gpgPubkeyFile != "" {
+		return kind + compressor.Extension() + gpgSuffix
+	}
+	return kind + compressor.Extension()
+}
+
+// archiveFormat разбирает суффикс имени: вид содержимого и сжатие.
+func archiveFormat(name string) (kind string, c Compressor, ok bool) {
+	name = trimEncSuffix(name)
+	for _, k := range archiveKinds {
+		for _, c := range compressors {
+			if strings.HasSuffix(name, k+c.Extension()) {
+				return k, c, true
+			}
+		}
+	}
+	return "", nil, false
+}
+
+// compressorFor подбирает формат по имени файла (для чтения старых архивов);
+// по умолчанию — текущий.
+func compressorFor(name string) Compressor {
+	if _, c, ok := archiveFormat(name); ok {
+		return c
+	}
+	return compressor
+}
+
+// isArchiveFile: имя с суффиксом любого из известных форматов.
+func isArchiveFile(name string) bool {
+	_, _, ok := archiveFormat(name)
+	return ok
+}
+
+// isDump: логический дамп, а не tar.
+func isDump(name string) bool {
+	kind, _, _ := archiveFormat(name)
+	return kind == dumpKind
+}
+
+// globArchives — архивы всех форматов в dir (dir может быть шаблоном).
+func globArchives(dir string) []string {
+	var out []string
+	for _, k := range archiveKinds {
+		for _, c := range compressors {
+			m, _ := filepath.Glob(filepath.Join(dir, "*"+k+c.Extension()))
+			e, _ := filepath.Glob(filepath.Join(dir, "*"+k+c.Extension()+encSuffix))
+			g, _ := filepath.Glob(filepath.Join(dir, "*"+k+c.Extension()+gpgSuffix))
+			out = append(append(append(out, m...), e...), g...)
+		}
+	}
+	return out
+}
+
+// trimEncSuffix — имя без .enc или .gpg.
+func trimEncSuffix(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, encSuffix), gpgSuffix)
+}
+
+// archiveStem — имя архива без суффикса формата.
+func archiveStem(name string) string {
+	name = trimEncSuffix(name)
+	if kind, c, ok := archiveFormat(name); ok {
+		return strings.TrimSuffix(name, kind+c.Extension())
+	}
+	return name
+}
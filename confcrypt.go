@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+)
+
+/******************** ENCRYPTED FTP CONF ********************/
+
+// confKeyEnv — ключ для зашифрованного --ftp-conf: секретный ключ age
+// (AGE-SECRET-KEY-1…) или пароль, если файл шифровали через age -p.
+const confKeyEnv = "POSTGRESQL_BACKUP_CONF_KEY"
+
+// openFTPConf возвращает содержимое --ftp-conf; файлы age (двоичные или
+// armored) расшифровываются в памяти, остальные читаются как есть.
+func openFTPConf(path string) (io.Reader, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var src io.Reader
+	switch {
+	case bytes.HasPrefix(data, []byte("age-encryption.org/v1\n")):
+		src = bytes.NewReader(data)
+	case bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header)):
+		src = armor.NewReader(bytes.NewReader(bytes.TrimSpace(data)))
+	default:
+		return bytes.NewReader(data), nil
+	}
+	ids, err := confIdentities()
+	if err != nil {
+		return nil, err
+	}
+	r, err := age.Decrypt(src, ids...)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt: %w", err)
+	}
+	return r, nil
+}
+
+// confEncrypted: файл зашифрован age (двоичный или armored).
+func confEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("age-encryption.org/v1\n")) ||
+		bytes.HasPrefix(bytes.TrimSpace(data), []byte(armor.Header))
+}
+
+// confIdentities берёт ключи из --conf-key-file, иначе из окружения.
+func confIdentities() ([]age.Identity, error) {
+	if confKeyFile != "" {
+		f, err := os.Open(confKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return age.ParseIdentities(bufio.NewReader(f))
+	}
+	key := strings.TrimSpace(os.Getenv(confKeyEnv))
+	if key == "" {
+		return nil, errors.New("file is encrypted: set --conf-key-file or " + confKeyEnv)
+	}
+	if strings.HasPrefix(key, "AGE-SECRET-KEY-") {
+		return age.ParseIdentities(strings.NewReader(key))
+	}
+	id, err := age.NewScryptIdentity(key)
+	if err != nil {
+		return nil, err
+	}
+	return []age.Identity{id}, nil
+}
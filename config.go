@@ -0,0 +1,174 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+/******************** CONFIG FILE ********************/
+
+// --config: YAML, ключи — имена флагов без «--»; повторяемые флаги
+// (cluster, exclude, …) принимают список. Флаг из командной строки
+// важнее значения из файла. FTP-цели задаются списком ftp, иначе
+// по-старому — через --ftp-conf.
+//
+//	backup-path: /backup
+//	copies: 7
+//	cluster:
+//	  - main=host=/var/run/postgresql port=5432 user=postgres
+//	ftp:
+//	  - {host: ftp1.example.com, user: backup, pass: secret, tls: true}
+//	s3-bucket: pg-backups
+
+var (
+	configFile string
+	configFTP  []ftpAccount // ftp: из --config
+)
+
+// loadConfig применяет --config к флагам, не заданным в командной строке.
+// Все неизвестные ключи перечисляются в одной ошибке.
+func loadConfig(path string) error {
+	raw, err := readConfig(path)
+	if err != nil {
+		return err
+	}
+	explicit := explicitFlags()
+
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var unknown []string
+	for _, key := range keys {
+		if key == "ftp" {
+			accs, err := configFTPAccounts(raw[key])
+			if err != nil {
+				return fmt.Errorf("%s: ftp: %w", path, err)
+			}
+			if !explicit["ftp-conf"] && !explicit["ftp-host"] {
+				configFTP = accs
+			}
+			continue
+		}
+		fl := flag.Lookup(key)
+		if fl == nil || key == "config" {
+			unknown = append(unknown, key)
+			continue
+		}
+		if explicit[key] {
+			continue
+		}
+		values := []any{raw[key]}
+		if list, ok := raw[key].([]any); ok {
+			values = list
+		}
+		for _, v := range values {
+			if !isScalar(v) {
+				return fmt.Errorf("%s: %s: want a value or a list of values", path, key)
+			}
+			if err := fl.Value.Set(fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("%s: %s: %w", path, key, err)
+			}
+		}
+	}
+	if len(unknown) > 0 {
+		return fmt.Errorf("%s: unknown key(s): %s", path, strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// readConfig читает YAML --config; тот же формат шифрования age, что
+// и у --ftp-conf.
+func readConfig(path string) (map[string]any, error) {
+	f, err := openFTPConf(path)
+	if err != nil {
+		return nil, err
+	}
+	var raw map[string]any
+	if err := yaml.NewDecoder(f).Decode(&raw); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return raw, nil
+}
+
+// explicitFlags — флаги, заданные в командной строке.
+func explicitFlags() map[string]bool {
+	explicit := map[string]bool{}
+	flag.Visit(func(fl *flag.Flag) { explicit[fl.Name] = true })
+	return explicit
+}
+
+// reloadConfigFTP — список ftp: из --config заново, по тем же правилам,
+// что и в loadConfig (nil — списка нет или его перекрывает командная строка).
+func reloadConfigFTP(path string) ([]ftpAccount, error) {
+	raw, err := readConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	explicit := explicitFlags()
+	v, ok := raw["ftp"]
+	if !ok || explicit["ftp-conf"] || explicit["ftp-host"] {
+		return nil, nil
+	}
+	accs, err := configFTPAccounts(v)
+	if err != nil {
+		return nil, fmt.Errorf("%s: ftp: %w", path, err)
+	}
+	return accs, nil
+}
+
+func isScalar(v any) bool {
+	switch v.(type) {
+	case string, int, float64, bool:
+		return true
+	}
+	return false
+}
+
+// configFTPAccounts разбирает список ftp: host, user, pass, port, tls.
+func configFTPAccounts(v any) ([]ftpAccount, error) {
+	list, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("want a list of accounts")
+	}
+	var accs []ftpAccount
+	for i, item := range list {
+		m, ok := item.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("#%d: want host, user, pass, port, tls", i+1)
+		}
+		var acc ftpAccount
+		for k, val := range m {
+			var ok bool
+			switch k {
+			case "host":
+				acc.Host, ok = val.(string)
+			case "user":
+				acc.User, ok = val.(string)
+			case "pass":
+				acc.Pass, ok = val.(string)
+			case "port":
+				acc.Port, ok = val.(int)
+				ok = ok && acc.Port >= 1 && acc.Port <= 65535
+			case "tls":
+				acc.TLS, ok = val.(bool)
+			default:
+				return nil, fmt.Errorf("#%d: unknown key %s", i+1, k)
+			}
+			if !ok {
+				return nil, fmt.Errorf("#%d: bad %s %v", i+1, k, val)
+			}
+		}
+		if acc.Host == "" || acc.User == "" || acc.Pass == "" {
+			return nil, fmt.Errorf("#%d: host, user and pass are required", i+1)
+		}
+		accs = append(accs, acc)
+	}
+	return accs, nil
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+/******************** DEDUP ********************/
+
+// --dedup-dir: общий пул блобов по SHA-256. Файл от --dedup-min-size
+// кладётся в пул один раз (<пул>/ab/<sha256>.blob<сжатие>), а в архив
+// попадает пустая запись с PAX-ссылкой PGBACKUP.dedup=<sha256>. Рядом
+// с архивом <архив>.dedup — его хеши: по ним пул чистится от блобов,
+// на которые больше не ссылается ни один архив под --backup-path.
+// --restore собирает файлы обратно из пула (нужен тот же --dedup-dir).
+var (
+	dedupDir     string
+	dedupMinSize sizeFlag = 1 << 20
+)
+
+const (
+	dedupSuffix = ".dedup"
+	dedupPAXKey = "PGBACKUP.dedup"
+	blobKind    = ".blob"
+	// блоб, не тронутый сутки до старта прогона и без ссылок, — мусор;
+	// запас на параллельные прогоны, которые ещё не записали .dedup
+	dedupGrace = 24 * time.Hour
+)
+
+func blobPath(sum string) string {
+	return filepath.Join(dedupDir, sum[:2], sum+kindExt(blobKind))
+}
+
+// findBlob ищет блоб в любом формате: сжатие могли сменить между прогонами.
+func findBlob(sum string) string {
+	m, _ := filepath.Glob(filepath.Join(dedupDir, sum[:2], sum+blobKind+"*"))
+	for _, p := range m {
+		if !strings.HasSuffix(p, partialSuffix) {
+			return p
+		}
+	}
+	return ""
+}
+
+// dedupFile возвращает хеш первых size байт f и гарантирует, что такой
+// блоб есть в пуле. Неизменный файл читается один раз и не пишется.
+func dedupFile(f *os.File, size int64, buf []byte) (string, error) {
+	h := sha256.New()
+	if _, err := copyBounded(h, f, size, buf); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+	if p := findBlob(sum); p != "" {
+		now := time.Now()
+		_ = os.Chtimes(p, now, now) // используется — чистка не тронет
+		return sum, nil
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return storeBlob(f, size, buf)
+}
+
+// storeBlob пишет файл в пул и возвращает хеш записанного: горячий файл
+// мог измениться после первого чтения — ссылаемся на то, что сохранили.
+func storeBlob(f *os.File, size int64, buf []byte) (sum string, err error) {
+	if err := os.MkdirAll(dedupDir, os.FileMode(dirMode)); err != nil {
+		return "", err
+	}
+	tmp, err := os.CreateTemp(dedupDir, ".blob-*"+partialSuffix)
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		tmp.Close()
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+		}
+	}()
+	w, err := newArchiveWriter(tmp)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	if _, err := copyBounded(io.MultiWriter(w, h), f, size, buf); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	if err := tmp.Sync(); err != nil {
+		return "", err
+	}
+	sum = hex.EncodeToString(h.Sum(nil))
+	if findBlob(sum) != "" {
+		return sum, os.Remove(tmp.Name())
+	}
+	dst := blobPath(sum)
+	if err := os.MkdirAll(filepath.Dir(dst), os.FileMode(dirMode)); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		return "", err
+	}
+	chownBackup(dst)
+	return sum, syncDir(filepath.Dir(dst))
+}
+
+// openBlob открывает блоб на чтение: расшифровка и распаковка по суффиксу.
+func openBlob(sum string) (io.ReadCloser, error) {
+	if dedupDir == "" {
+		return nil, errors.New("archive references deduplicated files: pass --dedup-dir")
+	}
+	p := findBlob(sum)
+	if p == "" {
+		return nil, fmt.Errorf("blob %s is missing from %s", sum, dedupDir)
+	}
+	return openCompressed(p, blobKind)
+}
+
+// openCompressed открывает файл вида <имя><kind><сжатие>[.enc] на чтение.
+func openCompressed(p, kind string) (io.ReadCloser, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	var r io.Reader = f
+	name := strings.TrimSuffix(p, partialSuffix)
+	if strings.HasSuffix(name, encSuffix) {
+		if r, err = newEncReader(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		name = strings.TrimSuffix(name, encSuffix)
+	}
+	c := compressor
+	for _, cc := range compressors {
+		if strings.HasSuffix(name, kind+cc.Extension()) {
+			c = cc
+			break
+		}
+	}
+	zr, err := c.NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decompress %s: %w", p, err)
+	}
+	return readCloser{zr, f}, nil
+}
+
+// writeDedupList сохраняет хеши блобов архива для чистки пула.
+func writeDedupList(archive string, st *archiveStats) {
+	if len(st.Blobs) == 0 {
+		return
+	}
+	p := archive + dedupSuffix
+	if err := os.WriteFile(p, []byte(strings.Join(st.Blobs, "\n")+"\n"), 0o600); err != nil {
+		log.Printf("%sCannot write %s: %v — its blobs may be pruned from %s%s", red, p, err, dedupDir, reset)
+		return
+	}
+	chownBackup(p)
+}
+
+// pruneDedupPool удаляет блобы, на которые не ссылается ни один .dedup
+// под --backup-path. Если какой-то список не прочитался — не чистим:
+// удалить нужный блоб хуже, чем подержать лишний.
+func pruneDedupPool(started time.Time) {
+	if dedupDir == "" || dryRun {
+		return
+	}
+	refs := map[string]bool{}
+	err := filepath.WalkDir(backupPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && p == filepath.Clean(dedupDir) {
+			return filepath.SkipDir
+		}
+		if d.IsDir() || !strings.HasSuffix(p, dedupSuffix) {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		sc := bufio.NewScanner(f)
+		for sc.Scan() {
+			refs[sc.Text()] = true
+		}
+		return sc.Err()
+	})
+	if err != nil {
+		log.Printf("%s⚠️  --dedup-dir: %v — not pruning the blob pool%s", yellow, err, reset)
+		return
+	}
+	cutoff := started.Add(-dedupGrace)
+	removed, freed := 0, int64(0)
+	_ = filepath.WalkDir(dedupDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil || !info.ModTime().Before(cutoff) {
+			return nil
+		}
+		sum, _, _ := strings.Cut(d.Name(), ".")
+		if refs[sum] {
+			return nil
+		}
+		if os.Remove(p) == nil {
+			removed++
+			freed += info.Size()
+		}
+		return nil
+	})
+	if removed > 0 {
+		log.Printf("%s♻️  Pruned %d unreferenced blob(s), %.2f GB from %s%s", cyan, removed, gb(freed), dedupDir, reset)
+	}
+}
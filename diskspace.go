@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"path/filepath"
+)
+
+/******************** DISK SPACE ********************/
+
+// minFreeSpace — --min-free-space: сколько места должно остаться на
+// файловой системе бэкапов после архива.
+var minFreeSpace sizeFlag
+
+// checkFreeSpace сравнивает объём того, что попадёт в архив (без сжатия —
+// оценка сверху), со свободным местом в dir. Иначе архив оборвётся на
+// середине, когда диск кончится. Инкременты малы — их не проверяем.
+func checkFreeSpace(dataDir, dir string, opts archiveOpts) error {
+	if sinceLSN > 0 || opts.Base != nil {
+		return nil
+	}
+	_, free, err := diskUsage(dir)
+	if err != nil {
+		log.Printf("%s⚠️  Cannot check free space in %s: %v%s", yellow, dir, err, reset)
+		return nil
+	}
+	need := dataDirSize(dataDir, opts) + int64(minFreeSpace)
+	if free < need {
+		return fmt.Errorf("not enough space in %s: %.2f GB free, need %.2f GB (data directory %.2f GB + --min-free-space %.2f GB)",
+			dir, gb(free), gb(need), gb(need-int64(minFreeSpace)), gb(int64(minFreeSpace)))
+	}
+	return nil
+}
+
+func gb(n int64) float64 { return float64(n) / (1 << 30) }
+
+// dataDirSize — сумма размеров файлов, которые архивирует createTarGzFromDir.
+func dataDirSize(dataDir string, opts archiveOpts) int64 {
+	var total int64
+	_ = filepath.WalkDir(dataDir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(dataDir, p)
+		if rel == "." {
+			return nil
+		}
+		if d.IsDir() {
+			if isExcluded(rel, true) || excludedDBDir(rel, opts.IncludeOIDs) ||
+				isExcludedDir(rel) && !(opts.KeepWAL && rel == "pg_wal") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if isExcluded(rel, false) {
+			return nil
+		}
+		if info, err := d.Info(); err == nil && info.Mode().IsRegular() {
+			total += info.Size()
+		}
+		return nil
+	})
+	for _, loc := range opts.Tablespaces {
+		total += archiveSize(loc)
+	}
+	return total
+}
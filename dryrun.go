@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+/******************** DRY RUN ********************/
+
+// --dry-run: показать, что сделал бы прогон, ничего не меняя. Соединение
+// с PostgreSQL только читает (pg_backup_start/stop не вызываются), data
+// directory обходится без чтения файлов, ротация и удаления только
+// логируются, к FTP/S3/SFTP не подключаемся. lock, --metrics-file,
+// --event-url и --record-in-db в этом режиме выключены (см. main).
+var dryRun bool
+
+func dryRunTag() string {
+	if dryRun {
+		return "[dry-run] "
+	}
+	return ""
+}
+
+// dryRunBackup заменяет шаги 3–7 runBackup.
+func dryRunBackup(cl cluster, dataDir, host string, now time.Time, opts archiveOpts) (string, error) {
+	base := filepath.Join(backupPath, host, backupSubdir, cl.Name)
+	archive := archivePathFor(filepath.Join(base, "daily"), now, opts.Base != nil)
+	opts.Stream, opts.Stop = nil, nil
+	log.Printf("%s🧪 [dry-run] Would archive %s into %s:%s", cyan, dataDir, archive, reset)
+	st, err := createTarGzFromDir(archive, dataDir, opts)
+	if err != nil {
+		return "", fmt.Errorf("dry run: %w", err)
+	}
+	log.Printf("%s🧪 [dry-run] %d file(s), %.2f MB before compression%s", cyan, st.Files,
+		float64(st.Bytes)/(1024*1024), reset)
+	// копии в weekly/monthly/yearly и удаления печатает сама ротация
+	rotateTiers(archive, base, now, sinceLSN == 0 && opts.Base == nil)
+	dryRunUploads(archive)
+	return archive, nil
+}
+
+func dryRunUploads(archive string) {
+	rel := ftpRemoteRel(archive)
+	for _, acc := range ftpAccounts {
+		log.Printf("%s🧪 [dry-run] Would upload to FTP %s: %s, then rotate there%s", cyan, acc.Host, filepath.ToSlash(rel), reset)
+	}
+	if s3Enabled {
+		log.Printf("%s🧪 [dry-run] Would upload to %s: %s, then rotate there%s", cyan, s3Target(), s3Key(rel), reset)
+	}
+	if sftpEnabled {
+		log.Printf("%s🧪 [dry-run] Would upload to %s: %s, then rotate there%s", cyan, sftpTarget(),
+			path.Join(sftpDir, filepath.ToSlash(rel)), reset)
+	}
+	if rsyncEnabled {
+		log.Printf("%s🧪 [dry-run] Would upload to %s: %s, then rotate there%s", cyan, rsyncTargetID(),
+			filepath.ToSlash(rel), reset)
+	}
+	if len(ftpAccounts) == 0 && !s3Enabled && !sftpEnabled && !rsyncEnabled {
+		log.Printf("%s🧪 [dry-run] No upload targets configured%s", cyan, reset)
+	}
+}
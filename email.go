@@ -0,0 +1,182 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************** EMAIL ********************/
+
+// --smtp-host: письмо, если бэкап или загрузка какого-либо кластера упали
+// (с --email-on-success — после каждого прогона): ошибки и последние
+// строки лога. STARTTLS, если сервер его предлагает; порт 465 — сразу TLS.
+var (
+	smtpHost       string
+	smtpPort       int
+	smtpUser       string
+	smtpPass       string
+	smtpFrom       string
+	smtpTo         string // через запятую
+	emailOnSuccess bool
+)
+
+// emailLogLines — сколько последних строк лога попадает в письмо.
+const emailLogLines = 50
+
+// logTail помнит последние строки лога для письма.
+type logTail struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+var emailTail logTail
+
+func (t *logTail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, l := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		t.lines = append(t.lines, ansiRe.ReplaceAllString(l, ""))
+	}
+	if n := len(t.lines) - emailLogLines; n > 0 {
+		t.lines = append(t.lines[:0], t.lines[n:]...)
+	}
+	return len(p), nil
+}
+
+func (t *logTail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(t.lines, "\n")
+}
+
+func checkSMTPFlags() error {
+	if smtpHost == "" {
+		return nil
+	}
+	if smtpFrom == "" || smtpTo == "" {
+		return fmt.Errorf("--smtp-host needs --smtp-from and --smtp-to")
+	}
+	if smtpPort < 1 || smtpPort > 65535 {
+		return fmt.Errorf("--smtp-port %d: not a port number", smtpPort)
+	}
+	return nil
+}
+
+// emailResults отправляет одно письмо на прогон. Ошибки только логируются.
+func emailResults(results []clusterResult) {
+	if smtpHost == "" {
+		return
+	}
+	host, _ := os.Hostname()
+	var failed, warned []string
+	var b strings.Builder
+	now := time.Now()
+	for _, r := range results {
+		s := summarize(r, now)
+		switch s.Status {
+		case "failed":
+			failed = append(failed, s.Cluster)
+			fmt.Fprintf(&b, "❌ %s: %s\n", s.Cluster, s.Error)
+		case "ok":
+			fmt.Fprintf(&b, "✅ %s: %s (%.2f MB) in %s\n", s.Cluster, s.Archive,
+				float64(s.Bytes)/(1024*1024), r.Duration.Round(time.Second))
+		case "warning":
+			warned = append(warned, s.Cluster)
+			fmt.Fprintf(&b, "⚠️ %s: %s (%.2f MB) in %s, %d file(s) skipped — see skipped_files.txt\n", s.Cluster, s.Archive,
+				float64(s.Bytes)/(1024*1024), r.Duration.Round(time.Second), s.SkippedFiles)
+		default:
+			fmt.Fprintf(&b, "⏭ %s: %s\n", s.Cluster, s.Error)
+		}
+	}
+	if len(failed) == 0 && len(warned) == 0 && !emailOnSuccess {
+		return
+	}
+	subject := fmt.Sprintf("[postgresql-backup] %s: backup OK", host)
+	if len(warned) > 0 {
+		subject = fmt.Sprintf("[postgresql-backup] %s: backup OK with warnings (%s)", host, strings.Join(warned, ", "))
+	}
+	if len(failed) > 0 {
+		subject = fmt.Sprintf("[postgresql-backup] %s: backup FAILED (%s)", host, strings.Join(failed, ", "))
+	}
+	fmt.Fprintf(&b, "\nLast log lines:\n\n%s\n", emailTail.String())
+	if err := sendMail(subject, b.String()); err != nil {
+		log.Printf("%sEmail to %s failed: %v%s", yellow, smtpTo, err, reset)
+		return
+	}
+	log.Printf("%s📧 Report emailed to %s%s", cyan, smtpTo, reset)
+}
+
+func sendMail(subject, body string) error {
+	addr := net.JoinHostPort(smtpHost, strconv.Itoa(smtpPort))
+	tlsConf := &tls.Config{ServerName: smtpHost}
+	var conn net.Conn
+	var err error
+	if smtpPort == 465 {
+		conn, err = tls.DialWithDialer(&net.Dialer{Timeout: 30 * time.Second}, "tcp", addr, tlsConf)
+	} else {
+		conn, err = net.DialTimeout("tcp", addr, 30*time.Second)
+	}
+	if err != nil {
+		return err
+	}
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Minute))
+	c, err := smtp.NewClient(conn, smtpHost)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if ok, _ := c.Extension("STARTTLS"); ok && smtpPort != 465 {
+		if err := c.StartTLS(tlsConf); err != nil {
+			return err
+		}
+	}
+	if smtpUser != "" {
+		// PlainAuth сам откажется слать пароль без TLS (кроме localhost)
+		if err := c.Auth(smtp.PlainAuth("", smtpUser, smtpPass, smtpHost)); err != nil {
+			return err
+		}
+	}
+	var to []string
+	for _, t := range strings.Split(smtpTo, ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			to = append(to, t)
+		}
+	}
+	if err := c.Mail(smtpFrom); err != nil {
+		return err
+	}
+	for _, t := range to {
+		if err := c.Rcpt(t); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	msg := "From: " + smtpFrom + "\r\n" +
+		"To: " + strings.Join(to, ", ") + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"Content-Transfer-Encoding: 8bit\r\n\r\n" +
+		strings.ReplaceAll(body, "\n", "\r\n")
+	if _, err := w.Write([]byte(msg)); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+)
+
+/******************** ENCRYPTION ********************/
+
+// Архив .enc: magic, 12 байт случайного nonce, затем записи
+// «uint32 длина шифротекста + AES-256-GCM(кусок до 64 КиБ)». Nonce записи —
+// базовый XOR номер записи, AAD — признак последней записи: перестановка
+// и обрезка файла не пройдут проверку.
+const (
+	encSuffix   = ".enc"
+	encMagic    = "PGBACKUP-AES256GCM1\n"
+	encChunk    = 64 << 10
+	encNonceLen = 12
+)
+
+var (
+	encKeyOnce sync.Once
+	encKey     []byte
+	encKeyErr  error
+)
+
+// archiveKey читает --encrypt-key-file: 32 байта как есть или 64 hex-символа.
+func archiveKey() ([]byte, error) {
+	encKeyOnce.Do(func() {
+		if encryptKeyFile == "" {
+			encKeyErr = errors.New("encrypted archive: --encrypt-key-file is required")
+			return
+		}
+		data, err := os.ReadFile(encryptKeyFile)
+		if err != nil {
+			encKeyErr = err
+			return
+		}
+		if len(data) == 32 {
+			encKey = data
+			return
+		}
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			encKeyErr = fmt.Errorf("%s: want 32 raw bytes or 64 hex characters", encryptKeyFile)
+			return
+		}
+		encKey = key
+	})
+	return encKey, encKeyErr
+}
+
+func newGCM() (cipher.AEAD, error) {
+	key, err := archiveKey()
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func chunkNonce(base []byte, n uint64) []byte {
+	nonce := append([]byte(nil), base...)
+	ctr := binary.BigEndian.Uint64(nonce[4:]) ^ n
+	binary.BigEndian.PutUint64(nonce[4:], ctr)
+	return nonce
+}
+
+// encWriter шифрует поток кусками; Close дописывает последнюю запись.
+type encWriter struct {
+	w     io.Writer
+	aead  cipher.AEAD
+	nonce []byte
+	n     uint64
+	buf   []byte
+}
+
+func newEncWriter(w io.Writer) (*encWriter, error) {
+	aead, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, encNonceLen)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(w, encMagic); err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(nonce); err != nil {
+		return nil, err
+	}
+	return &encWriter{w: w, aead: aead, nonce: nonce, buf: make([]byte, 0, encChunk)}, nil
+}
+
+func (e *encWriter) Write(p []byte) (int, error) {
+	total := len(p)
+	for len(p) > 0 {
+		k := min(encChunk-len(e.buf), len(p))
+		e.buf = append(e.buf, p[:k]...)
+		p = p[k:]
+		if len(e.buf) == encChunk {
+			if err := e.seal(false); err != nil {
+				return total - len(p), err
+			}
+		}
+	}
+	return total, nil
+}
+
+func (e *encWriter) seal(final bool) error {
+	aad := []byte{0}
+	if final {
+		aad[0] = 1
+	}
+	ct := e.aead.Seal(nil, chunkNonce(e.nonce, e.n), e.buf, aad)
+	e.n++
+	e.buf = e.buf[:0]
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(len(ct)))
+	if _, err := e.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := e.w.Write(ct)
+	return err
+}
+
+func (e *encWriter) Close() error { return e.seal(true) }
+
+// encReader расшифровывает и проверяет каждую запись.
+type encReader struct {
+	r     *bufio.Reader
+	aead  cipher.AEAD
+	nonce []byte
+	n     uint64
+	plain []byte
+	done  bool
+}
+
+func newEncReader(r io.Reader) (*encReader, error) {
+	aead, err := newGCM()
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	head := make([]byte, len(encMagic)+encNonceLen)
+	if _, err := io.ReadFull(br, head); err != nil || string(head[:len(encMagic)]) != encMagic {
+		return nil, errors.New("not an encrypted archive")
+	}
+	return &encReader{r: br, aead: aead, nonce: head[len(encMagic):]}, nil
+}
+
+func (d *encReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		var hdr [4]byte
+		if _, err := io.ReadFull(d.r, hdr[:]); err != nil {
+			return 0, fmt.Errorf("encrypted archive is truncated: %w", err)
+		}
+		size := binary.BigEndian.Uint32(hdr[:])
+		if size > encChunk+uint32(d.aead.Overhead()) {
+			return 0, errors.New("encrypted archive: bad record length")
+		}
+		ct := make([]byte, size)
+		if _, err := io.ReadFull(d.r, ct); err != nil {
+			return 0, fmt.Errorf("encrypted archive is truncated: %w", err)
+		}
+		nonce := chunkNonce(d.nonce, d.n)
+		plain, err := d.aead.Open(nil, nonce, ct, []byte{0})
+		if err != nil {
+			if plain, err = d.aead.Open(nil, nonce, ct, []byte{1}); err != nil {
+				return 0, errors.New("encrypted archive: authentication failed (wrong key or corrupted data)")
+			}
+			d.done = true
+		}
+		d.n++
+		d.plain = plain
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+// archiveWriter — цепочка записи архива: сжатие и, с --encrypt-key-file
+// или --gpg-pubkey-file, шифрование поверх. Close закрывает оба слоя по порядку.
+type archiveWriter struct {
+	io.WriteCloser
+	enc io.Closer
+}
+
+func (a archiveWriter) Close() error {
+	err := a.WriteCloser.Close()
+	if a.enc != nil {
+		if e := a.enc.Close(); err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func newArchiveWriter(w io.Writer) (io.WriteCloser, error) {
+	if gpgPubkeyFile != "" {
+		enc, err := newGPGWriter(w)
+		if err != nil {
+			return nil, err
+		}
+		return archiveWriter{compressor.NewWriter(enc), enc}, nil
+	}
+	if encryptKeyFile == "" {
+		return compressor.NewWriter(w), nil
+	}
+	enc, err := newEncWriter(w)
+	if err != nil {
+		return nil, err
+	}
+	return archiveWriter{compressor.NewWriter(enc), enc}, nil
+}
+
+// openArchive открывает архив для чтения tar: расшифровка (.enc) и
+// распаковка по суффиксу имени.
+func openArchive(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasSuffix(path, gpgSuffix) {
+		f.Close()
+		return nil, errGPGArchive
+	}
+	if kind, _, _ := archiveFormat(path); kind == zipKind {
+		f.Close()
+		return openZipArchive(path)
+	}
+	var r io.Reader = f
+	if strings.HasSuffix(path, encSuffix) {
+		if r, err = newEncReader(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+	}
+	zr, err := compressorFor(strings.TrimSuffix(path, encSuffix)).NewReader(r)
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("decompress: %w", err)
+	}
+	return readCloser{zr, f}, nil
+}
+
+type readCloser struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (r readCloser) Close() error {
+	_ = r.ReadCloser.Close()
+	return r.f.Close()
+}
+
+// decryptArchive — --decrypt: .enc → исходный архив (рядом или в dest).
+func decryptArchive(path, dest string) int {
+	if !strings.HasSuffix(path, encSuffix) {
+		log.Printf("%s--decrypt expects a %s file%s", red, encSuffix, reset)
+		return exitFailure
+	}
+	if dest == "" {
+		dest = strings.TrimSuffix(path, encSuffix)
+	}
+	in, err := os.Open(path)
+	if err != nil {
+		log.Printf("%s%v%s", red, err, reset)
+		return exitFailure
+	}
+	defer in.Close()
+	r, err := newEncReader(in)
+	if err == nil {
+		var out *os.File
+		if out, err = os.OpenFile(dest, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600); err == nil {
+			_, err = io.Copy(out, r)
+			if e := out.Close(); err == nil {
+				err = e
+			}
+			if err != nil {
+				os.Remove(dest)
+			}
+		}
+	}
+	if err != nil {
+		log.Printf("%s%s: %v%s", red, path, err, reset)
+		return exitFailure
+	}
+	log.Printf("%s🔓 Decrypted to %s%s", green, dest, reset)
+	return 0
+}
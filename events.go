@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/segmentio/kafka-go"
+)
+
+/******************** EVENTS ********************/
+
+// backupSummary — итог прогона одного кластера в JSON; одна и та же
+// структура для всех внешних интеграций.
+type backupSummary struct {
+	Host        string    `json:"host"`
+	Cluster     string    `json:"cluster"`
+	Status      string    `json:"status"` // ok | warning | failed | skipped
+	Archive     string    `json:"archive,omitempty"`
+	Bytes       int64     `json:"bytes,omitempty"`
+	Started     time.Time `json:"started"`
+	Finished    time.Time `json:"finished"`
+	DurationSec float64   `json:"duration_sec"`
+	Error       string    `json:"error,omitempty"`
+	// SkippedFiles — --best-effort: сколько файлов не попало в архив
+	SkippedFiles int `json:"skipped_files,omitempty"`
+}
+
+func summarize(r clusterResult, finished time.Time) backupSummary {
+	host, _ := os.Hostname()
+	s := backupSummary{
+		Host: host, Cluster: r.Cluster.Name, Status: "ok", Archive: r.Archive,
+		Started: finished.Add(-r.Duration), Finished: finished,
+		DurationSec: r.Duration.Seconds(),
+	}
+	switch {
+	case r.Err == nil:
+		s.Bytes = archiveSize(r.Archive)
+		if r.Skipped > 0 {
+			s.Status, s.SkippedFiles = "warning", r.Skipped
+		}
+	case isSkipped(r.Err):
+		s.Status, s.Error = "skipped", r.Err.Error()
+	default:
+		s.Status, s.Error = "failed", r.Err.Error()
+	}
+	return s
+}
+
+// publishEvents отправляет по событию на кластер в NATS (nats://host:4222)
+// или Kafka (kafka://broker1:9092,broker2:9092). Ошибки только логируются:
+// бэкап уже сделан, очередь — дело вторичное.
+func publishEvents(results []clusterResult) {
+	if eventURL == "" {
+		return
+	}
+	now := time.Now()
+	var payloads [][]byte
+	var keys []string
+	for _, r := range results {
+		b, _ := json.Marshal(summarize(r, now))
+		payloads = append(payloads, b)
+		keys = append(keys, r.Cluster.Name)
+	}
+	if err := publish(payloads, keys); err != nil {
+		log.Printf("%sEvent publish to %s failed: %v%s", yellow, eventURL, err, reset)
+		return
+	}
+	log.Printf("%s📨 Published %d event(s) to %s%s", cyan, len(payloads), eventTopic, reset)
+}
+
+func publish(payloads [][]byte, keys []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	switch {
+	case strings.HasPrefix(eventURL, "nats://"), strings.HasPrefix(eventURL, "tls://"):
+		nc, err := nats.Connect(eventURL, nats.Timeout(10*time.Second), nats.Name("postgresql-backup"))
+		if err != nil {
+			return err
+		}
+		defer nc.Close()
+		for _, p := range payloads {
+			if err := nc.Publish(eventTopic, p); err != nil {
+				return err
+			}
+		}
+		return nc.FlushWithContext(ctx)
+	case strings.HasPrefix(eventURL, "kafka://"):
+		brokers := strings.Split(strings.TrimPrefix(eventURL, "kafka://"), ",")
+		w := &kafka.Writer{
+			Addr:         kafka.TCP(brokers...),
+			Topic:        eventTopic,
+			RequiredAcks: kafka.RequireOne,
+		}
+		defer w.Close()
+		msgs := make([]kafka.Message, len(payloads))
+		for i, p := range payloads {
+			msgs[i] = kafka.Message{Key: []byte(keys[i]), Value: p}
+		}
+		return w.WriteMessages(ctx, msgs...)
+	}
+	return fmt.Errorf("unsupported scheme (want nats:// or kafka://)")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// defaultExcludes — каталоги, содержимое которых при восстановлении не
+// нужно или вредно (тот же список, что у pg_basebackup): pgsql_tmp
+// (сортировки, hash join), pg_stat_tmp, слоты репликации, SLRU-кеши,
+// pg_wal (WAL для recovery берётся из архива WAL). Сам каталог в архив
+// попадает пустым, чтобы после восстановления он был.
+var defaultExcludes = []string{
+	"pgsql_tmp", "pg_stat_tmp", "pg_wal", "pg_replslot", "pg_dynshmem",
+	"pg_notify", "pg_serial", "pg_snapshots", "pg_subtrans",
+}
+
+// defaultExcludeFiles — файлы работающего сервера: с ними восстановленный
+// кластер решит, что postmaster ещё жив, или поднимет устаревший relcache.
+// backup_label/tablespace_map в data directory — остатки чужого exclusive
+// бэкапа: настоящие дописываются в архив из pg_backup_stop.
+var defaultExcludeFiles = []string{
+	"postmaster.pid", "postmaster.opts", "pg_internal.init", "backup_label", "tablespace_map",
+}
+
+// listFlag — повторяемый строковый флаг.
+type listFlag []string
+
+func (l *listFlag) String() string { return strings.Join(*l, ",") }
+
+func (l *listFlag) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// isExcludedDir: шаблон без "/" сравнивается с именем каталога на любой
+// глубине (в т.ч. в табличных пространствах), шаблон с "/" — с путём
+// относительно data directory.
+func isExcludedDir(rel string) bool {
+	return matchesAny(rel, append(defaultExcludes, excludeIn...))
+}
+
+// isExcluded — --exclude и defaultExcludeFiles: в архив не попадает ни сам
+// путь, ни (для каталога) его поддерево. Шаблоны — как у isExcludedDir.
+func isExcluded(rel string, dir bool) bool {
+	if !dir && matchesAny(rel, defaultExcludeFiles) {
+		return true
+	}
+	return matchesAny(rel, excludePatterns)
+}
+
+func matchesAny(rel string, patterns []string) bool {
+	rel = filepath.ToSlash(rel)
+	for _, pat := range patterns {
+		pat = strings.Trim(filepath.ToSlash(pat), "/")
+		target := rel
+		if !strings.Contains(pat, "/") {
+			target = path.Base(rel)
+		}
+		if ok, _ := path.Match(pat, target); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveIncludeDBs переводит имена --include-db в OID каталогов base/<oid>.
+func resolveIncludeDBs(db *sql.DB) (map[string]bool, error) {
+	oids := map[string]bool{}
+	for _, name := range includeDBs {
+		var oid string
+		if err := db.QueryRow(`SELECT oid::text FROM pg_database WHERE datname = $1`, name).Scan(&oid); err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, fmt.Errorf("--include-db: no database %q", name)
+			}
+			return nil, fmt.Errorf("--include-db %s: %w", name, err)
+		}
+		oids[oid] = true
+	}
+	return oids, nil
+}
+
+// excludedDBDir: каталог базы, не попавшей в --include-db. rel — путь
+// относительно data directory (base/<oid>) или каталога табличного
+// пространства (PG_<ver>_<catver>/<oid>). global/, pg_xact и прочее общее
+// для кластера остаётся.
+func excludedDBDir(rel string, oids map[string]bool) bool {
+	if oids == nil {
+		return false
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) != 2 || (parts[0] != "base" && !strings.HasPrefix(parts[0], "PG_")) {
+		return false
+	}
+	return !oids[parts[1]]
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import "os"
+
+// заглушка: posix_fadvise доступен не везде, readahead остаётся за ОС.
+func adviseSequential(*os.File) {}
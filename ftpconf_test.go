@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseFTPConfPort(t *testing.T) {
+	defer func(p int) { ftpPort = p }(ftpPort)
+	ftpPort = 21 // --ftp-port по умолчанию
+	for _, tc := range []struct {
+		name, conf string
+		addr       string // "" — ожидается ошибка
+	}{
+		{"default port", "FTP_HOST=ftp.example.com", "ftp.example.com:21"},
+		{"FTP_PORT", "FTP_HOST=ftp.example.com\nFTP_PORT=2121", "ftp.example.com:2121"},
+		{"FTP_PORT before FTP_HOST", "FTP_PORT=2121\nFTP_HOST=ftp.example.com", "ftp.example.com:2121"},
+		{"host:port wins", "FTP_HOST=ftp.example.com:990\nFTP_PORT=2121", "ftp.example.com:990"},
+		{"host:port alone", "FTP_HOST=ftp.example.com:990", "ftp.example.com:990"},
+		{"lowest port", "FTP_HOST=ftp.example.com\nFTP_PORT=1", "ftp.example.com:1"},
+		{"highest port", "FTP_HOST=ftp.example.com\nFTP_PORT=65535", "ftp.example.com:65535"},
+		{"non-numeric", "FTP_HOST=ftp.example.com\nFTP_PORT=ftp", ""},
+		{"trailing garbage", "FTP_HOST=ftp.example.com\nFTP_PORT=21x", ""},
+		{"zero", "FTP_HOST=ftp.example.com\nFTP_PORT=0", ""},
+		{"negative", "FTP_HOST=ftp.example.com\nFTP_PORT=-21", ""},
+		{"too large", "FTP_HOST=ftp.example.com\nFTP_PORT=65536", ""},
+		{"empty", "FTP_HOST=ftp.example.com\nFTP_PORT=", ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "ftp-conf")
+			conf := tc.conf + "\nFTP_USER=backup\nFTP_PASS=secret\n"
+			if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			accs, err := parseFTPConf(path)
+			if tc.addr == "" {
+				if err == nil {
+					t.Fatalf("want an error, got %+v", accs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(accs) != 1 {
+				t.Fatalf("got %d accounts, want 1", len(accs))
+			}
+			if got := accs[0].addr(); got != tc.addr {
+				t.Errorf("addr() = %q, want %q", got, tc.addr)
+			}
+		})
+	}
+}
+
+// FTP_PORT относится только к своему блоку.
+func TestParseFTPConfPortPerAccount(t *testing.T) {
+	defer func(p int) { ftpPort = p }(ftpPort)
+	ftpPort = 21
+	path := filepath.Join(t.TempDir(), "ftp-conf")
+	conf := "FTP_HOST=a.example\nFTP_USER=u\nFTP_PASS=p\nFTP_PORT=2121\n\nFTP_HOST=b.example\nFTP_USER=u\nFTP_PASS=p\n"
+	if err := os.WriteFile(path, []byte(conf), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	accs, err := parseFTPConf(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, acc := range accs {
+		got = append(got, acc.addr())
+	}
+	if len(got) != 2 || got[0] != "a.example:2121" || got[1] != "b.example:21" {
+		t.Errorf("addrs = %v, want [a.example:2121 b.example:21]", got)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+)
+
+/******************** FTP PASSWORD ********************/
+
+// Пароль для --ftp-host: --ftp-pass виден в ps и истории shell, поэтому
+// лучше --ftp-pass-file, $FTP_PASS_FILE или $FTP_PASSWORD (в этом порядке
+// после --ftp-pass). В ftp-conf вместо FTP_PASS можно FTP_PASS_FILE.
+var ftpPassFile string
+
+const (
+	ftpPassFileEnv = "FTP_PASS_FILE"
+	ftpPasswordEnv = "FTP_PASSWORD"
+)
+
+func resolveFTPPass() (string, error) {
+	switch {
+	case ftpPass != "":
+		log.Printf("%s⚠️  --ftp-pass is visible in the process list; prefer --ftp-pass-file or $%s%s",
+			yellow, ftpPasswordEnv, reset)
+		return ftpPass, nil
+	case ftpPassFile != "":
+		return readSecretFile(ftpPassFile)
+	case os.Getenv(ftpPassFileEnv) != "":
+		return readSecretFile(os.Getenv(ftpPassFileEnv))
+	}
+	return os.Getenv(ftpPasswordEnv), nil
+}
+
+// readSecretFile читает пароль из файла (первая строка без перевода строки).
+func readSecretFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	warnReadable(path, "password file")
+	pass, _, _ := strings.Cut(string(data), "\n")
+	pass = strings.TrimSuffix(pass, "\r")
+	if pass == "" {
+		return "", fmt.Errorf("%s: empty password", path)
+	}
+	return pass, nil
+}
+
+// warnReadable предупреждает, если файл с секретами читают группа или все.
+func warnReadable(path, what string) {
+	info, err := os.Stat(path)
+	if err != nil || info.Mode().Perm()&0o077 == 0 {
+		return
+	}
+	log.Printf("%s⚠️  %s %s is readable by group/others (mode %04o); chmod 600 it%s",
+		yellow, what, path, info.Mode().Perm(), reset)
+}
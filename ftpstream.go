@@ -0,0 +1,172 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/jlaffaye/ftp"
+)
+
+/******************** FTP STREAMING ********************/
+
+// ftpStream отдаёт байты архива на все FTP-аккаунты по мере записи, чтобы
+// не перечитывать многогигабайтный файл с диска ради загрузки. Сбой одного
+// аккаунта не останавливает архивацию: он просто выпадает из потока и после
+// завершения архива загружается заново из локального файла (STOR
+// перезаписывает частичный файл целиком).
+type ftpStream struct {
+	ctx        context.Context
+	remotePath string
+	targets    []*streamTarget
+	hash       hash.Hash
+	n          int64
+}
+
+type streamTarget struct {
+	acc    ftpAccount
+	c      *ftp.ServerConn
+	pw     *io.PipeWriter
+	done   chan error
+	failed bool
+}
+
+func newFTPStream(ctx context.Context) *ftpStream {
+	return &ftpStream{ctx: ctx, hash: sha256.New()}
+}
+
+func (s *ftpStream) start(remoteRel string) {
+	s.remotePath = filepath.ToSlash(remoteRel)
+	for _, acc := range ftpAccounts {
+		t := &streamTarget{acc: acc, failed: true}
+		s.targets = append(s.targets, t)
+		c, err := dialFTP(acc)
+		if err != nil {
+			log.Printf("%sFTP %s: %v, will upload after archiving%s", yellow, acc.Host, err, reset)
+			continue
+		}
+		makeRemoteDirs(c, remoteRel)
+		pr, pw := io.Pipe()
+		t.c, t.pw, t.done, t.failed = c, pw, make(chan error, 1), false
+		go func() {
+			err := c.Stor(s.remotePath, throttle(withContext(s.ctx, pr)))
+			_ = pr.CloseWithError(err) // разблокировать писателя, если STOR упал
+			t.done <- err
+		}()
+		log.Printf("%s⇪ Streaming to %s: %s%s", cyan, acc.Host, s.remotePath, reset)
+	}
+}
+
+// Write не возвращает ошибку, пока жив хоть один поток или есть локальный
+// архив: иначе io.MultiWriter оборвал бы его запись из-за одного FTP.
+// С --no-local без потоков архивировать дальше некуда.
+func (s *ftpStream) Write(p []byte) (int, error) {
+	s.hash.Write(p)
+	s.n += int64(len(p))
+	for _, t := range s.targets {
+		if t.failed {
+			continue
+		}
+		if _, err := t.pw.Write(p); err != nil {
+			log.Printf("%sFTP %s stream broke at %d bytes: %v%s", yellow, t.acc.Host, s.n, err, reset)
+			t.failed = true
+		}
+	}
+	if noLocal && !s.alive() {
+		return 0, errors.New("all FTP streams failed and there is no local copy (--no-local)")
+	}
+	return len(p), nil
+}
+
+func (s *ftpStream) alive() bool {
+	for _, t := range s.targets {
+		if !t.failed {
+			return true
+		}
+	}
+	return false
+}
+
+// finish закрывает потоки, сверяет размер на сервере, запускает ротацию и
+// перезагружает из localPath то, что не удалось передать потоком.
+// Возвращает результат по каждому аккаунту, как uploadToFTP.
+func (s *ftpStream) finish(localPath string) map[string]bool {
+	sum := fmt.Sprintf("%x", s.hash.Sum(nil))
+	log.Printf("%s🔐 Streamed %d bytes, sha256 %s%s", cyan, s.n, sum, reset)
+	res := map[string]bool{}
+	for _, t := range s.targets {
+		if t.pw != nil {
+			_ = t.pw.Close()
+			if err := <-t.done; err != nil && !t.failed {
+				log.Printf("%sFTP %s stream: %v%s", yellow, t.acc.Host, err, reset)
+				t.failed = true
+			}
+		}
+		if !t.failed {
+			// SIZE поддерживают не все серверы — сверяем, только если ответил
+			if size, err := t.c.FileSize(s.remotePath); err == nil && size != s.n {
+				log.Printf("%sFTP %s has %d bytes of %d%s", yellow, t.acc.Host, size, s.n, reset)
+				t.failed = true
+			}
+		}
+		if t.failed {
+			if t.c != nil {
+				_ = t.c.Quit()
+			}
+			if noLocal {
+				log.Printf("%sFTP %s stream failed, nothing to re-upload (--no-local)%s", red, t.acc.Host, reset)
+				res[t.acc.id()] = false
+				continue
+			}
+			if uploadFailMode == "fast" {
+				log.Printf("%sFTP %s stream failed, not retrying (--upload-fail-mode fast)%s", red, t.acc.Host, reset)
+				res[t.acc.id()] = false
+				continue
+			}
+			log.Printf("%sFTP %s: re-uploading from the local archive%s", yellow, t.acc.Host, reset)
+			res[t.acc.id()] = uploadWithRetries(s.ctx, t.acc, localPath, s.remotePath)
+			continue
+		}
+		log.Printf("%s✅ Streamed to %s%s", green, t.acc.Host, reset)
+		res[t.acc.id()] = true
+		if noLocal {
+			s.storChecksum(t, sum)
+		} else {
+			storSidecars(s.ctx, t.c, t.acc, localPath, s.remotePath)
+		}
+		if c := rotateAfterUpload(t.acc, t.c, s.remotePath); c != nil {
+			_ = c.Quit()
+		}
+	}
+	return res
+}
+
+// storChecksum кладёт .sha256 по хешу потока — локального sidecar нет.
+func (s *ftpStream) storChecksum(t *streamTarget, sum string) {
+	line := sum + "  " + path.Base(s.remotePath) + "\n"
+	if err := t.c.Stor(s.remotePath+checksumSuffix, strings.NewReader(line)); err != nil {
+		log.Printf("%s⚠️  %s: archive uploaded without its %s: %v%s", yellow, t.acc.Host, checksumSuffix, err, reset)
+	}
+}
+
+// abort обрывает потоки, если архив не получился, и удаляет частичные файлы.
+func (s *ftpStream) abort() {
+	for _, t := range s.targets {
+		if t.pw == nil {
+			continue
+		}
+		_ = t.pw.CloseWithError(errors.New("archive failed"))
+		<-t.done
+		if err := t.c.Delete(s.remotePath); err != nil {
+			log.Printf("%sFTP %s: cannot remove partial %s: %v%s", yellow, t.acc.Host, s.remotePath, err, reset)
+		}
+		_ = t.c.Quit()
+	}
+}
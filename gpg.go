@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/openpgp"
+	"golang.org/x/crypto/openpgp/packet"
+)
+
+/******************** GPG ********************/
+
+// --gpg-pubkey-file: сжатый поток шифруется открытым ключом OpenPGP, архив
+// получает суффикс .gpg. Расшифровать его может только владелец закрытого
+// ключа (gpg --decrypt), сам инструмент этого не умеет: --restore и
+// --verify-all таких архивов не читают, проверка — по .sha256.
+const gpgSuffix = ".gpg"
+
+var (
+	gpgPubkeyFile string
+	gpgRecipient  string // ключ из файла: ID, отпечаток или часть user ID
+
+	gpgOnce sync.Once
+	gpgKeys openpgp.EntityList
+	gpgErr  error
+)
+
+var errGPGArchive = errors.New("GPG-encrypted archive: decrypt it with gpg --decrypt first")
+
+// gpgRecipients читает ключи из --gpg-pubkey-file (armored или двоичный)
+// и оставляет подходящие под --gpg-recipient; без него — все.
+func gpgRecipients() (openpgp.EntityList, error) {
+	gpgOnce.Do(func() {
+		data, err := os.ReadFile(gpgPubkeyFile)
+		if err != nil {
+			gpgErr = err
+			return
+		}
+		keys, err := openpgp.ReadArmoredKeyRing(bytes.NewReader(data))
+		if err != nil {
+			if keys, err = openpgp.ReadKeyRing(bytes.NewReader(data)); err != nil {
+				gpgErr = fmt.Errorf("%s: %w", gpgPubkeyFile, err)
+				return
+			}
+		}
+		for _, e := range keys {
+			if gpgRecipient == "" || gpgKeyMatches(e, gpgRecipient) {
+				gpgKeys = append(gpgKeys, e)
+			}
+		}
+		if len(gpgKeys) == 0 {
+			gpgErr = fmt.Errorf("%s has no key for %q", gpgPubkeyFile, gpgRecipient)
+			return
+		}
+		// ключ без подключа шифрования отвергнет только Encrypt — проверяем сразу
+		w, err := openpgp.Encrypt(io.Discard, gpgKeys, nil, nil, nil)
+		if err != nil {
+			gpgErr = err
+			return
+		}
+		gpgErr = w.Close()
+	})
+	return gpgKeys, gpgErr
+}
+
+// gpgKeyMatches: ID ключа (короткий или длинный, с 0x или без),
+// отпечаток или подстрока user ID (обычно e-mail).
+func gpgKeyMatches(e *openpgp.Entity, who string) bool {
+	id := strings.ToUpper(strings.TrimPrefix(strings.ReplaceAll(who, " ", ""), "0x"))
+	fp := fmt.Sprintf("%X", e.PrimaryKey.Fingerprint)
+	if len(id) >= 8 && strings.HasSuffix(fp, id) {
+		return true
+	}
+	for name := range e.Identities {
+		if strings.Contains(strings.ToLower(name), strings.ToLower(who)) {
+			return true
+		}
+	}
+	return false
+}
+
+func newGPGWriter(w io.Writer) (io.WriteCloser, error) {
+	keys, err := gpgRecipients()
+	if err != nil {
+		return nil, err
+	}
+	return openpgp.Encrypt(w, keys, nil, &openpgp.FileHints{IsBinary: true},
+		&packet.Config{DefaultCipher: packet.CipherAES256})
+}
+
+// verifyGPGArchive — без закрытого ключа содержимое не проверить: сверяем
+// файл с .sha256, записанным при создании.
+func verifyGPGArchive(path string) error {
+	want, err := readChecksum(path)
+	if err != nil {
+		return fmt.Errorf("%w (%s cannot be decrypted here)", err, gpgSuffix)
+	}
+	got, err := hashFile(path)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return fmt.Errorf("SHA-256 mismatch (sidecar %s, file %s)", want, got)
+	}
+	return nil
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/******************** HEALTH & METRICS ********************/
+
+// clusterMetrics — итог последнего прогона одного кластера.
+type clusterMetrics struct {
+	LastRun      time.Time
+	LastSuccess  time.Time
+	Duration     time.Duration
+	OK           bool
+	ArchiveBytes int64
+	SkippedFiles int // --best-effort: файлы, не попавшие в последний архив
+	Runs         int
+	Failures     int
+	Uploads      map[string]bool // цель → успех загрузки последнего архива
+	LastError    string          // для /status
+	LastErrorAt  time.Time
+}
+
+var (
+	metricsMu sync.Mutex
+	running   bool
+	metrics   = map[string]*clusterMetrics{}
+)
+
+// recordResults обновляет метрики после прогона; пропуск из-за lock
+// не считается ни успехом, ни ошибкой.
+func recordResults(results []clusterResult) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	running = false
+	for _, r := range results {
+		if r.Err != nil && isSkipped(r.Err) {
+			continue
+		}
+		m := metrics[r.Cluster.Name]
+		if m == nil {
+			m = &clusterMetrics{}
+			metrics[r.Cluster.Name] = m
+		}
+		m.LastRun = time.Now()
+		m.Duration = r.Duration
+		m.OK = r.Err == nil
+		m.Runs++
+		if !m.OK {
+			m.Failures++
+			m.LastError, m.LastErrorAt = r.Err.Error(), m.LastRun
+			continue
+		}
+		m.LastSuccess = m.LastRun
+		m.ArchiveBytes = archiveSize(r.Archive)
+		m.SkippedFiles = r.Skipped
+	}
+}
+
+// recordUploads запоминает результаты загрузки архива кластера.
+func recordUploads(name string, uploads map[string]bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	m := metrics[name]
+	if m == nil {
+		m = &clusterMetrics{}
+		metrics[name] = m
+	}
+	m.Uploads = uploads
+}
+
+func markRunning() {
+	metricsMu.Lock()
+	running = true
+	metricsMu.Unlock()
+}
+
+// startHealthServer поднимает /healthz, /metrics (формат Prometheus) и
+// /status (JSON, см. status.go). /healthz отвечает 503, если последний
+// прогон какого-либо кластера упал.
+func startHealthServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, _ *http.Request) {
+		metricsMu.Lock()
+		defer metricsMu.Unlock()
+		for name, m := range metrics {
+			if !m.OK {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				fmt.Fprintf(w, "last backup of %s failed\n", name)
+				return
+			}
+		}
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w)
+	})
+	mux.HandleFunc("/status", serveStatus)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("%s--listen %s: %v%s", red, addr, err, reset)
+		}
+	}()
+	log.Printf("%s🩺 Health and metrics on http://%s/healthz, /metrics, /status%s", cyan, addr, reset)
+}
+
+func writeMetrics(w io.Writer) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	gauge := func(name, help string, value func(*clusterMetrics) float64) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		for _, n := range names {
+			fmt.Fprintf(w, "%s{cluster=%q} %g\n", name, n, value(metrics[n]))
+		}
+	}
+	counter := func(name, help string, value func(*clusterMetrics) int) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+		for _, n := range names {
+			fmt.Fprintf(w, "%s{cluster=%q} %d\n", name, n, value(metrics[n]))
+		}
+	}
+	unix := func(t time.Time) float64 {
+		if t.IsZero() {
+			return 0
+		}
+		return float64(t.Unix())
+	}
+
+	gauge("postgresql_backup_last_run_timestamp_seconds", "Time of the last backup attempt.",
+		func(m *clusterMetrics) float64 { return unix(m.LastRun) })
+	gauge("postgresql_backup_last_success_timestamp_seconds", "Time of the last successful backup.",
+		func(m *clusterMetrics) float64 { return unix(m.LastSuccess) })
+	gauge("postgresql_backup_last_success", "1 if the last backup succeeded.",
+		func(m *clusterMetrics) float64 {
+			if m.OK {
+				return 1
+			}
+			return 0
+		})
+	gauge("postgresql_backup_last_duration_seconds", "Duration of the last backup.",
+		func(m *clusterMetrics) float64 { return m.Duration.Seconds() })
+	gauge("postgresql_backup_last_archive_bytes", "Size of the last successful archive.",
+		func(m *clusterMetrics) float64 { return float64(m.ArchiveBytes) })
+	gauge("postgresql_backup_last_skipped_files", "Files left out of the last successful archive (--best-effort).",
+		func(m *clusterMetrics) float64 { return float64(m.SkippedFiles) })
+	counter("postgresql_backup_runs_total", "Backup attempts since start.",
+		func(m *clusterMetrics) int { return m.Runs })
+	counter("postgresql_backup_failures_total", "Failed backups since start.",
+		func(m *clusterMetrics) int { return m.Failures })
+
+	name := "postgresql_backup_last_upload_success"
+	fmt.Fprintf(w, "# HELP %s 1 if the last archive reached this upload target.\n# TYPE %s gauge\n", name, name)
+	for _, n := range names {
+		targets := make([]string, 0, len(metrics[n].Uploads))
+		for t := range metrics[n].Uploads {
+			targets = append(targets, t)
+		}
+		sort.Strings(targets)
+		for _, t := range targets {
+			v := 0
+			if metrics[n].Uploads[t] {
+				v = 1
+			}
+			fmt.Fprintf(w, "%s{cluster=%q,target=%q} %d\n", name, n, t, v)
+		}
+	}
+
+	r := 0
+	if running {
+		r = 1
+	}
+	fmt.Fprintf(w, "# HELP postgresql_backup_running 1 while a backup is in progress.\n# TYPE postgresql_backup_running gauge\npostgresql_backup_running %d\n", r)
+}
+
+/******************** METRICS TEXTFILE ********************/
+
+// Для textfile collector node_exporter: файл переписывается после каждого
+// прогона. Счётчики и время последнего успеха берутся из прошлой версии
+// файла — иначе неудачный одиночный запуск обнулил бы last_success и
+// алерт на устаревший бэкап не сработал бы.
+
+var metricLineRe = regexp.MustCompile(`^(postgresql_backup_[a-z_]+)\{cluster="((?:[^"\\]|\\.)*)"\} (\S+)$`)
+
+// loadMetricsFile восстанавливает метрики из прошлого --metrics-file.
+func loadMetricsFile(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		m := metricLineRe.FindStringSubmatch(sc.Text())
+		if m == nil {
+			continue
+		}
+		name, err := strconv.Unquote(`"` + m[2] + `"`)
+		if err != nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			continue
+		}
+		cm := metrics[name]
+		if cm == nil {
+			cm = &clusterMetrics{OK: true}
+			metrics[name] = cm
+		}
+		switch m[1] {
+		case "postgresql_backup_last_run_timestamp_seconds":
+			cm.LastRun = unixTime(v)
+		case "postgresql_backup_last_success_timestamp_seconds":
+			cm.LastSuccess = unixTime(v)
+		case "postgresql_backup_last_success":
+			cm.OK = v == 1
+		case "postgresql_backup_last_duration_seconds":
+			cm.Duration = time.Duration(v * float64(time.Second))
+		case "postgresql_backup_last_archive_bytes":
+			cm.ArchiveBytes = int64(v)
+		case "postgresql_backup_last_skipped_files":
+			cm.SkippedFiles = int(v)
+		case "postgresql_backup_runs_total":
+			cm.Runs = int(v)
+		case "postgresql_backup_failures_total":
+			cm.Failures = int(v)
+		}
+	}
+}
+
+func unixTime(v float64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(int64(v), 0)
+}
+
+// writeMetricsFile пишет метрики во временный файл рядом и переименовывает:
+// collector никогда не прочтёт файл наполовину.
+func writeMetricsFile(path string) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp*")
+	if err != nil {
+		log.Printf("%s--metrics-file: %v%s", red, err, reset)
+		return
+	}
+	defer os.Remove(tmp.Name()) // после успешного rename — no-op
+	writeMetrics(tmp)
+	err = tmp.Chmod(0o644)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		log.Printf("%s--metrics-file: %v%s", red, err, reset)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Число пропущенных --best-effort файлов попадает в /metrics и
+// переживает перезапуск через --metrics-file.
+func TestMetricsLastSkippedFiles(t *testing.T) {
+	defer func(m map[string]*clusterMetrics) { metrics = m }(metrics)
+	metrics = map[string]*clusterMetrics{}
+	recordResults([]clusterResult{
+		{Cluster: cluster{Name: "main"}, Skipped: 3},
+		{Cluster: cluster{Name: "broken"}, Err: errors.New("boom")},
+	})
+
+	var buf bytes.Buffer
+	writeMetrics(&buf)
+	for _, want := range []string{
+		"# TYPE postgresql_backup_last_skipped_files gauge\n",
+		`postgresql_backup_last_skipped_files{cluster="main"} 3` + "\n",
+		`postgresql_backup_last_skipped_files{cluster="broken"} 0` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("no %q in:\n%s", want, buf.String())
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "backup.prom")
+	if err := os.WriteFile(path, buf.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	metrics = map[string]*clusterMetrics{}
+	loadMetricsFile(path)
+	if m := metrics["main"]; m == nil || m.SkippedFiles != 3 {
+		t.Errorf("after reload: %+v, want SkippedFiles 3", m)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/******************** INCREMENTAL (LSN) ********************/
+
+// parseLSN разбирает LSN в формате PostgreSQL "16/B374D848".
+func parseLSN(s string) (uint64, error) {
+	hi, lo, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok {
+		return 0, fmt.Errorf("bad LSN %q (want X/Y)", s)
+	}
+	h, err := strconv.ParseUint(hi, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad LSN %q: %v", s, err)
+	}
+	l, err := strconv.ParseUint(lo, 16, 32)
+	if err != nil {
+		return 0, fmt.Errorf("bad LSN %q: %v", s, err)
+	}
+	return h<<32 | l, nil
+}
+
+func formatLSN(lsn uint64) string {
+	return fmt.Sprintf("%X/%X", lsn>>32, uint32(lsn))
+}
+
+// isRelationFile: сегменты таблиц/индексов лежат в base/, global/ и
+// pg_tblspc/ и называются по relfilenode (цифры, ".N", "_fsm", …).
+func isRelationFile(rel string) bool {
+	rel = filepath.ToSlash(rel)
+	if !strings.HasPrefix(rel, "base/") && !strings.HasPrefix(rel, "global/") &&
+		!strings.HasPrefix(rel, "pg_tblspc/") {
+		return false
+	}
+	name := filepath.Base(rel)
+	return name != "" && name[0] >= '0' && name[0] <= '9'
+}
+
+// relationChangedSince: есть ли в сегменте отношения страница с
+// pd_lsn >= since. Слои FSM/VM пишутся в WAL не полностью, поэтому они,
+// как и файлы некратного странице размера, считаются изменёнными всегда.
+func relationChangedSince(path string, size int64, since uint64, blockSize int) (bool, error) {
+	name := filepath.Base(path)
+	if strings.Contains(name, "_fsm") || strings.Contains(name, "_vm") ||
+		size%int64(blockSize) != 0 {
+		return true, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	page := make([]byte, blockSize)
+	for {
+		if _, err := io.ReadFull(f, page); err == io.EOF {
+			return false, nil
+		} else if err != nil {
+			return true, nil // файл меняется прямо сейчас — берём целиком
+		}
+		// pd_lsn = {xlogid, xrecoff} в порядке байт хоста
+		lsn := uint64(binary.NativeEndian.Uint32(page[0:4]))<<32 |
+			uint64(binary.NativeEndian.Uint32(page[4:8]))
+		if lsn >= since {
+			return true, nil
+		}
+	}
+}
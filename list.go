@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+/******************** LIST ********************/
+
+// listFormat — вывод --list: text (таблица) или json (для скриптов).
+var listFormat = "text"
+
+// --since/--until — только архивы за период [since, until) по времени из
+// имени архива (или mtime): RFC3339, дата 2006-01-02 или «назад» — 7d, 12h.
+var listSince, listUntil string
+
+// listEntry — один архив кластера; копии в weekly/monthly/yearly с тем
+// же именем сведены в одну строку.
+type listEntry struct {
+	Cluster  string          `json:"cluster"`
+	Name     string          `json:"name"`
+	Size     int64           `json:"size"`
+	Modified time.Time       `json:"modified"`
+	AgeDays  int             `json:"age_days"`
+	Tiers    []string        `json:"tiers"`
+	StartLSN string          `json:"start_lsn,omitempty"`
+	StopLSN  string          `json:"stop_lsn,omitempty"`
+	FTP      map[string]bool `json:"ftp,omitempty"` // user@host → есть ли на сервере
+
+	time time.Time // archiveTime — для --since/--until
+}
+
+// listBackups печатает архивы всех уровней ротации: размер, время, возраст,
+// уровни с копией и наличие на каждом FTP (по удалённому листингу).
+func listBackups() {
+	if listFormat != "text" && listFormat != "json" {
+		log.Fatalf("%s--list-format must be text or json, got %q%s", red, listFormat, reset)
+	}
+	now := time.Now()
+	since, err := parseListTime(listSince, now)
+	if err != nil {
+		log.Fatalf("%s--since: %v%s", red, err, reset)
+	}
+	until, err := parseListTime(listUntil, now)
+	if err != nil {
+		log.Fatalf("%s--until: %v%s", red, err, reset)
+	}
+	if len(clusters) == 0 {
+		clusters = clusterList{{Name: defaultCluster}}
+	}
+	root := catalogRoot()
+	var entries []listEntry
+	for _, cl := range clusters {
+		if _, err := os.Stat(filepath.Join(root, cl.Name)); err != nil {
+			log.Fatalf("%sCannot open %s: %v%s", red, filepath.Join(root, cl.Name), err, reset)
+		}
+		for _, e := range localListEntries(root, cl.Name) {
+			if (!since.IsZero() && e.time.Before(since)) || (!until.IsZero() && !e.time.Before(until)) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	addCatalogLSNs(entries)
+	initFTP()
+	for _, acc := range ftpAccounts {
+		remote, err := listFTPArchives(acc)
+		if err != nil {
+			log.Printf("%s⚠️  FTP %s: %v — its copies are not shown%s", yellow, acc.Host, err, reset)
+			continue
+		}
+		for i := range entries {
+			e := &entries[i]
+			if e.FTP == nil {
+				e.FTP = map[string]bool{}
+			}
+			e.FTP[acc.id()] = remote[e.Cluster+"/"+e.Name]
+		}
+	}
+
+	if listFormat == "json" {
+		if entries == nil {
+			entries = []listEntry{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(entries); err != nil {
+			log.Fatalf("%s%v%s", red, err, reset)
+		}
+		return
+	}
+	printListTable(entries)
+}
+
+// localListEntries собирает архивы кластера из daily/weekly/monthly/yearly.
+func localListEntries(root, clusterName string) []listEntry {
+	byName := map[string]*listEntry{}
+	var paths []string
+	for _, tier := range catalogTiers {
+		for _, a := range localArchives(filepath.Join(root, clusterName, tier)) {
+			name := filepath.Base(a)
+			if e, ok := byName[name]; ok {
+				e.Tiers = append(e.Tiers, tier)
+				continue
+			}
+			info, err := os.Stat(a)
+			if err != nil {
+				continue // удалён ротацией параллельного прогона
+			}
+			byName[name] = &listEntry{
+				Cluster:  clusterName,
+				Name:     name,
+				Size:     archiveSize(a),
+				Modified: info.ModTime(),
+				AgeDays:  int(time.Since(info.ModTime()).Hours() / 24),
+				Tiers:    []string{tier},
+				time:     archiveTime(a),
+			}
+			paths = append(paths, a)
+		}
+	}
+	sortArchives(paths)
+	out := make([]listEntry, 0, len(paths))
+	for _, p := range paths {
+		out = append(out, *byName[filepath.Base(p)])
+	}
+	return out
+}
+
+// parseListTime разбирает --since/--until; "" — без границы.
+func parseListTime(v string, now time.Time) (time.Time, error) {
+	if v == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", v, time.Local); err == nil {
+		return t, nil
+	}
+	if n, err := strconv.Atoi(strings.TrimSuffix(v, "d")); err == nil && strings.HasSuffix(v, "d") && n >= 0 {
+		return now.AddDate(0, 0, -n), nil
+	}
+	if d, err := time.ParseDuration(v); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("%q: want RFC3339, 2006-01-02 or an age like 7d, 12h", v)
+}
+
+// addCatalogLSNs дописывает LSN из catalog.json, если он есть: по файлам
+// их не восстановить.
+func addCatalogLSNs(entries []listEntry) {
+	c, err := loadCatalog()
+	if err != nil {
+		return
+	}
+	lsn := map[string]catalogEntry{}
+	for _, e := range c.Backups {
+		lsn[e.Cluster+"/"+filepath.Base(e.Path)] = e
+	}
+	for i := range entries {
+		if ce, ok := lsn[entries[i].Cluster+"/"+entries[i].Name]; ok {
+			entries[i].StartLSN, entries[i].StopLSN = ce.StartLSN, ce.StopLSN
+		}
+	}
+}
+
+// listFTPArchives — имена архивов на FTP по уровням каждого кластера:
+// "<кластер>/<имя>" → true.
+func listFTPArchives(acc ftpAccount) (map[string]bool, error) {
+	c, err := dialFTP(acc)
+	if err != nil {
+		return nil, err
+	}
+	defer c.Quit()
+	host, _ := os.Hostname()
+	out := map[string]bool{}
+	for _, cl := range clusters {
+		for _, tier := range catalogTiers {
+			dir := path.Join("/", host, backupSubdir, cl.Name, tier)
+			list, err := c.List(dir)
+			if err != nil {
+				continue // уровня на сервере ещё нет
+			}
+			for _, e := range list {
+				if isArchiveFile(e.Name) || strings.HasSuffix(e.Name, baseBackupSuffix) {
+					out[cl.Name+"/"+e.Name] = true
+				}
+			}
+		}
+	}
+	return out, nil
+}
+
+func printListTable(entries []listEntry) {
+	tw := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	showFTP := len(ftpAccounts) > 0
+	header := "CLUSTER\tNAME\tSIZE MB\tMODIFIED\tAGE\tTIERS\tLSN"
+	if showFTP {
+		header += "\tFTP"
+	}
+	fmt.Fprintln(tw, header)
+	var total int64
+	for _, e := range entries {
+		lsn := "-"
+		if e.StartLSN != "" {
+			lsn = e.StartLSN + "–" + e.StopLSN
+		}
+		line := fmt.Sprintf("%s\t%s\t%.2f\t%s\t%dd\t%s\t%s", e.Cluster, e.Name, float64(e.Size)/(1024*1024),
+			e.Modified.Format("2006-01-02 15:04"), e.AgeDays, strings.Join(e.Tiers, ","), lsn)
+		if showFTP {
+			line += "\t" + ftpPresence(e.FTP)
+		}
+		fmt.Fprintln(tw, line)
+		total += e.Size
+	}
+	_ = tw.Flush()
+	fmt.Printf("%d archive(s), %.2f GB\n", len(entries), gb(total))
+}
+
+// ftpPresence: "ok", если копия есть на всех опрошенных серверах, иначе
+// список серверов без неё; "?" — ни один сервер не ответил.
+func ftpPresence(m map[string]bool) string {
+	if len(m) == 0 {
+		return "?"
+	}
+	var missing []string
+	for id, ok := range m {
+		if !ok {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return "ok"
+	}
+	sort.Strings(missing)
+	return "missing: " + strings.Join(missing, ",")
+}
@@ -0,0 +1,15 @@
+//go:build linux
+// +build linux
+
+package main
+
+import "golang.org/x/sys/unix"
+
+// loadAvg — load average за минуту.
+func loadAvg() (float64, bool) {
+	var si unix.Sysinfo_t
+	if err := unix.Sysinfo(&si); err != nil {
+		return 0, false
+	}
+	return float64(si.Loads[0]) / (1 << unix.SI_LOAD_SHIFT), true
+}
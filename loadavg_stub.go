@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// заглушка: на других ОС --load-signal loadavg недоступен.
+func loadAvg() (float64, bool) { return 0, false }
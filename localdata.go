@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+/******************** LOCAL DATA DIRECTORY ********************/
+
+// checkLocalDataDir убеждается, что dataDir на этой машине — каталог того
+// самого сервера, к которому мы подключены. Архив читается с локального
+// диска, и DSN на удалённый хост иначе дал бы пустой или чужой бэкап.
+// Сверяется system identifier: сервер знает его из pg_control_system(),
+// а global/pg_control начинается с него же (в порядке байт машины).
+func checkLocalDataDir(db *sql.DB, dataDir string) error {
+	hint := "run postgresql-backup on the database host (or set --data-dir to where its data directory is mounted)"
+	var addr sql.NullString
+	if err := db.QueryRow(`SELECT host(inet_server_addr())`).Scan(&addr); err == nil && addr.Valid {
+		if ip := net.ParseIP(addr.String); ip != nil && !ip.IsLoopback() {
+			hint = fmt.Sprintf("the DSN reaches the server over TCP at %s; %s", addr.String, hint)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(dataDir, "PG_VERSION")); err != nil {
+		return fmt.Errorf("data directory %s is not readable here (%v): %s", dataDir, err, hint)
+	}
+	var sysid string
+	if err := db.QueryRow(`SELECT system_identifier::text FROM pg_control_system()`).Scan(&sysid); err != nil {
+		return nil // ≤9.5 или нет прав — хватит проверки PG_VERSION
+	}
+	want, err := strconv.ParseUint(sysid, 10, 64)
+	if err != nil {
+		return nil
+	}
+	f, err := os.Open(filepath.Join(dataDir, "global", "pg_control"))
+	if err != nil {
+		return fmt.Errorf("data directory %s: %v: %s", dataDir, err, hint)
+	}
+	defer f.Close()
+	var buf [8]byte
+	if _, err := io.ReadFull(f, buf[:]); err != nil {
+		return fmt.Errorf("data directory %s: pg_control: %v", dataDir, err)
+	}
+	if got := binary.NativeEndian.Uint64(buf[:]); got != want {
+		return fmt.Errorf("data directory %s belongs to another cluster (system identifier %d, server has %d): %s",
+			dataDir, got, want, hint)
+	}
+	return nil
+}
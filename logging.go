@@ -0,0 +1,93 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************** LOG FORMAT ********************/
+
+// --log-format json: одна JSON-запись на строку лога (time, level, msg и
+// поля ключевых событий). Уровень берётся из цвета сообщения: red — error,
+// yellow — warn, остальное — info. Цвета в text выключены без TTY, с
+// --no-color и при заданной NO_COLOR (https://no-color.org).
+var (
+	logFormat string
+	noColor   bool
+)
+
+var (
+	ansiRe  = regexp.MustCompile("\033\\[[0-9;]*m")
+	jsonMu  sync.Mutex
+	jsonOut = os.Stderr
+)
+
+func initLogging() error {
+	switch logFormat {
+	case "", "text":
+		// с --output - в stdout идёт архив: важен только терминал логов
+		stdoutTTY := isTerminal(os.Stdout) || outputPath == "-"
+		if noColor || os.Getenv("NO_COLOR") != "" || !isTerminal(os.Stderr) || !stdoutTTY {
+			green, yellow, red, cyan, reset = "", "", "", "", ""
+		}
+	case "json":
+		log.SetFlags(0)
+		log.SetOutput(jsonLogWriter{})
+	default:
+		return fmt.Errorf("--log-format must be text or json, got %q", logFormat)
+	}
+	return nil
+}
+
+// jsonLogWriter превращает строки log.Printf в JSON-записи.
+type jsonLogWriter struct{}
+
+func (jsonLogWriter) Write(p []byte) (int, error) {
+	line := string(p)
+	writeJSONLog(levelOf(line), line, nil)
+	return len(p), nil
+}
+
+func levelOf(s string) string {
+	switch {
+	case strings.HasPrefix(s, "\033[31m"):
+		return "error"
+	case strings.HasPrefix(s, "\033[33m"):
+		return "warn"
+	}
+	return "info"
+}
+
+func writeJSONLog(level, msg string, fields map[string]any) {
+	rec := map[string]any{}
+	for k, v := range fields {
+		rec[k] = v
+	}
+	rec["time"] = time.Now().Format(time.RFC3339Nano)
+	rec["level"] = level
+	rec["msg"] = strings.TrimSpace(ansiRe.ReplaceAllString(msg, ""))
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	_, _ = jsonOut.Write(append(data, '\n'))
+}
+
+// logEvent — сообщение с полями (архив, размер, длительность): в json они
+// идут отдельными ключами, в text печатается только текст.
+func logEvent(color string, fields map[string]any, format string, args ...any) {
+	msg := fmt.Sprintf(format, args...)
+	if logFormat != "json" {
+		log.Printf("%s%s%s", color, msg, reset)
+		return
+	}
+	writeJSONLog(levelOf(color), msg, fields)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+/******************** LOGICAL DUMP ********************/
+
+// runLogical — --logical --database: SQL-дамп одной базы (pg_dump, схема и
+// данные) в logical/<db>/daily/<ts>_<db>.sql.gz рядом с каталогом кластера.
+// Сжатие, шифрование, ротация и загрузка — те же, что у физических архивов.
+func runLogical(cl cluster, dbName string) (string, error) {
+	pgDump, err := exec.LookPath("pg_dump")
+	if err != nil {
+		return "", fmt.Errorf("--logical needs pg_dump from the PostgreSQL client tools: %w", err)
+	}
+	conninfo, pass := dumpConnString(cl.DSN, dbName)
+	db, err := sql.Open("postgres", dumpDSN(conninfo, pass))
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", errDBConnect, err)
+	}
+	defer db.Close()
+	ctx, cancel := backupContext()
+	defer cancel()
+	if err := db.PingContext(ctx); err != nil {
+		return "", fmt.Errorf("%w: database %s: %w", errDBConnect, dbName, err)
+	}
+
+	now := time.Now()
+	host, _ := os.Hostname()
+	name := fileSafeName(dbName)
+	base := filepath.Join(backupPath, host, backupSubdir, "logical", name)
+	if dryRun {
+		dump := filepath.Join(base, "daily", archiveName(cl.Name, name, dumpExt(), now))
+		log.Printf("%s🧪 [dry-run] Would dump database %s → %s%s", cyan, dbName, dump, reset)
+		rotateTiers(dump, base, now, true)
+		dryRunUploads(dump)
+		return dump, nil
+	}
+	for _, tier := range catalogTiers {
+		if err := makeBackupDir(filepath.Join(base, tier)); err != nil {
+			return "", fmt.Errorf("mkdir %s: %w", filepath.Join(base, tier), err)
+		}
+	}
+	dump := filepath.Join(base, "daily", archiveName(cl.Name, name, dumpExt(), now))
+	removePartials(filepath.Dir(dump))
+
+	log.Printf("%s📦 Dumping database %s → %s …%s", cyan, dbName, dump, reset)
+	setPhase(cl.Name, "archiving")
+	if err := writeDump(ctx, pgDump, conninfo, pass, dump); err != nil {
+		removeArchive(dump)
+		if cerr := ctxErr(ctx); cerr != nil {
+			return "", cerr
+		}
+		return "", fmt.Errorf("%w: %w", errArchive, err)
+	}
+	printFileSize(dump)
+	chownBackup(dump)
+	if _, err := writeChecksum(dump); err != nil {
+		log.Printf("%sCannot write %s: %v%s", red, dump+checksumSuffix, err, reset)
+	}
+	rotateTiers(dump, base, now, true)
+	log.Printf("%s✅ Dump finished%s", green, reset)
+
+	setPhase(cl.Name, "uploading")
+	uploads, targets := uploadArchive(ctx, dump, nil)
+	recordUploads(cl.Name, uploads)
+	if err := checkUploads(dump, uploads, targets); err != nil {
+		return "", err
+	}
+	return dump, nil
+}
+
+// runLogicalAll дампит --database или каждую базу из --logical-db-query.
+// Сбой одной базы не останавливает остальные: арендаторы независимы.
+// Возвращает последний удачный дамп и все ошибки вместе.
+func runLogicalAll(cl cluster) (string, error) {
+	dbs := []string{logicalDB}
+	if logicalDBQuery != "" {
+		var err error
+		if dbs, err = logicalDatabases(cl.DSN, logicalDBQuery); err != nil {
+			log.Printf("%s--logical-db-query: %v%s", red, err, reset)
+			return "", err
+		}
+		log.Printf("%s🗂  --logical-db-query: %d database(s): %s%s", cyan, len(dbs), strings.Join(dbs, ", "), reset)
+	}
+	var last string
+	var errs []error
+	for _, db := range dbs {
+		dump, err := runLogical(cl, db)
+		if err != nil {
+			log.Printf("%sDump of %s failed: %v%s", red, db, err, reset)
+			errs = append(errs, fmt.Errorf("database %s: %w", db, err))
+			if ctxErr(runCtx) != nil {
+				break // сигнал: остальные базы не начинаем
+			}
+			continue
+		}
+		last = dump
+	}
+	return last, errors.Join(errs...)
+}
+
+// errNoDatabases — --logical-db-query не вернул ни одной базы: пустой
+// прогон не должен выглядеть в мониторинге как удачный бэкап.
+var errNoDatabases = errors.New("--logical-db-query returned no databases")
+
+// logicalDatabases выполняет query на кластере. Запрос должен вернуть
+// ровно одну текстовую колонку; NULL, пустые имена и повторы — ошибка
+// запроса, а не повод гадать.
+func logicalDatabases(dsn, query string) ([]string, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errDBConnect, err)
+	}
+	defer db.Close()
+	ctx, cancel := backupContext()
+	defer cancel()
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		if perr := db.PingContext(ctx); perr != nil {
+			return nil, fmt.Errorf("%w: %w", errDBConnect, perr)
+		}
+		return nil, fmt.Errorf("--logical-db-query: %w", err)
+	}
+	defer rows.Close()
+	cols, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	if len(cols) != 1 {
+		return nil, fmt.Errorf("--logical-db-query must return one column of database names, got %d", len(cols))
+	}
+	switch t := cols[0].DatabaseTypeName(); t {
+	case "TEXT", "VARCHAR", "BPCHAR", "NAME":
+	default:
+		return nil, fmt.Errorf("--logical-db-query must return a text column, got %s", strings.ToLower(t))
+	}
+	var dbs []string
+	seen := map[string]bool{}
+	for rows.Next() {
+		var name sql.NullString
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		switch {
+		case !name.Valid || name.String == "":
+			return nil, fmt.Errorf("--logical-db-query returned an empty database name")
+		case seen[name.String]:
+			return nil, fmt.Errorf("--logical-db-query returned %s twice", name.String)
+		}
+		seen[name.String] = true
+		dbs = append(dbs, name.String)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(dbs) == 0 {
+		return nil, errNoDatabases
+	}
+	return dbs, nil
+}
+
+// writeDump пишет вывод pg_dump через сжатие (и шифрование) в dst —
+// через .partial, как и физические архивы.
+func writeDump(ctx context.Context, pgDump, conninfo, pass, dst string) (err error) {
+	tmp := dst + partialSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	defer func() {
+		if err != nil {
+			_ = os.Remove(tmp)
+		}
+	}()
+	w, err := newArchiveWriter(out)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, pgDump, "--no-password", "--dbname", conninfo)
+	if pass != "" {
+		// пароль — через окружение, а не в argv, видимом в ps
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+pass)
+	}
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("pg_dump: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return syncDir(filepath.Dir(dst))
+}
+
+var dsnPasswordRe = regexp.MustCompile(`(^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S+)`)
+
+// dumpConnString подставляет dbname в DSN (URL или key=value) и выносит
+// из него пароль.
+func dumpConnString(dsn, dbName string) (conninfo, pass string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		if u, err := url.Parse(dsn); err == nil {
+			if u.User != nil {
+				pass, _ = u.User.Password()
+				u.User = url.User(u.User.Username())
+			}
+			u.Path = "/" + dbName
+			return u.String(), pass
+		}
+	}
+	if m := dsnPasswordRe.FindStringSubmatch(dsn); m != nil {
+		pass = unquoteConnValue(m[2])
+		dsn = dsnPasswordRe.ReplaceAllString(dsn, "$1")
+	}
+	return strings.TrimSpace(dsn) + " dbname=" + quoteConnValue(dbName), pass
+}
+
+// dumpDSN возвращает пароль в строку подключения для lib/pq.
+func dumpDSN(conninfo, pass string) string {
+	if pass == "" {
+		return conninfo
+	}
+	if u, err := url.Parse(conninfo); err == nil && u.Scheme != "" && u.User != nil {
+		u.User = url.UserPassword(u.User.Username(), pass)
+		return u.String()
+	}
+	return conninfo + " password=" + quoteConnValue(pass)
+}
+
+func quoteConnValue(v string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
+}
+
+func unquoteConnValue(v string) string {
+	if len(v) < 2 || v[0] != '\'' {
+		return v
+	}
+	return strings.NewReplacer(`\\`, `\`, `\'`, `'`).Replace(v[1 : len(v)-1])
+}
+
+var unsafeNameRe = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// fileSafeName — имя базы для имени файла: всё кроме [A-Za-z0-9_.-] → "_".
+func fileSafeName(s string) string { return unsafeNameRe.ReplaceAllString(s, "_") }
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+/******************** BACKUP MANIFEST ********************/
+
+// <архив>.manifest.json — что внутри архива без распаковки: каждый файл
+// data directory с размером, mtime и SHA-256 содержимого (каким он будет
+// после --restore, с добитыми нулями для --trim-zeros), LSN начала и
+// конца, время, версия и настройки сервера, data_directory. Лежит рядом с архивом,
+// уходит с ним на FTP, S3 и SFTP и удаляется вместе с ним.
+const manifestSuffix = ".manifest.json"
+
+type backupManifest struct {
+	Archive       string         `json:"archive"`
+	Host          string         `json:"host"`
+	Cluster       string         `json:"cluster"`
+	Started       time.Time      `json:"started"`
+	Finished      time.Time      `json:"finished"`
+	StartLSN      string         `json:"start_lsn"`
+	StopLSN       string         `json:"stop_lsn"`
+	serverInfo                   // server_version, server_version_num, data_checksums, block_size, wal_level
+	DataDirectory string         `json:"data_directory"`
+	Incremental   bool           `json:"incremental,omitempty"` // в files только изменившиеся
+	Files         []archivedFile `json:"files"`
+}
+
+type archivedFile struct {
+	Path   string    `json:"path"`
+	Size   int64     `json:"size"`
+	Mtime  time.Time `json:"mtime"`
+	SHA256 string    `json:"sha256"`
+}
+
+// writeManifest пишет <archive>.manifest.json; сбой только в лог —
+// архив от этого не хуже.
+func writeManifest(archive string, m backupManifest) {
+	m.Archive = filepath.Base(archive)
+	if m.Files == nil {
+		m.Files = []archivedFile{}
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		log.Printf("%sCannot write %s: %v%s", red, archive+manifestSuffix, err, reset)
+		return
+	}
+	p := archive + manifestSuffix
+	if err := os.WriteFile(p, append(data, '\n'), 0o600); err != nil {
+		log.Printf("%sCannot write %s: %v%s", red, p, err, reset)
+		return
+	}
+	chownBackup(p)
+}
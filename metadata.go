@@ -0,0 +1,67 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+/******************** BACKUP METADATA IN DB ********************/
+
+type backupRecord struct {
+	Host              string
+	Started, Finished time.Time
+	StartLSN, StopLSN string
+	Location          string
+}
+
+// quoteTable экранирует "[schema.]table" для подстановки в DDL/DML.
+func quoteTable(name string) string {
+	parts := strings.Split(name, ".")
+	for i, p := range parts {
+		parts[i] = pq.QuoteIdentifier(p)
+	}
+	return strings.Join(parts, ".")
+}
+
+// recordBackupInDB пишет строку о бэкапе в саму базу, чтобы DBA видели
+// историю из SQL. На standby/read-only только предупреждаем.
+func recordBackupInDB(db *sql.DB, standby bool, r backupRecord) {
+	if standby {
+		log.Printf("%s--record-in-db skipped: server is read-only (standby)%s", yellow, reset)
+		return
+	}
+	var size int64
+	if info, err := os.Stat(r.Location); err == nil {
+		size = info.Size()
+	}
+	tbl := quoteTable(metadataTable)
+	ddl := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s (
+	id          bigserial PRIMARY KEY,
+	host        text        NOT NULL,
+	started_at  timestamptz NOT NULL,
+	finished_at timestamptz NOT NULL,
+	start_lsn   pg_lsn,
+	stop_lsn    pg_lsn,
+	size_bytes  bigint,
+	location    text        NOT NULL
+)`, tbl)
+	if _, err := db.Exec(ddl); err != nil {
+		log.Printf("%s--record-in-db: cannot create %s: %v%s", yellow, metadataTable, err, reset)
+		return
+	}
+	_, err := db.Exec(fmt.Sprintf(`INSERT INTO %s
+	(host, started_at, finished_at, start_lsn, stop_lsn, size_bytes, location)
+	VALUES ($1, $2, $3, NULLIF($4, '')::pg_lsn, NULLIF($5, '')::pg_lsn, $6, $7)`, tbl),
+		r.Host, r.Started, r.Finished, r.StartLSN, r.StopLSN, size, r.Location)
+	if err != nil {
+		log.Printf("%s--record-in-db: insert into %s: %v%s", yellow, metadataTable, err, reset)
+		return
+	}
+	log.Printf("%s🗂  Backup recorded in %s%s", cyan, metadataTable, reset)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"archive/tar"
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/******************** INCREMENTAL (MTIME) ********************/
+
+// --incremental: рядом с каждым архивом лежит <архив>.files — размер и
+// mtime всех файлов кластера. Следующий прогон берёт только файлы, у
+// которых они изменились, и пишет *_cluster_incr-архив с INCREMENTAL.txt
+// («base <имя предыдущего архива>» и полный список файлов). --restore
+// разматывает цепочку до полного архива и накладывает инкременты по порядку.
+var incremental bool
+
+const fileListSuffix = ".files"
+
+type fileStamp struct {
+	Size    int64
+	ModTime int64 // UnixNano
+}
+
+// incrBase — архив, поверх которого ложится инкремент, и его список файлов.
+type incrBase struct {
+	Name  string
+	Files map[string]fileStamp
+}
+
+func (b *incrBase) unchanged(rel string, info fs.FileInfo) bool {
+	s, ok := b.Files[filepath.ToSlash(rel)]
+	return ok && s.Size == info.Size() && s.ModTime == info.ModTime().UnixNano()
+}
+
+func stampLine(rel string, info fs.FileInfo) string {
+	return fmt.Sprintf("%s\t%d\t%d", filepath.ToSlash(rel), info.Size(), info.ModTime().UnixNano())
+}
+
+func isIncrementalArchive(p string) bool {
+	return strings.Contains(filepath.Base(p), "cluster_incr")
+}
+
+// incrementalBase выбирает базу для --incremental в daily. nil — пора
+// делать полный бэкап: списка файлов нет или новый инкремент пережил бы
+// в ротации daily свой полный архив, и цепочку стало бы не восстановить.
+func incrementalBase(daily string, now time.Time) *incrBase {
+	var archives []string
+	for _, a := range globArchives(daily) {
+		if !isDump(a) {
+			archives = append(archives, a)
+		}
+	}
+	sortArchives(archives)
+	incrs := 0
+	full := ""
+	for i := len(archives) - 1; i >= 0; i-- {
+		if !isIncrementalArchive(archives[i]) {
+			full = archives[i]
+			break
+		}
+		incrs++
+	}
+	if full == "" {
+		log.Printf("%s🧩 No full archive in %s yet — taking a full backup%s", cyan, daily, reset)
+		return nil
+	}
+	if maxCopies > 0 && incrs+2 > maxCopies {
+		log.Printf("%s🧩 %d incremental(s) since %s fill --copies %d — taking a full backup%s",
+			cyan, incrs, filepath.Base(full), maxCopies, reset)
+		return nil
+	}
+	if info, err := os.Stat(full); maxCopies == 0 && err == nil &&
+		now.Sub(info.ModTime()) >= time.Duration(keepDays-1)*24*time.Hour {
+		log.Printf("%s🧩 %s expires under --days %d soon — taking a full backup%s",
+			cyan, filepath.Base(full), keepDays, reset)
+		return nil
+	}
+	newest := archives[len(archives)-1]
+	files, err := readFileList(newest + fileListSuffix)
+	if err != nil {
+		log.Printf("%s🧩 No file list for %s (%v) — taking a full backup%s", yellow, filepath.Base(newest), err, reset)
+		return nil
+	}
+	log.Printf("%s🧩 Incremental on top of %s (%d incremental(s) since %s)%s",
+		cyan, filepath.Base(newest), incrs, filepath.Base(full), reset)
+	return &incrBase{Name: filepath.Base(newest), Files: files}
+}
+
+func readFileList(p string) (map[string]fileStamp, error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	files := map[string]fileStamp{}
+	sc := bufio.NewScanner(f)
+	for n := 1; sc.Scan(); n++ {
+		parts := strings.Split(sc.Text(), "\t")
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("%s:%d: malformed line", p, n)
+		}
+		size, err1 := strconv.ParseInt(parts[1], 10, 64)
+		mtime, err2 := strconv.ParseInt(parts[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("%s:%d: malformed line", p, n)
+		}
+		files[parts[0]] = fileStamp{size, mtime}
+	}
+	return files, sc.Err()
+}
+
+// writeFileList сохраняет список файлов архива для следующего инкремента.
+func writeFileList(archive string, st *archiveStats) {
+	p := archive + fileListSuffix
+	if err := os.WriteFile(p, []byte(strings.Join(st.Stamps, "\n")+"\n"), 0o600); err != nil {
+		log.Printf("%sCannot write %s: %v — next --incremental run will be full%s", red, p, err, reset)
+		return
+	}
+	chownBackup(p)
+}
+
+// readIncrementalInfo читает INCREMENTAL.txt архива: base — предыдущий
+// архив цепочки ("" — полный архив или --since-lsn), files — все файлы
+// кластера на момент бэкапа (nil, если INCREMENTAL.txt нет).
+func readIncrementalInfo(archive string) (base string, files map[string]bool, err error) {
+	if info, err := os.Stat(archive); err != nil || info.IsDir() || isDump(archive) {
+		return "", nil, err
+	}
+	r, err := openArchive(archive)
+	if err != nil {
+		return "", nil, err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return "", nil, nil
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		if hdr.Name != incrementalEntry {
+			continue
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return "", nil, err
+		}
+		first, rest, _ := strings.Cut(string(body), "\n")
+		base, _ = strings.CutPrefix(first, "base ")
+		if base == first {
+			base = "" // since-lsn
+		}
+		files = map[string]bool{}
+		for _, l := range strings.Split(rest, "\n") {
+			if p, _, ok := strings.Cut(l, "\t"); ok {
+				files[p] = true
+			}
+		}
+		return base, files, nil
+	}
+}
+
+// incrementalChain — архивы для восстановления archive по порядку: полный,
+// затем инкременты. files — список файлов последнего инкремента (nil для
+// одиночного архива). База ищется в том же каталоге, что и archive.
+func incrementalChain(archive string) (chain []string, files map[string]bool, err error) {
+	chain = []string{archive}
+	for a := archive; ; {
+		base, list, err := readIncrementalInfo(a)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", a, err)
+		}
+		if a == archive {
+			files = list
+		}
+		if base == "" {
+			break
+		}
+		if base >= filepath.Base(a) {
+			return nil, nil, fmt.Errorf("%s: base %s is not older", a, base)
+		}
+		a = filepath.Join(filepath.Dir(archive), base)
+		if _, err := os.Stat(a); err != nil {
+			return nil, nil, fmt.Errorf("base archive of %s is missing: %w", filepath.Base(chain[0]), err)
+		}
+		chain = append([]string{a}, chain...)
+	}
+	if len(chain) == 1 {
+		files = nil
+	}
+	return chain, files, nil
+}
+
+// служебные записи в корне архива (SERVER_INFO.txt — serverInfoEntry)
+const (
+	skippedFilesEntry = "skipped_files.txt" // --best-effort: что не попало в архив
+	trimmedEntry      = "TRIMMED.txt"       // --trim-zeros: исходные размеры
+	incrementalEntry  = "INCREMENTAL.txt"   // инкремент: база и список файлов
+)
+
+// служебные файлы архива, которых нет в списке файлов кластера
+var incrServiceFiles = map[string]bool{
+	"backup_label": true, "tablespace_map": true, skippedFilesEntry: true,
+	trimmedEntry: true, incrementalEntry: true, serverInfoEntry: true,
+}
+
+// archiveMetaEntry: запись описывает сам архив, в data directory ей не
+// место. backup_label и tablespace_map, наоборот, нужны серверу.
+func archiveMetaEntry(name string) bool {
+	return incrServiceFiles[name] && name != "backup_label" && name != "tablespace_map"
+}
+
+// pruneRestored удаляет из dest файлы, которых не было в кластере на
+// момент последнего инкремента (удалённые таблицы, сегменты после VACUUM).
+func pruneRestored(dest string, files map[string]bool) (int, error) {
+	removed := 0
+	err := filepath.WalkDir(dest, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return err
+		}
+		rel, _ := filepath.Rel(dest, p)
+		rel = filepath.ToSlash(rel)
+		if files[rel] || incrServiceFiles[rel] {
+			return nil
+		}
+		log.Printf("  🗑  %s", rel)
+		removed++
+		return os.Remove(p)
+	})
+	return removed, err
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+/******************** ARCHIVE NAMES ********************/
+
+// --name-template — имя нового архива или дампа (Go template). По
+// умолчанию даёт прежние имена: 2006-01-02_15-04-05_cluster.tar.gz.
+// Ротация, --list и загрузки узнают архивы по суффиксу формата ({{.Ext}}),
+// поэтому шаблон обязан им заканчиваться. Каталоги
+// --pgbasebackup-compatible называются как раньше.
+const defaultNameTemplate = "{{.Time}}_{{.Kind}}{{.Ext}}"
+
+var (
+	nameTemplate = defaultNameTemplate
+	nameTmpl     = template.Must(template.New("name").Option("missingkey=error").Parse(defaultNameTemplate))
+)
+
+// nameVars — переменные шаблона.
+type nameVars struct {
+	Host    string
+	Cluster string
+	Kind    string   // cluster, cluster_incr или имя базы для --logical
+	Time    nameTime // {{.Time}} — 2006-01-02_15-04-05, {{.Time.Format "…"}} — своё
+	Ext     string   // .tar.gz, .tar.zst.enc, .sql.gz …
+}
+
+type nameTime struct{ time.Time }
+
+func (t nameTime) String() string { return t.Format(archiveTimeLayout) }
+
+const archiveTimeLayout = "2006-01-02_15-04-05"
+
+// parseNameTemplate проверяет --name-template на пробных значениях: имя
+// без каталогов, с {{.Ext}} на конце, разное для разных секунд и (для
+// --incremental/--since-lsn) с cluster_incr у инкрементов.
+func parseNameTemplate(text string, incr bool) error {
+	t, err := template.New("name").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return err
+	}
+	nameTmpl = t
+	now := time.Now()
+	v := nameVars{Host: "host", Cluster: defaultCluster, Kind: "cluster", Time: nameTime{now}, Ext: archiveExt()}
+	a, err := renderName(v)
+	if err != nil {
+		return err
+	}
+	if a == "" || strings.ContainsAny(a, `/\`) || a == v.Ext {
+		return fmt.Errorf("%q is not a file name", a)
+	}
+	if !strings.HasSuffix(a, v.Ext) {
+		return fmt.Errorf("%q must end with {{.Ext}} (%s): rotation finds archives by it", a, v.Ext)
+	}
+	v.Time = nameTime{now.Add(time.Second)}
+	if b, _ := renderName(v); a == b {
+		return errors.New("names must differ from run to run: include {{.Time}} down to the second")
+	}
+	if incr {
+		v.Kind = "cluster_incr"
+		if b, _ := renderName(v); !strings.Contains(b, "cluster_incr") {
+			return errors.New("incremental archives need {{.Kind}} in the name")
+		}
+	}
+	return nil
+}
+
+func renderName(v nameVars) (string, error) {
+	var b strings.Builder
+	if err := nameTmpl.Execute(&b, v); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// archiveName — имя нового архива; шаблон проверен при старте.
+func archiveName(cl, kind, ext string, now time.Time) string {
+	host, _ := os.Hostname()
+	name, err := renderName(nameVars{Host: host, Cluster: cl, Kind: kind, Time: nameTime{now}, Ext: ext})
+	if err != nil {
+		return now.Format(archiveTimeLayout) + "_" + kind + ext
+	}
+	return name
+}
+
+var archiveTimeRe = regexp.MustCompile(`\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}`)
+
+// archiveTime — время архива: метка в имени, а если шаблон её не
+// содержит — mtime файла.
+func archiveTime(p string) time.Time {
+	if m := archiveTimeRe.FindString(filepath.Base(p)); m != "" {
+		if t, err := time.ParseInLocation(archiveTimeLayout, m, time.Local); err == nil {
+			return t
+		}
+	}
+	if info, err := os.Stat(p); err == nil {
+		return info.ModTime()
+	}
+	return time.Time{}
+}
+
+// sortArchives упорядочивает архивы от старых к новым.
+func sortArchives(archives []string) {
+	t := make(map[string]time.Time, len(archives))
+	for _, a := range archives {
+		t[a] = archiveTime(a)
+	}
+	sort.SliceStable(archives, func(i, j int) bool {
+		if !t[archives[i]].Equal(t[archives[j]]) {
+			return t[archives[i]].Before(t[archives[j]])
+		}
+		return archives[i] < archives[j]
+	})
+}
+
+// expectedArchiveName — имя архива (или его sidecar-файла) по текущей
+// схеме. Со своим --name-template достаточно суффикса формата.
+func expectedArchiveName(name string) bool {
+	if nameTemplate == defaultNameTemplate {
+		return archiveNameRe.MatchString(name)
+	}
+	for _, s := range []string{".backup_label", ".tablespace_map", checksumSuffix, manifestSuffix} {
+		name = strings.TrimSuffix(name, s)
+	}
+	return isArchiveFile(name)
+}
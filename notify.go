@@ -0,0 +1,90 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+/******************** NOTIFICATIONS ********************/
+
+// --notify-url: POST итога кластера (тот же backupSummary, что у событий)
+// на webhook; --notify-format slack заворачивает текст в {"text": …}.
+// Ошибки webhook только логируются — на результат бэкапа они не влияют.
+var (
+	notifyURL    string
+	notifyOn     string // success | failure | always
+	notifyFormat string // json | slack
+)
+
+func checkNotifyFlags() error {
+	if notifyURL == "" {
+		return nil
+	}
+	switch notifyOn {
+	case "success", "failure", "always":
+	default:
+		return fmt.Errorf("--notify-on must be success, failure or always, got %q", notifyOn)
+	}
+	switch notifyFormat {
+	case "json", "slack":
+	default:
+		return fmt.Errorf("--notify-format must be json or slack, got %q", notifyFormat)
+	}
+	return nil
+}
+
+func notifyResults(results []clusterResult) {
+	if notifyURL == "" {
+		return
+	}
+	now := time.Now()
+	for _, r := range results {
+		s := summarize(r, now)
+		switch {
+		case notifyOn == "success" && s.Status != "ok" && s.Status != "warning",
+			notifyOn == "failure" && s.Status != "failed":
+			continue
+		}
+		if err := postNotification(s); err != nil {
+			log.Printf("%sNotification to %s failed: %v%s", yellow, notifyURL, err, reset)
+		}
+	}
+}
+
+func postNotification(s backupSummary) error {
+	var body []byte
+	if notifyFormat == "slack" {
+		body, _ = json.Marshal(map[string]string{"text": slackText(s)})
+	} else {
+		body, _ = json.Marshal(s)
+	}
+	client := &http.Client{Timeout: 15 * time.Second}
+	resp, err := client.Post(notifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("HTTP %s", resp.Status)
+	}
+	return nil
+}
+
+func slackText(s backupSummary) string {
+	switch s.Status {
+	case "ok":
+		return fmt.Sprintf("✅ Backup of %s on %s finished in %s: %s (%.2f MB)", s.Cluster, s.Host,
+			time.Duration(s.DurationSec*float64(time.Second)).Round(time.Second), s.Archive, float64(s.Bytes)/(1024*1024))
+	case "warning":
+		return fmt.Sprintf("⚠️ Backup of %s on %s finished in %s with %d skipped file(s): %s (%.2f MB)", s.Cluster, s.Host,
+			time.Duration(s.DurationSec*float64(time.Second)).Round(time.Second), s.SkippedFiles, s.Archive, float64(s.Bytes)/(1024*1024))
+	case "skipped":
+		return fmt.Sprintf("⏭ Backup of %s on %s skipped: %s", s.Cluster, s.Host, s.Error)
+	}
+	return fmt.Sprintf("❌ Backup of %s on %s failed after %s: %s", s.Cluster, s.Host,
+		time.Duration(s.DurationSec*float64(time.Second)).Round(time.Second), s.Error)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+/******************** FTP ORPHANS ********************/
+
+// archiveNameRe — имена, которые создают backupCluster и --logical (плюс
+// sidecar-файлы).
+var archiveNameRe = regexp.MustCompile(
+	`^\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}_(cluster(_incr)?\.(tar|zip)|[A-Za-z0-9_.-]+\.sql)(\.[a-z0-9]+)?(\.enc|\.gpg)?(\.backup_label|\.tablespace_map|\.sha256|\.manifest\.json)?$`)
+
+// содержимое каталога --pgbasebackup-compatible
+var (
+	baseBackupDirRe  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}_\d{2}-\d{2}-\d{2}` + baseBackupSuffix + `$`)
+	baseBackupFileRe = regexp.MustCompile(`^(base|\d+)\.tar(\.[a-z0-9]+)?$|^backup_manifest$`)
+)
+
+type ftpOrphan struct {
+	Path   string
+	Size   uint64
+	Reason string
+}
+
+// listFTPOrphans ищет на каждом FTP файлы, которые не соответствуют ни
+// схеме имён, ни текущей ротации, и (с --delete) удаляет их после
+// подтверждения.
+func listFTPOrphans(del bool) int {
+	initFTP()
+	if !ftpEnabled {
+		log.Printf("%sNo FTP accounts configured%s", red, reset)
+		return exitFailure
+	}
+	host, _ := os.Hostname()
+	root := path.Join("/", host, backupSubdir)
+	localRoot := path.Join(backupPath, host, backupSubdir)
+	status := 0
+	for _, acc := range ftpAccounts {
+		c, err := dialFTP(acc)
+		if err != nil {
+			log.Printf("%sFTP %s: %v%s", red, acc.Host, err, reset)
+			status = exitFailure
+			continue
+		}
+		orphans := findFTPOrphans(c, root, localRoot)
+		if len(orphans) == 0 {
+			log.Printf("%s✅ %s: no orphans under %s%s", green, acc.Host, root, reset)
+			_ = c.Quit()
+			continue
+		}
+		var total uint64
+		fmt.Printf("%s%s:%s\n", cyan, acc.Host, reset)
+		for _, o := range orphans {
+			fmt.Printf("  %-70s %10.2f MB  %s\n", o.Path, float64(o.Size)/(1024*1024), o.Reason)
+			total += o.Size
+		}
+		fmt.Printf("  %d orphan(s), %.2f MB\n", len(orphans), float64(total)/(1024*1024))
+		if del && confirm(fmt.Sprintf("Delete %d file(s) on %s?", len(orphans), acc.Host)) {
+			for _, o := range orphans {
+				if err := c.Delete(o.Path); err != nil {
+					log.Printf("%sFTP delete %s: %v%s", red, o.Path, err, reset)
+					status = exitFailure
+					continue
+				}
+				log.Printf("🧹 (FTP) Deleted orphan %s", o.Path)
+			}
+		}
+		_ = c.Quit()
+	}
+	return status
+}
+
+func findFTPOrphans(c *ftp.ServerConn, root, localRoot string) []ftpOrphan {
+	var orphans []ftpOrphan
+	daily := map[string][]*ftp.Entry{} // <cluster>/daily → архивы
+	w := c.Walk(root)
+	for w.Next() {
+		e := w.Stat()
+		if e.Type != ftp.EntryTypeFile {
+			continue
+		}
+		p := w.Path()
+		switch {
+		case baseBackupDirRe.MatchString(path.Base(path.Dir(p))) && baseBackupFileRe.MatchString(e.Name):
+			// часть набора pg_basebackup, ротируется вместе с каталогом
+		case path.Base(path.Dir(p)) == walDir:
+			// сегменты --wal-archive, чистятся вместе с базовыми бэкапами
+		case !expectedArchiveName(e.Name):
+			orphans = append(orphans, ftpOrphan{p, e.Size, "unexpected name"})
+		case path.Base(path.Dir(p)) == "daily" && isArchiveFile(e.Name):
+			daily[path.Dir(p)] = append(daily[path.Dir(p)], e)
+		}
+	}
+	if err := w.Err(); err != nil {
+		log.Printf("%sFTP walk %s: %v%s", yellow, root, err, reset)
+	}
+
+	// daily-архивы вне окна FTP-ротации, которых нет и локально
+	cutoff := time.Now().AddDate(0, 0, -keepDays*ftpKeepFactor)
+	for dir, entries := range daily {
+		localDaily := path.Join(localRoot, strings.TrimPrefix(dir, root))
+		sort.Slice(entries, func(i, j int) bool { return entries[i].Time.After(entries[j].Time) })
+		for i, e := range entries {
+			expired := maxCopies > 0 && i >= maxCopies*ftpKeepFactor ||
+				maxCopies == 0 && e.Time.Before(cutoff)
+			if !expired {
+				continue
+			}
+			if _, err := os.Stat(path.Join(localDaily, e.Name)); err == nil {
+				continue
+			}
+			orphans = append(orphans, ftpOrphan{path.Join(dir, e.Name), e.Size, "outside retention, no local copy"})
+		}
+	}
+	return orphans
+}
+
+// confirm спрашивает y/N на терминале; без терминала — «нет».
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N]: ", question)
+	line, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer := strings.ToLower(strings.TrimSpace(line))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+/******************** OUTPUT ********************/
+
+// --output <file|->: один архив в файл или в stdout для конвейеров
+// (`--output - | ssh host 'cat > backup.tar.gz'`) вместо daily/: без
+// ротации, загрузок и каталога. Логи, как всегда, идут в stderr.
+var outputPath string
+
+func writeOutput(dataDir string, opts archiveOpts) (string, *archiveStats, error) {
+	if outputPath != "-" {
+		log.Printf("%s📦 Archiving to %s …%s", cyan, outputPath, reset)
+		st, err := createTarGzFromDir(outputPath, dataDir, opts)
+		if err != nil {
+			return "", st, err
+		}
+		printFileSize(outputPath)
+		return outputPath, st, nil
+	}
+	// закрытый читатель должен дать ошибку записи, а не убить процесс
+	// SIGPIPE посреди бэкапа
+	signal.Ignore(syscall.SIGPIPE)
+	log.Printf("%s📦 Archiving to stdout …%s", cyan, reset)
+	opts.Output = os.Stdout
+	st, err := createTarGzFromDir(outputPath, dataDir, opts)
+	return outputPath, st, err
+}
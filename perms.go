@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+/******************** PERMISSIONS ********************/
+
+// modeFlag — права в восьмеричной записи ("0700", "750").
+type modeFlag os.FileMode
+
+func (m *modeFlag) String() string { return fmt.Sprintf("%04o", uint32(*m)) }
+
+func (m *modeFlag) Set(v string) error {
+	n, err := strconv.ParseUint(v, 8, 32)
+	if err != nil || n > 0o7777 {
+		return fmt.Errorf("want an octal mode like 0700, got %q", v)
+	}
+	*m = modeFlag(n)
+	return nil
+}
+
+var ownerUID, ownerGID = -1, -1 // --owner, -1 = не менять
+
+// resolveOwner разбирает "user", "user:group" или ":group".
+func resolveOwner(spec string) error {
+	name, group, _ := strings.Cut(spec, ":")
+	if name != "" {
+		u, err := user.Lookup(name)
+		if err != nil {
+			return err
+		}
+		ownerUID, _ = strconv.Atoi(u.Uid)
+		if group == "" {
+			ownerGID, _ = strconv.Atoi(u.Gid)
+		}
+	}
+	if group != "" {
+		g, err := user.LookupGroup(group)
+		if err != nil {
+			return err
+		}
+		ownerGID, _ = strconv.Atoi(g.Gid)
+	}
+	return nil
+}
+
+// makeBackupDir создаёт каталог и выставляет --dir-mode/--owner всем
+// каталогам между --backup-path и ним (сам корень не трогаем: он может
+// быть общим точкой монтирования).
+func makeBackupDir(dir string) error {
+	if err := os.MkdirAll(dir, os.FileMode(dirMode)); err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(backupPath, dir)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return nil
+	}
+	p := backupPath
+	for _, part := range strings.Split(rel, string(os.PathSeparator)) {
+		if part == "." || part == "" {
+			continue
+		}
+		p = filepath.Join(p, part)
+		// MkdirAll подвержен umask, и старые каталоги были 0755
+		if err := os.Chmod(p, os.FileMode(dirMode)); err != nil {
+			return err
+		}
+		chownBackup(p)
+	}
+	return nil
+}
+
+// chownBackup отдаёт созданный файл или каталог владельцу из --owner.
+func chownBackup(path string) {
+	if ownerUID < 0 && ownerGID < 0 {
+		return
+	}
+	_ = os.Chown(path, ownerUID, ownerGID)
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"archive/tar"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+/******************** SERVER VERSION ********************/
+
+// Версия и ключевые настройки сервера, с которого снят бэкап. Они пишутся
+// в .manifest.json и первой записью архива SERVER_INFO.txt («ключ значение»
+// построчно, как PG_VERSION). Data directory поднимается только той же
+// major-версией, поэтому --restore сверяет её с установленным postgres.
+const serverInfoEntry = "SERVER_INFO.txt"
+
+type serverInfo struct {
+	Version       string `json:"server_version"`
+	VersionNum    int    `json:"server_version_num"`
+	DataChecksums string `json:"data_checksums"`
+	BlockSize     int    `json:"block_size"`
+	WALLevel      string `json:"wal_level"`
+}
+
+func queryServerInfo(ctx context.Context, db *sql.DB) (serverInfo, error) {
+	var s serverInfo
+	err := db.QueryRowContext(ctx, `SELECT current_setting('server_version'),
+		current_setting('server_version_num')::int, current_setting('data_checksums'),
+		current_setting('block_size')::int, current_setting('wal_level')`).
+		Scan(&s.Version, &s.VersionNum, &s.DataChecksums, &s.BlockSize, &s.WALLevel)
+	return s, err
+}
+
+func (s serverInfo) text() string {
+	return fmt.Sprintf("server_version %s\nserver_version_num %d\ndata_checksums %s\nblock_size %d\nwal_level %s\n",
+		s.Version, s.VersionNum, s.DataChecksums, s.BlockSize, s.WALLevel)
+}
+
+func parseServerInfo(text string) serverInfo {
+	var s serverInfo
+	for _, l := range strings.Split(text, "\n") {
+		k, v, _ := strings.Cut(l, " ")
+		switch k {
+		case "server_version":
+			s.Version = v
+		case "server_version_num":
+			s.VersionNum, _ = strconv.Atoi(v)
+		case "data_checksums":
+			s.DataChecksums = v
+		case "block_size":
+			s.BlockSize, _ = strconv.Atoi(v)
+		case "wal_level":
+			s.WALLevel = v
+		}
+	}
+	return s
+}
+
+// major — major-версия: 16 из 160004, 9.6 из 90624.
+func (s serverInfo) major() string {
+	if s.VersionNum >= 100000 {
+		return strconv.Itoa(s.VersionNum / 10000)
+	}
+	return fmt.Sprintf("%d.%d", s.VersionNum/10000, s.VersionNum/100%100)
+}
+
+// archiveServerInfo — версия из <archive>.manifest.json, иначе из
+// SERVER_INFO.txt в начале архива. У старых архивов её нет.
+func archiveServerInfo(archive string) (serverInfo, bool) {
+	if data, err := os.ReadFile(archive + manifestSuffix); err == nil {
+		var m backupManifest
+		if json.Unmarshal(data, &m) == nil && m.VersionNum > 0 {
+			return m.serverInfo, true
+		}
+	}
+	if info, err := os.Stat(archive); err != nil || info.IsDir() {
+		return serverInfo{}, false
+	}
+	r, err := openArchive(archive)
+	if err != nil {
+		return serverInfo{}, false
+	}
+	defer r.Close()
+	hdr, err := tar.NewReader(r).Next()
+	if err != nil || hdr.Name != serverInfoEntry {
+		return serverInfo{}, false
+	}
+	body, err := io.ReadAll(io.LimitReader(r, 4096))
+	if err != nil {
+		return serverInfo{}, false
+	}
+	s := parseServerInfo(string(body))
+	return s, s.VersionNum > 0
+}
+
+var postgresVersionRe = regexp.MustCompile(`\(PostgreSQL\) (\d+)(?:\.(\d+))?`)
+
+// installedPostgres — major-версии установленных postgres: из PATH и
+// стандартных каталогов пакетов Debian/RHEL.
+func installedPostgres() map[string]string {
+	var bins []string
+	if p, err := exec.LookPath("postgres"); err == nil {
+		bins = append(bins, p)
+	}
+	for _, g := range []string{"/usr/lib/postgresql/*/bin/postgres", "/usr/pgsql-*/bin/postgres", "/usr/local/pgsql/bin/postgres"} {
+		m, _ := filepath.Glob(g)
+		bins = append(bins, m...)
+	}
+	out := map[string]string{} // major → бинарник
+	for _, b := range bins {
+		v, err := exec.Command(b, "--version").Output()
+		if err != nil {
+			continue
+		}
+		m := postgresVersionRe.FindStringSubmatch(string(v))
+		if m == nil {
+			continue
+		}
+		major := m[1]
+		if n, _ := strconv.Atoi(m[1]); n < 10 && m[2] != "" {
+			major += "." + m[2]
+		}
+		if _, ok := out[major]; !ok {
+			out[major] = b
+		}
+	}
+	return out
+}
+
+// checkRestoreVersion предупреждает, если архив снят с major-версии,
+// которой на этой машине нет: такой data directory не запустится.
+func checkRestoreVersion(archive string) {
+	s, ok := archiveServerInfo(archive)
+	if !ok {
+		return
+	}
+	want := s.major()
+	found := installedPostgres()
+	if bin, ok := found[want]; ok {
+		log.Printf("%s🐘 Archive from PostgreSQL %s, matching server: %s%s", cyan, s.Version, bin, reset)
+		return
+	}
+	if len(found) == 0 {
+		log.Printf("%s⚠️  Archive from PostgreSQL %s: no postgres binary found to check compatibility — start it with PostgreSQL %s%s",
+			yellow, s.Version, want, reset)
+		return
+	}
+	var have []string
+	for v := range found {
+		have = append(have, v)
+	}
+	sort.Strings(have)
+	log.Printf("%s⚠️  Archive from PostgreSQL %s, but installed postgres is %s: the data directory needs PostgreSQL %s%s",
+		yellow, s.Version, strings.Join(have, ", "), want, reset)
+}
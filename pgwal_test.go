@@ -0,0 +1,107 @@
+package main
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testDataDirWALLink — data directory, где pg_wal — симлинк на каталог
+// с сегментом и .ready в archive_status.
+func testDataDirWALLink(t *testing.T) string {
+	t.Helper()
+	dir := testDataDir(t, 100)
+	wal := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(wal, "archive_status"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"000000010000000000000001", "archive_status/000000010000000000000001.ready"} {
+		if err := os.WriteFile(filepath.Join(wal, f), []byte("wal"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(wal, filepath.Join(dir, "pg_wal")); err != nil {
+		t.Skip(err)
+	}
+	return dir
+}
+
+// tarEntries — имена записей архива с типом: «/» на конце — каталог.
+func tarEntries(t *testing.T, archive string) map[string]byte {
+	t.Helper()
+	r, err := openArchive(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	entries := map[string]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[strings.TrimSuffix(hdr.Name, "/")] = hdr.Typeflag
+	}
+}
+
+func checkEmptyPgWAL(t *testing.T, entries map[string]byte) {
+	t.Helper()
+	for _, d := range []string{"pg_wal", "pg_wal/archive_status"} {
+		typ, ok := entries[d]
+		if !ok {
+			t.Errorf("no %s/ entry", d)
+		} else if typ != tar.TypeDir {
+			t.Errorf("%s: type %q, want a directory", d, typ)
+		}
+	}
+	for name := range entries {
+		if strings.HasPrefix(name, "pg_wal/") && name != "pg_wal/archive_status" {
+			t.Errorf("WAL file %s archived", name)
+		}
+	}
+	if _, ok := entries["base/1/1234"]; !ok {
+		t.Error("base/1/1234 missing")
+	}
+}
+
+func TestCreateTarGzFromDirPgWALSymlink(t *testing.T) {
+	dir := testDataDirWALLink(t)
+	dst := filepath.Join(t.TempDir(), "a.tar.gz")
+	if _, err := createTarGzFromDir(dst, dir, archiveOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	checkEmptyPgWAL(t, tarEntries(t, dst))
+}
+
+func TestWriteBaseBackupPgWALSymlink(t *testing.T) {
+	dir := testDataDirWALLink(t)
+	dst := filepath.Join(t.TempDir(), "a"+baseBackupSuffix)
+	const label = "START WAL LOCATION: 0/2000028 (file 000000010000000000000002)\nSTART TIMELINE: 1\n"
+	stop := func() (string, string, string, error) { return "0/2000100", label, "", nil }
+	if _, err := writeBaseBackup(dst, dir, archiveOpts{Stop: stop}); err != nil {
+		t.Fatal(err)
+	}
+	checkEmptyPgWAL(t, tarEntries(t, filepath.Join(dst, "base"+archiveExt())))
+}
+
+// --keep-wal: сегменты pg_wal попадают в архив.
+func TestCreateTarGzFromDirKeepWAL(t *testing.T) {
+	dir := testDataDirWALLink(t)
+	dst := filepath.Join(t.TempDir(), "a.tar.gz")
+	if _, err := createTarGzFromDir(dst, dir, archiveOpts{KeepWAL: true}); err != nil {
+		t.Fatal(err)
+	}
+	entries := tarEntries(t, dst)
+	for _, f := range []string{"pg_wal/000000010000000000000001", "pg_wal/archive_status/000000010000000000000001.ready"} {
+		if _, ok := entries[f]; !ok {
+			t.Errorf("%s missing", f)
+		}
+	}
+}
@@ -0,0 +1,26 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// по умолчанию — unix-сокет локального сервера
+const defaultDSN = "host=/var/run/postgresql user=postgres sslmode=disable"
+
+var defaultLockFile = "/tmp/postgresql_backup.lock"
+
+// processAlive — сигнал 0: процесс есть, если его можно «пнуть».
+func processAlive(pid int) bool {
+	proc, err := os.FindProcess(pid)
+	return err == nil && proc.Signal(syscall.Signal(0)) == nil
+}
+
+// shellCommand — команда хука через /bin/sh -c.
+func shellCommand(script string) *exec.Cmd {
+	return exec.Command("/bin/sh", "-c", script)
+}
@@ -0,0 +1,35 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"golang.org/x/sys/windows"
+)
+
+// unix-сокетов у PostgreSQL под Windows нет — TCP на localhost
+const defaultDSN = "host=localhost port=5432 user=postgres sslmode=disable"
+
+var defaultLockFile = filepath.Join(os.TempDir(), "postgresql_backup.lock")
+
+// processAlive: сигнала 0 в Windows нет — открываем процесс и смотрим,
+// не завершился ли он (handle завершённого процесса тоже открывается).
+func processAlive(pid int) bool {
+	h, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return false
+	}
+	defer windows.CloseHandle(h)
+	var code uint32
+	const stillActive = 259 // STILL_ACTIVE
+	return windows.GetExitCodeProcess(h, &code) == nil && code == stillActive
+}
+
+// shellCommand — команда хука через cmd /C: /bin/sh в Windows нет.
+func shellCommand(script string) *exec.Cmd {
+	return exec.Command("cmd", "/C", script)
+}
@@ -1,6 +1,3 @@
-//go:build !windows
-// +build !windows
-
 // postgresql-backup — hot physical backup of a local PostgreSQL cluster
 // pure Go, без shell-команд.
 
@@ -9,19 +6,27 @@ package main
 import (
 	"archive/tar"
 	"bufio" // ← вернули: нужен parseFTPConf
-	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"log"
+	"net"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -32,34 +37,209 @@ import (
 /******************** CONFIG & GLOBALS ********************/
 
 var (
-	backupPath string // root for all backups
-	keepDays   int    // local retention (days)
-	maxCopies  int    // keep only N newest daily archives (0 = unlimited)
+	backupPath string            // root for all backups
+	keepDays   int               // local retention (days)
+	maxCopies  int               // keep only N newest daily archives (0 = unlimited)
+	dirMode    = modeFlag(0o700) // mode of the directories we create
+	ownerSpec  string            // --owner user[:group] for created files and dirs
+
+	// GFS: сколько копий хранить в weekly/monthly/yearly (0 = все)
+	keepWeekly  int
+	keepMonthly int
+	keepYearly  int
 
 	// PostgreSQL
-	pgDSN string // connection string
+	pgDSN              string  // connection string
+	allowStandby       bool    // permit backups from a server in recovery
+	recordInDB         bool    // write each successful backup into metadataTable
+	metadataTable      string  // [schema.]table for --record-in-db
+	dataDirOverride    string  // walk this path instead of SHOW data_directory
+	precheckChecksums  bool    // verify pg_control and sampled page checksums first
+	precheckSample     float64 // fraction of pages to verify (1 = all)
+	precheckAbort      bool    // abort the backup if corruption is detected
+	abortOnWALPressure bool    // stop archiving before pg_wal fills the disk
+	walPressureFactor  float64 // warn when pg_wal exceeds this × max_wal_size
+
+	// clusters
+	clusters         clusterList // --cluster name=DSN, repeatable
+	clustersFile     string      // file with one name=DSN per line
+	parallelClusters int         // how many clusters are archived at once
+
+	// logical
+	logicalDump    bool   // --logical: pg_dump of one database instead of the cluster
+	logicalDB      string // --database for --logical
+	logicalDBQuery string // --logical-db-query: SQL returning the databases to dump
+
+	// incremental
+	sinceLSNFlag string // --since-lsn as given
+	sinceLSN     uint64 // only archive relation files changed since this LSN
 
 	// FTP
 	ftpConfFile          string
+	confKeyFile          string // age identities for an encrypted ftpConfFile
 	ftpHost, ftpUser     string
 	ftpPass              string
+	ftpPort              int // port for hosts given without :port
 	ftpKeepFactor        int
 	ftpEnabled           bool
 	ftpKeepFactorFlagged bool
+	ftpTimeout           time.Duration // dial timeout and wait for the post-transfer reply
+	ftpKeepAlive         time.Duration // TCP keepalive period on the control connection
+	ftpTLS               bool          // explicit FTPS (AUTH TLS) for every account
+	ftpTLSInsecure       bool          // skip FTPS certificate verification
+	requireUpload        bool          // a run without any successful upload is a failure
+	streamFTP            bool          // upload while archiving instead of re-reading the file
+	noLocal              bool          // stream to FTP only, no archive on local disk
+	uploadFailMode       string        // "continue" (all accounts) or "fast" (stop at first failure)
+	uploadMode           string        // "any": ftp-conf order is fallback order; "all": every account required
+	uploadRetries        int           // extra attempts per account before giving up on it
+	uploadParallelism    int           // FTP accounts uploaded to at once
+
+	// S3
+	s3Endpoint, s3Bucket     string // S3-compatible storage (MinIO, AWS); http:// endpoint = no TLS
+	s3AccessKey, s3SecretKey string
+	s3Region, s3Prefix       string
+	s3Enabled                bool
+
+	// SFTP
+	sftpHost, sftpUser string // host[:port]
+	sftpKey, sftpPass  string // private key file and/or password
+	sftpDir            string // remote base directory
+	sftpKnownHosts     string // known_hosts for server key verification
+	sftpEnabled        bool
+
+	// archiving
+	bestEffort     bool     // skip unreadable/vanished files instead of aborting
+	partSize       sizeFlag // write/upload chunk size (0 = library defaults)
+	safeRotate     bool     // delete old archives only if a newer one verifies
+	reportToFile   string   // where to write the list of skipped files
+	compression    string   // gzip, zstd or none
+	compressLvl    int      // 0 = the algorithm's default
+	compressThr    int      // parallel compression threads (1 = single-threaded)
+	encryptKeyFile string   // AES-256-GCM key; archives become *.enc
+
+	// excludes
+	excludeIn        listFlag      // extra transient dirs, on top of defaultExcludes
+	excludePatterns  listFlag      // --exclude: paths left out of the archive entirely
+	keepWAL          bool          // --keep-wal: archive pg_wal instead of leaving it empty
+	excludeNewerThan time.Duration // >0: in excluded dirs skip only files modified within this
+	trimZeros        bool          // drop trailing all-zero pages of relation files
+	includeDBs       listFlag      // physical backup of only these databases' base/<oid>
+	pgbbCompat       bool          // write base.tar.gz + <oid>.tar.gz + backup_manifest like pg_basebackup -Ft
+
+	// run guards
+	minBackupInterval time.Duration // skip if the newest archive is younger than this
+	forceBackup       bool          // ignore minBackupInterval
+	restoreOverwrite  bool          // --restore/--restore-file: extract over existing files
+	maxLoad           float64       // wait for load below this before archiving (0 = don't wait)
+	maxWait           time.Duration // ... but not longer than this
+	loadSignal        string        // "active" (pg_stat_activity) or "loadavg"
+
+	// hooks
+	onLockHeld string // command to run when another backup holds the lock
+	noLock     bool   // skip the lock file (external mutual exclusion)
+	lockFile   string // lock path; {cluster} → cluster name, otherwise non-default clusters get .<name>.lock
+
+	// events
+	eventURL   string // nats://… or kafka://… for backup events
+	eventTopic string // subject / topic name
+
+	// service
+	listenAddr   string // --listen: serve /healthz and /metrics
+	metricsFile  string // --metrics-file: textfile collector output
+	scheduleExpr string // --schedule: stay running, back up on this cron spec
+
+	// resources
+	cpuAffinity    string              // CPU list the process is pinned to, e.g. "4-7"
+	readBufferSize = sizeFlag(1 << 20) // buffer for copying files into the archive
 )
 
-const (
+// minReadBufferSize — меньший --read-buffer-size поднимается до него в main.
+const minReadBufferSize = 4096
+
+// цвета — переменные: без TTY их выключает initLogging
+var (
 	green  = "\033[32m"
 	yellow = "\033[33m"
 	red    = "\033[31m"
 	cyan   = "\033[36m"
 	reset  = "\033[0m"
+)
+
+const backupSubdir = "postgresql-backup"
+
+// exit codes — чтобы cron/мониторинг различали причины
+const (
+	exitFailure   = 1 // generic error
+	exitLockHeld  = 2 // another backup is already running
+	exitTooSoon   = 3 // skipped: last backup newer than --min-backup-interval
+	exitUpload    = 4 // archive is local, but an upload target failed after all retries
+	exitDBConnect = 5 // PostgreSQL unreachable
+	exitArchive   = 6 // archive was not created (disk space, I/O, pg_dump)
+	exitWarnings  = 7 // archive is complete, but --best-effort skipped files
+)
 
-	lockFile     = "/tmp/postgresql_backup.lock"
-	backupSubdir = "postgresql-backup"
+var (
+	errDBConnect = errors.New("cannot connect to PostgreSQL")
+	errArchive   = errors.New("archive was not created")
 )
 
-type ftpAccount struct{ Host, User, Pass string }
+// sizeFlag — размер в байтах с суффиксами K/M/G ("512K", "1M").
+type sizeFlag int64
+
+func (s *sizeFlag) String() string { return strconv.FormatInt(int64(*s), 10) }
+
+func (s *sizeFlag) Set(v string) error {
+	n, err := parseSize(v)
+	if err != nil {
+		return err
+	}
+	*s = sizeFlag(n)
+	return nil
+}
+
+func parseSize(v string) (int64, error) {
+	v = strings.ToUpper(strings.TrimSpace(v))
+	v = strings.TrimSuffix(strings.TrimSuffix(v, "B"), "I") // 1MB, 1MiB
+	mult := int64(1)
+	switch {
+	case strings.HasSuffix(v, "K"):
+		mult = 1 << 10
+	case strings.HasSuffix(v, "M"):
+		mult = 1 << 20
+	case strings.HasSuffix(v, "G"):
+		mult = 1 << 30
+	}
+	if mult > 1 {
+		v = v[:len(v)-1]
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("bad size %q", v)
+	}
+	return n * mult, nil
+}
+
+type ftpAccount struct {
+	Host, User, Pass string
+	Port             int  // FTP_PORT в ftp-conf; 0 — --ftp-port
+	TLS              bool // FTP_TLS=true в ftp-conf
+}
+
+func (a ftpAccount) id() string { return a.User + "@" + a.Host }
+
+// addr — host:port для Dial: порт из FTP_HOST (host:2121) важнее FTP_PORT,
+// тот — --ftp-port (по умолчанию 21).
+func (a ftpAccount) addr() string {
+	if _, _, err := net.SplitHostPort(a.Host); err == nil {
+		return a.Host
+	}
+	port := a.Port
+	if port == 0 {
+		port = ftpPort
+	}
+	return net.JoinHostPort(a.Host, strconv.Itoa(port))
+}
 
 var ftpAccounts []ftpAccount
 
@@ -68,22 +248,169 @@ var ftpAccounts []ftpAccount
 func main() {
 	// Flags
 	listFlag := flag.Bool("list", false, "List existing backups and exit")
+	flag.StringVar(&listFormat, "list-format", listFormat, "With --list: text (table) or json")
+	flag.StringVar(&listSince, "since", "", "With --list: only archives from this time on (RFC3339, 2006-01-02 or an age like 7d)")
+	flag.StringVar(&listUntil, "until", "", "With --list: only archives before this time (RFC3339, 2006-01-02 or an age like 7d)")
 	helpFlag := flag.Bool("help", false, "Show help and exit")
+	orphansFlag := flag.Bool("list-ftp-orphans", false, "List remote files that match no archive naming or retention, and exit")
+	verifyAllFlag := flag.Bool("verify-all", false, "Verify every local archive (all clusters and tiers) and exit")
+	verifyJobs := flag.Int("verify-jobs", 2, "With --verify-all: archives verified concurrently")
+	verifyFlag := flag.String("verify", "", "Check an archive against its .sha256 sidecar and exit")
+	decryptFlag := flag.String("decrypt", "", "Decrypt this .enc archive (with --encrypt-key-file; --to sets the output) and exit")
+	reindexFlag := flag.Bool("reindex", false, "Rebuild catalog.json from the backup directories and exit")
+	restoreFlag := flag.String("restore-file", "", "Extract one file or directory (path inside the data dir) from an archive and exit")
+	restoreTo := flag.String("to", "", "With --restore-file: destination path")
+	restoreFrom := flag.String("from", "", "With --restore-file: archive to read (default: newest daily)")
+	restoreAll := flag.String("restore", "", "Extract a whole archive into --restore-to and exit")
+	restoreAllTo := flag.String("restore-to", "", "With --restore: destination directory (must be empty)")
+	flag.BoolVar(&restoreOverwrite, "restore-overwrite", false, "With --restore/--restore-file: extract over existing files and into non-empty directories")
+	flag.Var(&tablespaceMapping, "tablespace-mapping", "With --restore: put tablespace /old/dir into /new/dir (repeatable)")
+	reconcileFlag := flag.Bool("reconcile", false, "Compare local archives with each FTP account (missing, extra, size mismatch) and exit")
+	reuploadFlag := flag.Bool("reupload", false, "With --reconcile: upload archives missing or broken on FTP")
+	deleteFlag := flag.Bool("delete", false, "With --list-ftp-orphans: delete the orphans after confirmation")
 
 	flag.StringVar(&backupPath, "backup-path", "/backup", "Root directory for backups")
 	flag.IntVar(&keepDays, "days", 30, "Days to keep local daily backups")
 	flag.IntVar(&maxCopies, "copies", 0, "Keep only <n> newest daily backups (0 = unlimited)")
 	flag.IntVar(&maxCopies, "c", 0, "Alias for --copies")
-	flag.StringVar(&pgDSN, "dsn",
-		"host=/var/run/postgresql user=postgres sslmode=disable",
-		"PostgreSQL DSN (connection string)")
+	flag.IntVar(&maxCopies, "keep-daily", 0, "Alias for --copies")
+	flag.IntVar(&keepWeekly, "keep-weekly", 8, "Keep only <n> newest weekly copies (0 = all)")
+	flag.IntVar(&keepMonthly, "keep-monthly", 12, "Keep only <n> newest monthly copies (0 = all)")
+	flag.IntVar(&keepYearly, "keep-yearly", 0, "Keep only <n> newest yearly copies (0 = all)")
+	flag.Var(&dirMode, "dir-mode", "Mode of created backup directories (octal)")
+	flag.StringVar(&ownerSpec, "owner", "", "Chown created backup directories and archives to user[:group]")
+	flag.StringVar(&pgDSN, "dsn", defaultDSN, "PostgreSQL DSN (connection string)")
+	flag.Var(&clusters, "cluster", "Back up cluster <name>=<DSN> (repeatable; replaces --dsn)")
+	flag.StringVar(&clustersFile, "clusters-file", "", "Read clusters from <path>: one <name>=<DSN> per line")
+	flag.BoolVar(&logicalDump, "logical", false, "Dump one database as SQL (pg_dump) instead of the physical cluster")
+	flag.StringVar(&logicalDB, "database", "", "With --logical: database to dump")
+	flag.StringVar(&logicalDBQuery, "logical-db-query", "", "With --logical: SQL returning one text column of database names to dump")
+	flag.IntVar(&parallelClusters, "parallel-clusters", 1, "Archive up to <n> clusters concurrently")
+	flag.BoolVar(&recordInDB, "record-in-db", false, "Record each successful backup in a table of the backed-up database")
+	flag.StringVar(&metadataTable, "metadata-table", "public.postgresql_backups", "Table for --record-in-db (created if absent)")
+	flag.StringVar(&dataDirOverride, "data-dir", "", "Archive this path instead of the server-reported data_directory")
+	flag.BoolVar(&precheckChecksums, "precheck-checksums", false, "Check pg_control and sampled page checksums before backing up")
+	flag.Float64Var(&precheckSample, "precheck-sample", 0.01, "Fraction of pages verified by --precheck-checksums (1 = all)")
+	flag.BoolVar(&precheckAbort, "precheck-abort", false, "Abort the backup when --precheck-checksums finds corruption")
+	flag.BoolVar(&abortOnWALPressure, "abort-on-wal-pressure", false, "Abort the backup when WAL accumulates dangerously during archiving")
+	flag.Float64Var(&walPressureFactor, "wal-pressure-factor", 3, "WAL pressure: pg_wal larger than this many max_wal_size (0 = disk check only)")
+	flag.BoolVar(&allowStandby, "allow-standby", false, "Allow backing up a standby (server in recovery)")
 
 	// FTP
 	flag.StringVar(&ftpConfFile, "ftp-conf", "/etc/ftp-backup.conf", "Path to FTP credentials file")
+	flag.StringVar(&confKeyFile, "conf-key-file", "", "age identity file to decrypt an encrypted --ftp-conf")
 	flag.StringVar(&ftpHost, "ftp-host", "", "Override FTP host")
 	flag.StringVar(&ftpUser, "ftp-user", "", "Override FTP username")
-	flag.StringVar(&ftpPass, "ftp-pass", "", "Override FTP password")
-	flag.IntVar(&ftpKeepFactor, "ftp-keep-factor", 4, "Retention multiplier on FTP")
+	flag.StringVar(&ftpPass, "ftp-pass", "", "Override FTP password (visible in ps; prefer --ftp-pass-file)")
+	flag.StringVar(&ftpPassFile, "ftp-pass-file", "", "Read the --ftp-host password from this file")
+	flag.IntVar(&ftpPort, "ftp-port", 21, "FTP port for hosts given without :port")
+	flag.IntVar(&ftpKeepFactor, "ftp-keep-factor", 4, "Retention multiplier on FTP and S3")
+
+	// S3
+	flag.StringVar(&s3Endpoint, "s3-endpoint", "", "S3 endpoint, e.g. s3.amazonaws.com or http://minio:9000")
+	flag.StringVar(&s3Bucket, "s3-bucket", "", "Upload archives to this S3 bucket")
+	flag.StringVar(&s3AccessKey, "s3-access-key", "", "S3 access key")
+	flag.StringVar(&s3SecretKey, "s3-secret-key", "", "S3 secret key")
+	flag.StringVar(&s3Region, "s3-region", "", "S3 region (empty = auto)")
+	flag.StringVar(&s3Prefix, "s3-prefix", "", "Key prefix inside the bucket")
+
+	// SFTP
+	home, _ := os.UserHomeDir()
+	flag.StringVar(&sftpHost, "sftp-host", "", "Upload archives over SFTP to host[:port]")
+	flag.StringVar(&sftpUser, "sftp-user", "", "SFTP user")
+	flag.StringVar(&sftpKey, "sftp-key", "", "SSH private key file for SFTP")
+	flag.StringVar(&sftpPass, "sftp-pass", "", "SFTP password")
+	flag.StringVar(&sftpDir, "sftp-dir", ".", "Remote base directory for SFTP")
+	flag.StringVar(&sftpKnownHosts, "sftp-known-hosts", filepath.Join(home, ".ssh", "known_hosts"), "known_hosts file to verify the SFTP server key")
+	flag.StringVar(&rsyncTarget, "rsync-target", "", "Upload archives with rsync over SSH to [user@]host:/path")
+	flag.DurationVar(&ftpTimeout, "ftp-timeout", 30*time.Second, "FTP dial timeout and wait for the server reply after a transfer")
+	flag.DurationVar(&ftpKeepAlive, "ftp-keepalive", 30*time.Second, "TCP keepalive interval on the FTP control connection (0 = OS default)")
+	flag.BoolVar(&ftpTLS, "ftp-tls", false, "Use explicit FTPS (AUTH TLS) for all FTP accounts")
+	flag.BoolVar(&ftpTLSInsecure, "ftp-tls-insecure", false, "Do not verify the FTPS server certificate")
+
+	flag.BoolVar(&streamFTP, "stream-ftp", false, "Upload to FTP while the archive is written instead of afterwards")
+	flag.BoolVar(&noLocal, "no-local", false, "Stream the archive to FTP only, without a local copy (implies --stream-ftp)")
+	flag.StringVar(&dedupDir, "dedup-dir", "", "Keep files of --dedup-min-size and up once in this SHA-256 blob pool; archives reference them")
+	flag.Var(&dedupMinSize, "dedup-min-size", "Smallest file to deduplicate with --dedup-dir, e.g. 1M")
+	flag.StringVar(&nameTemplate, "name-template", nameTemplate, "Go template for archive names: {{.Host}} {{.Cluster}} {{.Kind}} {{.Time}} {{.Ext}}")
+	flag.StringVar(&walArchivePath, "wal-archive", "", "archive_command mode: compress and upload WAL file %p (optionally followed by %f), then exit")
+	flag.StringVar(&outputPath, "output", "", "Write one archive to <file> or stdout (-) instead of daily/; no rotation or uploads")
+	flag.StringVar(&uploadMode, "upload-mode", "", "any = stop at the first FTP account that succeeds (ftp-conf order), all = fail unless every account succeeds")
+	flag.IntVar(&uploadRetries, "upload-retries", 3, "Retry a failed FTP upload this many times before moving on")
+	flag.IntVar(&uploadRetries, "ftp-retries", 3, "Alias for --upload-retries")
+	flag.IntVar(&uploadParallelism, "upload-parallelism", 4, "Upload to at most this many FTP accounts at once (1 = one after another)")
+	flag.Var(&readRateLimit, "read-rate-limit", "Cap reading of data directory files at <n> bytes/sec while archiving, e.g. 50M")
+	flag.Var(&uploadRateLimit, "upload-rate-limit", "Cap total upload bandwidth at <n> bytes/sec, e.g. 10M (K/M/G suffixes)")
+	flag.StringVar(&uploadFailMode, "upload-fail-mode", "continue", "On an FTP upload failure: continue with other accounts, or fast = stop and fail the run")
+	flag.BoolVar(&requireUpload, "require-upload", false, "Fail the run unless the archive reached at least one FTP account")
+
+	flag.StringVar(&sinceLSNFlag, "since-lsn", "", "Incremental: archive only relation files changed since this LSN")
+	flag.BoolVar(&incremental, "incremental", false, "Archive only files whose size or mtime changed since the previous archive")
+
+	flag.BoolVar(&safeRotate, "safe-rotate", false, "Rotate only when a newer archive passes verification")
+	flag.BoolVar(&safeRotate, "compare-checksum-on-rotate", false, "Alias for --safe-rotate")
+	flag.StringVar(&compression, "compression", "gzip", "Archive compression: gzip (.tar.gz), zstd (.tar.zst) or none (.tar)")
+	flag.StringVar(&archiveContainer, "format", "tar", "Physical archive format: tar (compressed stream) or zip (per-file deflate, random access)")
+	flag.BoolVar(&zstdDict, "zstd-dict", false, "With --format zip --compression zstd: train a zstd dictionary on small files and share it across entries")
+	flag.IntVar(&compressLvl, "compression-level", 0, "Compression level: gzip 1..9, zstd 1..22 (0 = default)")
+	flag.IntVar(&compressThr, "compress-threads", runtime.NumCPU(), "Compress in parallel blocks on this many threads (1 = single-threaded gzip)")
+	flag.StringVar(&gpgPubkeyFile, "gpg-pubkey-file", "", "Encrypt archives to the OpenPGP public key(s) in this file; adds .gpg")
+	flag.StringVar(&gpgRecipient, "gpg-recipient", "", "With --gpg-pubkey-file: use only this key (ID, fingerprint or e-mail)")
+	flag.StringVar(&encryptKeyFile, "encrypt-key-file", "", "Encrypt archives with AES-256-GCM using this key (32 bytes or 64 hex chars); adds .enc")
+	flag.Var(&partSize, "part-size", "Chunk size for archive writes and multipart uploads, e.g. 16M (min 5M)")
+	flag.BoolVar(&bestEffort, "best-effort", false, "Skip unreadable or vanished files instead of aborting")
+	flag.BoolVar(&bestEffort, "skip-errors", false, "Alias for --best-effort")
+	flag.Var(&excludeIn, "exclude-in", "Do not archive the contents of this directory (name or path under data dir; repeatable)")
+	flag.DurationVar(&excludeNewerThan, "exclude-newer-than", 0, "In excluded directories skip only files modified within this duration")
+	flag.Var(&excludePatterns, "exclude", "Leave this file or directory out of the archive (glob, name or path under data dir; repeatable)")
+	flag.BoolVar(&keepWAL, "keep-wal", false, "Archive the contents of pg_wal (for servers without a WAL archive)")
+	flag.BoolVar(&pgbbCompat, "pgbasebackup-compatible", false, "Write a pg_basebackup -Ft style directory: base.tar.gz, <oid>.tar.gz, backup_manifest")
+	flag.Var(&includeDBs, "include-db", "Archive only this database's files under base/ (repeatable; partial backup)")
+	flag.BoolVar(&trimZeros, "trim-zeros", false, "Drop trailing zero pages of relation files (restore must re-extend them, see TRIMMED.txt)")
+	flag.StringVar(&reportToFile, "report-to-file", "", "Write the list of skipped files (path, reason) to this file")
+
+	flag.DurationVar(&minBackupInterval, "min-backup-interval", 0, "Skip the run if the newest archive is younger than this, e.g. 12h (0 = off)")
+	flag.BoolVar(&forceBackup, "force", false, "Back up even if --min-backup-interval says it is too soon")
+	flag.Float64Var(&maxLoad, "max-load", 0, "Delay archiving until the --load-signal value is at most this (0 = off)")
+	flag.DurationVar(&maxWait, "max-wait", time.Hour, "Longest delay for --max-load; then back up anyway")
+	flag.StringVar(&loadSignal, "load-signal", "active", "Load measure for --max-load: active (queries in pg_stat_activity) or loadavg (Linux)")
+
+	// hooks
+	flag.StringVar(&lockFile, "lock-file", defaultLockFile, "Lock file path ({cluster} is replaced by the cluster name)")
+	flag.BoolVar(&noLock, "no-lock", false, "Do not take the lock file (the scheduler guarantees exclusivity)")
+	flag.StringVar(&onLockHeld, "on-lock-held", "", "Command to run when the lock is held by another backup")
+
+	// events
+	flag.StringVar(&eventURL, "event-url", "", "Publish a JSON event per backup to nats://host:4222 or kafka://broker:9092[,broker…]")
+	flag.StringVar(&eventTopic, "event-topic", "postgresql-backup.completed", "NATS subject / Kafka topic for --event-url")
+	flag.StringVar(&notifyURL, "notify-url", "", "POST a JSON summary of each cluster run to this webhook")
+	flag.StringVar(&notifyOn, "notify-on", "failure", "When to call --notify-url: success, failure or always")
+	flag.StringVar(&smtpHost, "smtp-host", "", "Email a report through this SMTP server when a backup or upload fails")
+	flag.IntVar(&smtpPort, "smtp-port", 587, "SMTP port (STARTTLS if offered; 465 = implicit TLS)")
+	flag.StringVar(&smtpUser, "smtp-user", "", "SMTP login")
+	flag.StringVar(&smtpPass, "smtp-pass", "", "SMTP password")
+	flag.StringVar(&smtpFrom, "smtp-from", "", "Sender address for report emails")
+	flag.StringVar(&smtpTo, "smtp-to", "", "Recipients for report emails, comma-separated")
+	flag.BoolVar(&emailOnSuccess, "email-on-success", false, "Email a report after successful runs too")
+	flag.StringVar(&notifyFormat, "notify-format", "json", "Webhook payload: json (backup summary) or slack ({\"text\": …})")
+
+	// service
+	flag.StringVar(&scheduleExpr, "schedule", "", "Run as a service and back up on this cron schedule, e.g. \"0 3 * * *\"")
+	flag.StringVar(&listenAddr, "listen", "", "Serve /healthz and /metrics on this address, e.g. :9000")
+	flag.StringVar(&statusAddr, "status-addr", "", "Serve JSON run status (phase, bytes, last success, last error) on this address, e.g. :8080")
+	flag.StringVar(&metricsFile, "metrics-file", "", "Write Prometheus metrics to this file after each run (node_exporter textfile collector)")
+
+	// resources
+	flag.StringVar(&cpuAffinity, "cpu-affinity", "", "Pin the backup to these CPUs, e.g. 4-7 (Linux only)")
+	flag.Var(&minFreeSpace, "min-free-space", "Keep at least this much free on the backup filesystem after the archive, e.g. 5G")
+	flag.Var(&readBufferSize, "read-buffer-size", "Copy buffer for archived files, e.g. 4M (default 1M)")
+	flag.DurationVar(&progressInterval, "progress-interval", time.Minute, "Log files, bytes and MB/s while archiving every <d> (0 = off)")
+	flag.DurationVar(&backupTimeout, "timeout", 0, "Abort a cluster's backup (queries, archiving, uploads) after <d>; pg_backup_stop still runs (0 = no limit)")
+
+	flag.BoolVar(&dryRun, "dry-run", false, "Show which files would be archived, deleted and uploaded without changing anything")
+	flag.StringVar(&configFile, "config", "", "YAML config: flag names as keys, plus an ftp: list of accounts")
+	flag.BoolVar(&noColor, "no-color", false, "Plain log output without ANSI colors (also NO_COLOR=1)")
+	flag.StringVar(&logFormat, "log-format", "text", "Log format: text (colors only on a terminal) or json (one object per line)")
 
 	flag.Parse()
 
@@ -91,10 +418,58 @@ func main() {
 		printHelp()
 		return
 	}
+	if configFile != "" {
+		if err := loadConfig(configFile); err != nil {
+			log.Fatalf("%s--config: %v%s", red, err, reset)
+		}
+	}
+	if err := initLogging(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	if clustersFile != "" {
+		if err := loadClustersFile(clustersFile, &clusters); err != nil {
+			log.Fatalf("%s--clusters-file: %v%s", red, err, reset)
+		}
+		if len(clusters) == 0 {
+			log.Fatalf("%s--clusters-file %s lists no clusters%s", red, clustersFile, reset)
+		}
+	}
 	if *listFlag {
 		listBackups()
 		return
 	}
+	if *orphansFlag {
+		os.Exit(listFTPOrphans(*deleteFlag))
+	}
+	if *reconcileFlag {
+		os.Exit(reconcile(*reuploadFlag))
+	}
+	if *verifyAllFlag {
+		os.Exit(verifyAll(*verifyJobs))
+	}
+	if *verifyFlag != "" {
+		os.Exit(verifyChecksum(*verifyFlag))
+	}
+	if *decryptFlag != "" {
+		os.Exit(decryptArchive(*decryptFlag, *restoreTo))
+	}
+	if *reindexFlag {
+		os.Exit(reindex())
+	}
+	// раньше перезапись при восстановлении включал --force
+	if forceBackup && (*restoreFlag != "" || *restoreAll != "") {
+		log.Printf("%s--force for restores is deprecated, use --restore-overwrite%s", yellow, reset)
+		restoreOverwrite = true
+	}
+	if *restoreFlag != "" {
+		os.Exit(restoreFile(*restoreFrom, *restoreFlag, *restoreTo))
+	}
+	if *restoreAll != "" {
+		if err := checkTablespaceMapping(); err != nil {
+			log.Fatalf("%s%v%s", red, err, reset)
+		}
+		os.Exit(restoreArchive(*restoreAll, *restoreAllTo))
+	}
 
 	// если пользователь задал --ftp-keep-factor вручную
 	flag.Visit(func(f *flag.Flag) {
@@ -107,15 +482,223 @@ func main() {
 		ftpKeepFactor = 4
 	}
 
+	if sinceLSNFlag != "" {
+		lsn, err := parseLSN(sinceLSNFlag)
+		if err != nil {
+			log.Fatalf("%s--since-lsn: %v%s", red, err, reset)
+		}
+		sinceLSN = lsn
+	}
+
+	if readBufferSize < minReadBufferSize {
+		readBufferSize = minReadBufferSize
+	}
+
+	// 5 MiB — минимальный размер части multipart в S3, 5 GiB — максимальный
+	if partSize != 0 && (partSize < 5<<20 || partSize > 5<<30) {
+		log.Fatalf("%s--part-size must be between 5M and 5G (S3 multipart limits)%s", red, reset)
+	}
+	// до создания компрессора: потоков сжатия по умолчанию столько,
+	// сколько ядер реально досталось процессу
+	if cpuAffinity != "" {
+		if n, err := setCPUAffinity(cpuAffinity); err != nil {
+			log.Printf("%s--cpu-affinity ignored: %v%s", yellow, err, reset)
+		} else {
+			log.Printf("%s🧮 Pinned to CPUs %s (%d cores)%s", cyan, cpuAffinity, n, reset)
+			if !explicitFlags()["compress-threads"] {
+				compressThr = n
+			}
+		}
+	}
+	if c, err := newCompressor(compression, compressLvl, compressThr); err != nil {
+		log.Fatalf("%s--compression: %v%s", red, err, reset)
+	} else {
+		compressor = c
+	}
+	if err := checkArchiveContainer(); err != nil {
+		log.Fatalf("%s--format: %v%s", red, err, reset)
+	}
+
+	if ownerSpec != "" {
+		if err := resolveOwner(ownerSpec); err != nil {
+			log.Fatalf("%s--owner %s: %v%s", red, ownerSpec, err, reset)
+		}
+	}
+
+	if noLocal {
+		if pgbbCompat || incremental || logicalDump {
+			log.Fatalf("%s--no-local cannot be combined with --pgbasebackup-compatible, --incremental or --logical%s", red, reset)
+		}
+		streamFTP = true // --no-local — это --stream-ftp без записи на диск
+	}
+	if dedupDir != "" && (pgbbCompat || noLocal || outputPath != "") {
+		// пул чистится по .dedup рядом с архивами — они должны лежать под --backup-path
+		log.Fatalf("%s--dedup-dir cannot be combined with --pgbasebackup-compatible, --no-local or --output%s", red, reset)
+	}
+	if outputPath != "" && (pgbbCompat || incremental || logicalDump || streamFTP) {
+		log.Fatalf("%s--output cannot be combined with --pgbasebackup-compatible, --incremental, --logical, --stream-ftp or --no-local%s", red, reset)
+	}
+	if pgbbCompat && (sinceLSN > 0 || trimZeros || streamFTP || encryptKeyFile != "" || gpgPubkeyFile != "") {
+		log.Fatalf("%s--pgbasebackup-compatible cannot be combined with --since-lsn, --trim-zeros, --stream-ftp, --encrypt-key-file or --gpg-pubkey-file%s", red, reset)
+	}
+	if keepWAL && pgbbCompat {
+		log.Fatalf("%s--keep-wal cannot be combined with --pgbasebackup-compatible%s", red, reset)
+	}
+	if incremental && (sinceLSN > 0 || pgbbCompat || logicalDump) {
+		log.Fatalf("%s--incremental cannot be combined with --since-lsn, --pgbasebackup-compatible or --logical%s", red, reset)
+	}
+	if nameTemplate != defaultNameTemplate {
+		if err := parseNameTemplate(nameTemplate, incremental || sinceLSN > 0); err != nil {
+			log.Fatalf("%s--name-template: %v%s", red, err, reset)
+		}
+	}
+	if encryptKeyFile != "" {
+		if _, err := archiveKey(); err != nil {
+			log.Fatalf("%s--encrypt-key-file: %v%s", red, err, reset)
+		}
+	}
+	if gpgRecipient != "" && gpgPubkeyFile == "" {
+		log.Fatalf("%s--gpg-recipient picks a key from --gpg-pubkey-file, which is not set%s", red, reset)
+	}
+	if gpgPubkeyFile != "" {
+		if encryptKeyFile != "" || dedupDir != "" {
+			// блобы пула тоже стали бы .gpg, а собрать из них архив некому
+			log.Fatalf("%s--gpg-pubkey-file cannot be combined with --encrypt-key-file or --dedup-dir%s", red, reset)
+		}
+		if _, err := gpgRecipients(); err != nil {
+			log.Fatalf("%s--gpg-pubkey-file: %v%s", red, err, reset)
+		}
+	}
+
+	if eventURL != "" && !strings.HasPrefix(eventURL, "nats://") &&
+		!strings.HasPrefix(eventURL, "tls://") && !strings.HasPrefix(eventURL, "kafka://") {
+		log.Fatalf("%s--event-url must start with nats://, tls:// or kafka://%s", red, reset)
+	}
+	if err := checkNotifyFlags(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	if err := checkSMTPFlags(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	if smtpHost != "" {
+		log.SetOutput(io.MultiWriter(log.Writer(), &emailTail))
+	}
+
+	if len(clusters) == 0 {
+		clusters = clusterList{{Name: defaultCluster, DSN: pgDSN}}
+	}
+	if len(clusters) > 1 && (sinceLSN > 0 || dataDirOverride != "" || outputPath != "") {
+		log.Fatalf("%s--since-lsn, --data-dir and --output apply to a single cluster only%s", red, reset)
+	}
+	if logicalDump {
+		switch {
+		case logicalDB == "" && logicalDBQuery == "":
+			log.Fatalf("%s--logical needs --database <name> or --logical-db-query <sql>%s", red, reset)
+		case logicalDB != "" && logicalDBQuery != "":
+			log.Fatalf("%s--database and --logical-db-query are mutually exclusive%s", red, reset)
+		case len(clusters) > 1:
+			log.Fatalf("%s--logical dumps from a single cluster only%s", red, reset)
+		case sinceLSN > 0 || pgbbCompat:
+			log.Fatalf("%s--logical cannot be combined with --since-lsn or --pgbasebackup-compatible%s", red, reset)
+		}
+	}
+
+	if logicalDBQuery != "" && !logicalDump {
+		log.Fatalf("%s--logical-db-query needs --logical%s", red, reset)
+	}
+
+	if uploadFailMode != "continue" && uploadFailMode != "fast" {
+		log.Fatalf("%s--upload-fail-mode must be fast or continue%s", red, reset)
+	}
+	if uploadParallelism < 1 {
+		log.Fatalf("%s--upload-parallelism must be at least 1%s", red, reset)
+	}
+	if loadSignal != "active" && loadSignal != "loadavg" {
+		log.Fatalf("%s--load-signal must be active or loadavg%s", red, reset)
+	}
+	switch uploadMode {
+	case "", "all":
+	case "any":
+		// с потоком архив уходит на все аккаунты сразу — порядка нет
+		if streamFTP || uploadFailMode == "fast" {
+			log.Fatalf("%s--upload-mode any cannot be combined with --stream-ftp or --upload-fail-mode fast%s", red, reset)
+		}
+	default:
+		log.Fatalf("%s--upload-mode must be any or all%s", red, reset)
+	}
+
 	initFTP()
+	if err := checkS3Flags(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	s3Enabled = s3Bucket != ""
+	if err := checkSFTPFlags(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	sftpEnabled = sftpHost != ""
+	if err := checkRsyncFlags(); err != nil {
+		log.Fatalf("%s%v%s", red, err, reset)
+	}
+	rsyncEnabled = rsyncTarget != ""
+	if noLocal && (!ftpEnabled || s3Enabled || sftpEnabled || rsyncEnabled) {
+		// S3, SFTP и rsync загружают готовый файл, а его не будет
+		log.Fatalf("%s--no-local streams to FTP only: needs --ftp-conf or --ftp-host, no --s3-bucket, --sftp-host or --rsync-target%s", red, reset)
+	}
+	if outputPath != "" {
+		if requireUpload {
+			log.Fatalf("%s--output cannot be combined with --require-upload%s", red, reset)
+		}
+		if ftpEnabled || s3Enabled || sftpEnabled || rsyncEnabled {
+			log.Printf("%s--output: uploads are skipped, the archive goes to %s only%s", yellow, outputPath, reset)
+		}
+		ftpEnabled, s3Enabled, sftpEnabled, rsyncEnabled = false, false, false, false
+	}
+	if dedupDir != "" && (ftpEnabled || s3Enabled || sftpEnabled || rsyncEnabled) {
+		log.Printf("%s⚠️  --dedup-dir: uploaded archives reference blobs in %s, which are not uploaded — back up that directory too%s",
+			yellow, dedupDir, reset)
+	}
+	if requireUpload && !ftpEnabled && !s3Enabled && !sftpEnabled && !rsyncEnabled {
+		log.Fatalf("%s--require-upload needs an upload target (--ftp-conf, --ftp-host, --s3-bucket, --sftp-host or --rsync-target)%s", red, reset)
+	}
+	if dryRun {
+		// ничего не пишем: ни lock, ни метрики, ни события, ни webhook/почту, ни запись в БД
+		noLock, metricsFile, eventURL, notifyURL, smtpHost, recordInDB = true, "", "", "", "", false
+		log.Printf("%s🧪 Dry run: nothing is written, deleted or uploaded%s", yellow, reset)
+	}
+
+	if walArchivePath != "" {
+		// archive_command: без lock — идёт параллельно с базовыми бэкапами
+		os.Exit(archiveWAL(walArchivePath, flag.Arg(0)))
+	}
+
+	if noLock && !dryRun {
+		log.Printf("%s🔓 --no-lock: concurrent runs are NOT prevented%s", yellow, reset)
+	}
+	if metricsFile != "" {
+		loadMetricsFile(metricsFile)
+	}
+	if listenAddr != "" {
+		startHealthServer(listenAddr)
+	}
+	if statusAddr != "" && statusAddr != listenAddr {
+		startStatusServer(statusAddr)
+	}
+	if scheduleExpr != "" {
+		os.Exit(runScheduled(scheduleExpr))
+	}
 
-	acquireLock()
-	defer releaseLock()
 	sig := make(chan os.Signal, 1)
 	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
-	go func() { <-sig; releaseLock(); os.Exit(1) }()
+	go func() {
+		<-sig
+		log.Printf("%sInterrupted: stopping the backup cleanly (signal again to exit now)%s", yellow, reset)
+		cancelRun(errInterrupted)
+		<-sig
+		releaseAllLocks()
+		os.Exit(exitFailure)
+	}()
 
-	runBackup()
+	os.Exit(runClusters(clusters))
 }
 
 /******************** HELP & LIST ********************/
@@ -125,164 +708,1082 @@ func printHelp() {
 	fmt.Printf("%s📦 PostgreSQL Backup Utility%s\n\n", cyan, reset)
 	fmt.Printf("Usage:\n  %s [flags]\n\n", exe)
 	fmt.Println("Flags:")
-	fmt.Println("  --dsn <conn>             PostgreSQL DSN (default: local socket)")
+	fmt.Println("  --config <file>          YAML config (flag names as keys, ftp: accounts); flags win")
+	fmt.Println("  --log-format text|json   json: one object per line for log shippers; colors off without a TTY")
+	fmt.Println("  --no-color               No ANSI colors (also NO_COLOR=1; automatic without a TTY)")
+	fmt.Println("  --dry-run                List files to archive, archives to delete and uploads; change nothing")
+	fmt.Println("  --dsn <conn>             PostgreSQL DSN (default: local server)")
+	fmt.Println("  --cluster <name>=<dsn>   Back up several clusters (repeatable) into <name>/ dirs")
+	fmt.Println("  --logical --database <d> SQL dump of one database (pg_dump) into logical/<d>/")
+	fmt.Println("  --logical-db-query <sql> With --logical: dump every database the query returns (one text column)")
+	fmt.Println("  --clusters-file <path>   Clusters from a file, one <name>=<dsn> per line (# comments)")
+	fmt.Println("  --parallel-clusters <n>  Archive up to n clusters concurrently (1)")
+	fmt.Println("  --data-dir <dir>         Archive <dir> instead of SHOW data_directory (containers, bind mounts)")
+	fmt.Println("  --precheck-checksums     Verify pg_control and sampled page checksums before backup")
+	fmt.Println("  --precheck-sample <f>    Fraction of pages to verify (0.01; 1 = all)")
+	fmt.Println("  --precheck-abort         Abort when the precheck finds corruption")
+	fmt.Println("  --abort-on-wal-pressure  Abort if pg_wal grows past --wal-pressure-factor × max_wal_size (3) or <5% disk free")
+	fmt.Println("  --allow-standby          Allow backing up a standby (non-exclusive, no WAL switch)")
+	fmt.Println("  --record-in-db           Record each backup in --metadata-table <name> (public.postgresql_backups)")
 	fmt.Println("  --backup-path <dir>      Root directory for backups (/backup)")
+	fmt.Println("  --dir-mode <mode>        Mode of created backup directories (0700)")
+	fmt.Println("  --owner <user[:group]>   Chown created directories and archives")
 	fmt.Println("  --days <n>               Days to keep local daily backups (30)")
 	fmt.Println("  --copies, -c <n>         Keep only N newest daily archives (0 = unlimited)")
-	fmt.Println("  --list                   List backups and exit")
+	fmt.Println("  --keep-daily <n>         Alias for --copies")
+	fmt.Println("  --keep-weekly <n>        Keep only N newest weekly copies (8; 0 = all)")
+	fmt.Println("  --keep-monthly <n>       Keep only N newest monthly copies (12; 0 = all)")
+	fmt.Println("  --keep-yearly <n>        Keep only N newest yearly copies (0 = all, default)")
+	fmt.Println("  --safe-rotate            Delete old archives only if a newer one passes verification")
+	fmt.Println("  --list                   List backups of all tiers with size, age and FTP copies, and exit")
+	fmt.Println("  --list-format <f>        With --list: text table or json (text)")
+	fmt.Println("  --since <t>, --until <t> With --list: archives in [since, until): RFC3339, 2006-01-02 or age (7d, 12h)")
+	fmt.Println("  --verify-all             Check compression checksums and tar structure of every local archive, exit 1 on failure")
+	fmt.Println("  --verify-jobs <n>        Archives verified in parallel by --verify-all (2)")
+	fmt.Println("  --verify <archive>       Compare an archive with its .sha256 sidecar, exit 1 on mismatch")
+	fmt.Println("  --reindex                Rebuild catalog.json (the index --list reads) from the backup directories")
+	fmt.Println("  --restore-file <p> --to <dest> [--from <archive>]  Extract one file/subtree, checked against backup_manifest")
+	fmt.Println("  --restore <archive> --restore-to <dir>            Extract a whole archive into an empty directory")
+	fmt.Println("  --tablespace-mapping <old>=<new>                  With --restore: relocate a tablespace (repeatable)")
+	fmt.Println("  --restore-overwrite                               With --restore/--restore-file: overwrite existing files")
+	fmt.Println("  --list-ftp-orphans       List stray/expired remote files; add --delete to remove them")
+	fmt.Println("  --reconcile              Compare local tiers with each FTP: local-only, remote-only, size mismatch")
+	fmt.Println("  --reupload               With --reconcile: upload the local-only and mismatched archives again")
 	fmt.Println("  --ftp-conf <file>        FTP credentials file (/etc/ftp-backup.conf)")
+	fmt.Println("  --conf-key-file <file>   age key for an encrypted --ftp-conf (or $POSTGRESQL_BACKUP_CONF_KEY)")
 	fmt.Println("  --ftp-host/user/pass     Override credentials from file")
-	fmt.Println("  --ftp-keep-factor <n>    Days on FTP = days * n (default 4)")
-}
-
-func listBackups() {
-	host, _ := os.Hostname()
-	root := filepath.Join(backupPath, host, backupSubdir, "cluster", "daily")
-	files, err := os.ReadDir(root)
-	if err != nil {
-		log.Fatalf("%sCannot open %s: %v%s", red, root, err, reset)
-	}
-	for _, f := range files {
-		fmt.Println(f.Name())
-	}
+	fmt.Println("  --ftp-pass-file <file>   Password for --ftp-host from a file (or $FTP_PASS_FILE, $FTP_PASSWORD)")
+	fmt.Println("  --ftp-port <n>           Port for hosts without :port (21; per account: FTP_PORT)")
+	fmt.Println("  --ftp-keep-factor <n>    Days on FTP/S3 = days * n, copies and --keep-* likewise (default 4)")
+	fmt.Println("  --s3-endpoint <e>        S3/MinIO endpoint, e.g. s3.amazonaws.com or http://minio:9000")
+	fmt.Println("  --s3-bucket <b>          Upload to this bucket (with --s3-access-key, --s3-secret-key)")
+	fmt.Println("  --s3-region <r>          Bucket region (auto)")
+	fmt.Println("  --s3-prefix <p>          Key prefix inside the bucket")
+	fmt.Println("  --sftp-host <h[:port]>   Upload over SFTP (with --sftp-user and --sftp-key or --sftp-pass)")
+	fmt.Println("  --sftp-dir <dir>         Remote base directory (default: login directory)")
+	fmt.Println("  --sftp-known-hosts <f>   known_hosts used to verify the server key (~/.ssh/known_hosts)")
+	fmt.Println("  --rsync-target <dest>    Upload with rsync over SSH to [user@]host:/path (resumable, delta vs. last archive)")
+	fmt.Println("  --ftp-timeout <dur>      Dial timeout / wait for reply after transfer (30s)")
+	fmt.Println("  --ftp-keepalive <dur>    TCP keepalive on the control connection (30s)")
+	fmt.Println("  --ftp-tls                Explicit FTPS (AUTH TLS) for all accounts (per account: FTP_TLS=true)")
+	fmt.Println("  --ftp-tls-insecure       Skip FTPS certificate verification")
+	fmt.Println("  --stream-ftp             Upload while archiving (no second read of the archive)")
+	fmt.Println("  --no-local               Stream to FTP only, keep no local archive (no local rotation)")
+	fmt.Println("  --dedup-dir <dir>        Store big files once in a shared SHA-256 pool; --restore needs it too")
+	fmt.Println("  --dedup-min-size <n>     Smallest file to deduplicate (1M)")
+	fmt.Println("  --name-template <t>      Archive name, e.g. '{{.Host}}_{{.Cluster}}_{{.Time}}{{.Ext}}' (must end with {{.Ext}})")
+	fmt.Println("  --wal-archive <p> [<f>]  archive_command mode: compress WAL file <p> into <cluster>/wal/, upload it, exit 0 once stored")
+	fmt.Println("  --output <file|->        Write the archive to <file> or stdout, skip daily/, rotation and uploads")
+	fmt.Println("  --upload-mode <m>        any: accounts in ftp-conf order, stop at first success, exit 4 if none;")
+	fmt.Println("                           all: exit 4 unless every account got the archive (default: try all, exit 4 if any failed)")
+	fmt.Println("  --upload-retries <n>     Retry a failed upload n times (10s, 20s, 40s … apart) before the next account (3)")
+	fmt.Println("  --ftp-retries <n>        Alias for --upload-retries")
+	fmt.Println("  --upload-parallelism <n> Upload to up to n FTP accounts at once (4; 1 = one after another)")
+	fmt.Println("  --upload-rate-limit <n>  Total upload bandwidth cap in bytes/sec, e.g. 10M (unlimited)")
+	fmt.Println("  --read-rate-limit <n>    Data directory read cap in bytes/sec while archiving, e.g. 50M (unlimited)")
+	fmt.Println("  --upload-fail-mode <m>   continue: try every FTP account; fast: stop at first failure; exit 4 either way")
+	fmt.Println("  --require-upload         Fail (exit 4) if no FTP account received the archive")
+	fmt.Println("  --since-lsn <X/Y>        Incremental: only relation files with pages newer than LSN")
+	fmt.Println("  --incremental            Only files whose size/mtime changed since the previous archive; --restore applies the chain")
+	fmt.Println("  --compression <c>        gzip (.tar.gz, default), zstd (.tar.zst) or none (.tar)")
+	fmt.Println("  --format <f>             tar (default) or zip: .zip with per-file compression, extract single files with unzip")
+	fmt.Println("  --zstd-dict              zip + zstd: train a dictionary on small files and share it across entries")
+	fmt.Println("  --compression-level <n>  gzip 1..9, zstd 1..22 (default: the algorithm's default)")
+	fmt.Println("  --compress-threads <n>   Parallel compression threads (default: number of CPUs; 1 = classic gzip)")
+	fmt.Println("  --encrypt-key-file <f>   Encrypt archives (AES-256-GCM, key: 32 bytes or 64 hex chars), name gets .enc")
+	fmt.Println("  --gpg-pubkey-file <f>    Encrypt archives to an OpenPGP public key, name gets .gpg (decrypt with gpg)")
+	fmt.Println("  --gpg-recipient <id>     With --gpg-pubkey-file: key ID, fingerprint or e-mail to encrypt to (all keys)")
+	fmt.Println("  --decrypt <file.enc>     Decrypt an archive (needs --encrypt-key-file; --to <out>) and exit")
+	fmt.Println("  --part-size <n>          Archive write / multipart chunk size, 5M..5G (default: unbuffered)")
+	fmt.Println("  --best-effort            Skip unreadable/vanished files, record them in skipped_files.txt (exit 7)")
+	fmt.Println("  --skip-errors            Alias for --best-effort")
+	fmt.Println("  --exclude-in <dir>       Skip contents of transient dirs (repeatable; pg_wal, pg_replslot, pg_stat_tmp, … always)")
+	fmt.Println("  --exclude <glob>         Leave a file or whole directory out of the archive (repeatable)")
+	fmt.Println("  --keep-wal               Archive pg_wal too (default: empty pg_wal; for servers without a WAL archive)")
+	fmt.Println("  --exclude-newer-than <d> In excluded dirs skip only files modified within <d>")
+	fmt.Println("  --pgbasebackup-compatible  Write base.tar.gz, <oid>.tar.gz and backup_manifest as pg_basebackup -Ft -z -X none")
+	fmt.Println("  --include-db <name>      Only this database's base/<oid> (repeatable); partial, non-standard backup")
+	fmt.Println("  --trim-zeros             Drop trailing zero pages of relation files; re-extend from TRIMMED.txt on restore")
+	fmt.Println("  --report-to-file <file>  Also write the skipped-files report to <file>")
+	fmt.Println("  --min-backup-interval <d> Skip (exit 3) if the last archive is younger than <d>")
+	fmt.Println("  --force                  Ignore --min-backup-interval")
+	fmt.Println("  --max-load <n>           Wait until load is at most n before archiving (0 = off)")
+	fmt.Println("  --max-wait <d>           Give up waiting for --max-load after <d> and back up anyway (1h)")
+	fmt.Println("  --load-signal <s>        active: active queries in pg_stat_activity; loadavg: 1-min load (Linux)")
+	fmt.Printf("  --lock-file <path>       Lock file (default %s; {cluster} → cluster name)\n", defaultLockFile)
+	fmt.Println("  --no-lock                Skip the lock file (only if an orchestrator serializes runs)")
+	fmt.Println("  --on-lock-held <cmd>     Run <cmd> (/bin/sh -c; cmd /C on Windows) when another backup is running")
+	fmt.Println("  --event-url <url>        Publish a JSON event per backup to nats://… or kafka://… (best effort)")
+	fmt.Println("  --event-topic <name>     Subject/topic for events (postgresql-backup.completed)")
+	fmt.Println("  --notify-url <url>       POST a JSON summary per cluster to a webhook (best effort)")
+	fmt.Println("  --notify-on <when>       success, failure (default) or always")
+	fmt.Println("  --notify-format <f>      json (default) or slack")
+	fmt.Println("  --smtp-host <host>       Email failures (error + last log lines) via SMTP")
+	fmt.Println("  --smtp-port <n>          SMTP port (587; STARTTLS if offered, 465 = TLS)")
+	fmt.Println("  --smtp-user/--smtp-pass  SMTP login")
+	fmt.Println("  --smtp-from <addr>       Sender address")
+	fmt.Println("  --smtp-to <a,b>          Recipients, comma-separated")
+	fmt.Println("  --email-on-success       Also email after successful runs")
+	fmt.Println("  --schedule <cron>        Stay running and back up on a cron schedule (\"0 3 * * *\", @daily)")
+	fmt.Println("  --listen <addr>          Serve /healthz and Prometheus /metrics, e.g. :9000 (off)")
+	fmt.Println("  --status-addr <addr>     Serve JSON /status: phase, bytes archived, last success and error, e.g. :8080 (off)")
+	fmt.Println("  --metrics-file <path>    Write the same metrics to <path>.prom after each run (textfile collector)")
+	fmt.Println("  --cpu-affinity <list>    Pin to CPUs, e.g. 4-7 or 0,2 (Linux; sets GOMAXPROCS)")
+	fmt.Println("  --min-free-space <n>     Abort unless data dir size + n fits on the backup disk, e.g. 5G (0)")
+	fmt.Println("  --read-buffer-size <n>   Copy buffer per file read, e.g. 4M (default 1M)")
+	fmt.Println("  --progress-interval <d>  Log files, bytes and MB/s while archiving (default 1m, 0 = off)")
+	fmt.Println("  --timeout <d>            Abort a cluster's backup and uploads after <d>, stopping it cleanly (0 = no limit)")
+	fmt.Println("\nExit codes:")
+	fmt.Println("  0 success, 1 error, 2 skipped: another backup holds the lock,")
+	fmt.Println("  3 skipped: last backup newer than --min-backup-interval,")
+	fmt.Println("  4 upload failed (archive kept locally), 5 cannot connect to PostgreSQL,")
+	fmt.Println("  6 archive was not created, 7 completed with warnings: --best-effort skipped files")
 }
 
 /******************** BACKUP LOOP ********************/
 
-func runBackup() {
+// runBackup делает бэкап одного кластера и возвращает путь к архиву.
+// Ошибки возвращаются, а не валят процесс: остальные кластеры продолжат.
+func runBackup(cl cluster) (string, int, error) {
 	now := time.Now()
 	host, _ := os.Hostname()
+	ctx, cancel := backupContext()
+	defer cancel()
+	if err := ctxErr(ctx); err != nil {
+		return "", 0, err // прерваны до этого кластера
+	}
 
-	db, err := sql.Open("postgres", pgDSN)
+	db, err := sql.Open("postgres", cl.DSN)
 	if err != nil {
-		log.Fatalf("%sCannot connect to PostgreSQL: %v%s", red, err, reset)
+		return "", 0, fmt.Errorf("%w: %w", errDBConnect, err)
 	}
 	defer db.Close()
 
-	// 1) start backup
+	var standby bool
+	if err := db.QueryRowContext(ctx, `SELECT pg_is_in_recovery()`).Scan(&standby); err != nil {
+		return "", 0, fmt.Errorf("%w: %w", errDBConnect, err)
+	}
+	if standby && !allowStandby {
+		return "", 0, fmt.Errorf("server is in recovery (standby); refusing without --allow-standby")
+	}
+
+	// на standby только non-exclusive режим: start и stop в одной сессии
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return "", 0, fmt.Errorf("%w: %w", errDBConnect, err)
+	}
+	defer conn.Close()
+
+	// 1) data_directory
+	var dataDir string
+	if err := db.QueryRowContext(ctx, `SHOW data_directory`).Scan(&dataDir); err != nil {
+		return "", 0, fmt.Errorf("cannot determine data_directory: %w", err)
+	}
+	if dataDirOverride != "" {
+		// bind mount / контейнер: сервер видит один путь, мы — другой
+		if filepath.Clean(dataDirOverride) != filepath.Clean(dataDir) {
+			log.Printf("%s⚠️  --data-dir %s differs from server data_directory %s%s",
+				yellow, dataDirOverride, dataDir, reset)
+		}
+		dataDir = dataDirOverride
+	}
+	if err := checkLocalDataDir(db, dataDir); err != nil {
+		return "", 0, err
+	}
+	opts := archiveOpts{BlockSize: 8192, Cluster: cl.Name, KeepWAL: keepWAL}
+	// без archive_mode WAL бэкапа есть только в pg_wal — предупреждаем,
+	// но в архив его кладёт только явный --keep-wal
+	var archiveMode string
+	if err := db.QueryRowContext(ctx, `SHOW archive_mode`).Scan(&archiveMode); err == nil && archiveMode == "off" && !keepWAL && !pgbbCompat {
+		log.Printf("%s⚠️  archive_mode is off: the archive has no WAL to recover with; set up a WAL archive or use --keep-wal%s", yellow, reset)
+	}
+	if streamFTP && ftpEnabled {
+		opts.Stream = newFTPStream(ctx)
+	}
+	if opts.Server, err = queryServerInfo(ctx, db); err != nil {
+		return "", 0, fmt.Errorf("cannot read server version and settings: %w", err)
+	}
+	opts.BlockSize = opts.Server.BlockSize
+	log.Printf("%s🐘 PostgreSQL %s, data_checksums %s, wal_level %s%s", cyan, opts.Server.Version,
+		opts.Server.DataChecksums, opts.Server.WALLevel, reset)
+	if !pgbbCompat { // у --pgbasebackup-compatible свои <oid>.tar
+		if opts.Tablespaces, err = externalTablespaces(ctx, db); err != nil {
+			return "", 0, fmt.Errorf("cannot list tablespaces: %w", err)
+		}
+		for oid, loc := range opts.Tablespaces {
+			log.Printf("%s🗂  Tablespace %s at %s → pg_tblspc/%s in the archive%s", cyan, oid, loc, oid, reset)
+		}
+	}
+	if len(includeDBs) > 0 {
+		if opts.IncludeOIDs, err = resolveIncludeDBs(db); err != nil {
+			return "", 0, err
+		}
+		log.Printf("%s⚠️  --include-db %s: partial physical backup — other databases are missing, "+
+			"the result only suits a restore where just these databases matter%s", yellow, includeDBs.String(), reset)
+	}
+	if sinceLSN > 0 {
+		log.Printf("%s🧩 Incremental since LSN %s%s", cyan, formatLSN(sinceLSN), reset)
+	}
+
+	// 2) corruption precheck — до старта, чтобы не оставлять сессию бэкапа
+	if precheckChecksums {
+		if n := precheckCluster(db, dataDir, opts.BlockSize); n > 0 {
+			if precheckAbort {
+				return "", 0, fmt.Errorf("pre-backup check found %d problem(s), not backing up corrupt data", n)
+			}
+			log.Printf("%s⚠️  %d corruption problem(s) detected — backing up anyway%s", red, n, reset)
+		}
+	}
+
+	if incremental {
+		opts.Base = incrementalBase(filepath.Join(backupPath, host, backupSubdir, cl.Name, "daily"), now)
+	}
+
+	if dryRun {
+		archive, err := dryRunBackup(cl, dataDir, host, now, opts)
+		return archive, 0, err
+	}
+
+	// 3) quiet window — до старта бэкапа, чтобы не держать его открытым зря
+	waitQuietWindow(ctx, db)
+	if err := ctxErr(ctx); err != nil {
+		return "", 0, err
+	}
+
+	// 4) start backup — только non-exclusive: exclusive-режима нет в Pg 15+,
+	// а backup_label из pg_backup_stop должен попасть в архив
 	var lsn string
-	if err := db.QueryRow(`SELECT lsn FROM pg_backup_start(false)`).Scan(&lsn); err != nil {
-		// fallback ≤14
-		if err := db.QueryRow(`SELECT pg_start_backup('go-backup', true)`).Scan(&lsn); err != nil {
-			log.Fatalf("%sCannot start backup: %v%s", red, err, reset)
+	if lsn, err = startNonExclusiveBackup(ctx, conn); err != nil {
+		if standby {
+			return "", 0, fmt.Errorf("cannot start backup on standby: %w", err)
 		}
+		return "", 0, fmt.Errorf("cannot start backup: %w", err)
+	}
+	if standby {
+		log.Printf("%s🛰  Standby backup: no WAL switch, no wait for archiving%s", yellow, reset)
 	}
 	log.Printf("%s🚀 Backup started at LSN %s%s", cyan, lsn, reset)
+	opts.StartLSN = lsn
 
-	// 2) data_directory
-	var dataDir string
-	if err := db.QueryRow(`SHOW data_directory`).Scan(&dataDir); err != nil {
-		log.Fatalf("%sCannot determine data_directory: %v%s", red, err, reset)
+	// 5) stop backup при любом выходе — ошибка, паника, отмена: иначе
+	// сервер остаётся в режиме бэкапа и копит WAL до рестарта
+	var stopLSN string
+	stopped := false
+	abortBackup := func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		// ctx может быть уже отменён — у pg_backup_stop свой срок
+		sctx, scancel := stopContext(ctx)
+		defer scancel()
+		if _, _, _, err := stopNonExclusiveBackup(sctx, conn, false); err != nil {
+			log.Printf("%sCannot stop backup: %v%s", red, err, reset)
+			return
+		}
+		log.Printf("%s🛑 Backup mode ended without an archive%s", yellow, reset)
+	}
+	defer abortBackup()
+
+	// 6) archive
+	mon := startWALMonitor(db, lsn, dataDir)
+	// --timeout или сигнал обрывают архивацию так же, как давление WAL
+	opts.Cancelled = func() error {
+		if err := ctxErr(ctx); err != nil {
+			return err
+		}
+		return mon.err()
+	}
+	// backup_label и tablespace_map пишутся в архив последними записями:
+	// останавливаем бэкап изнутри архивации, пока архив открыт
+	opts.Stop = func() (string, string, string, error) {
+		stop, label, spcmap, err := stopNonExclusiveBackup(ctx, conn, !standby)
+		stopped = err == nil
+		stopLSN = stop
+		return stop, label, spcmap, err
+	}
+	setPhase(cl.Name, "archiving")
+	archivePath, st, err := backupCluster(cl, dataDir, host, now, opts)
+	mon.finish()
+	if err != nil {
+		abortBackup() // сразу, а не после загрузок
+		if opts.Stream != nil {
+			opts.Stream.abort()
+		}
+		if cerr := ctxErr(ctx); cerr != nil {
+			return "", 0, cerr
+		}
+		return "", 0, fmt.Errorf("%w: %w", errArchive, err)
 	}
 
-	// 3) archive
-	archivePath := backupCluster(dataDir, host, now)
+	skipped := 0
+	if st != nil && len(st.Skipped) > 0 {
+		skipped = len(st.Skipped)
+		log.Printf("%s⚠️  Backup finished with %d skipped file(s) of %d (%s) — see skipped_files.txt in the archive%s",
+			yellow, skipped, st.Files+skipped, skipSummary(st.Skipped), reset)
+	} else {
+		log.Printf("%s✅ Backup finished%s", green, reset)
+	}
+	if recordInDB {
+		recordBackupInDB(db, standby, backupRecord{
+			Host: host, Started: now, Finished: time.Now(),
+			StartLSN: lsn, StopLSN: stopLSN, Location: archivePath,
+		})
+	}
 
-	// 4) stop backup
-	if _, err := db.Exec(`SELECT pg_backup_stop(false)`); err != nil {
-		_, _ = db.Exec(`SELECT pg_stop_backup()`) // fallback
+	if outputPath != "" {
+		return archivePath, skipped, nil // --output: без загрузок и каталога
+	}
+
+	// 7) FTP, S3
+	setPhase(cl.Name, "uploading")
+	uploads, targets := uploadArchive(ctx, archivePath, opts.Stream)
+	uploadTierCopies(ctx, archivePath)
+	recordUploads(cl.Name, uploads)
+	updateCatalog(cl, archivePath, catalogEntry{Time: now, StartLSN: lsn, StopLSN: stopLSN, Uploads: uploads})
+	if err := checkUploads(archivePath, uploads, targets); err != nil {
+		return "", 0, err
+	}
+	return archivePath, skipped, nil
+}
+
+// uploadArchive отправляет готовый архив на FTP (или дожидается потока
+// --stream-ftp), затем на S3 и SFTP — в порядке --upload-mode any; fast не
+// идёт дальше сбоя. Возвращает результаты по целям и число целей.
+func uploadArchive(ctx context.Context, archivePath string, stream *ftpStream) (map[string]bool, int) {
+	uploads := map[string]bool{}
+	targets := len(ftpAccounts)
+	if ftpEnabled {
+		if stream != nil {
+			uploads = stream.finish(archivePath)
+		} else {
+			uploads = uploadToFTP(ctx, archivePath, ftpRemoteRel(archivePath))
+		}
+	}
+	type target struct {
+		id     string
+		upload func(context.Context, string) bool
+	}
+	var extra []target
+	if s3Enabled {
+		extra = append(extra, target{s3Target(), uploadToS3})
+	}
+	if sftpEnabled {
+		extra = append(extra, target{sftpTarget(), uploadToSFTP})
+	}
+	if rsyncEnabled {
+		extra = append(extra, target{rsyncTargetID(), uploadToRsync})
+	}
+	for _, t := range extra {
+		targets++
+		n := countUploaded(uploads)
+		switch {
+		case uploadMode == "any" && n > 0:
+		case uploadFailMode == "fast" && n < len(uploads):
+		default:
+			uploads[t.id] = t.upload(ctx, archivePath)
+		}
+	}
+	return uploads, targets
+}
+
+// uploadTierCopies отправляет на FTP копии этого прогона из weekly/monthly/
+// yearly — в одноимённые каталоги рядом с удалённым daily. На итог
+// прогона их сбой не влияет: daily-архив уже загружен.
+func uploadTierCopies(ctx context.Context, archivePath string) {
+	if !ftpEnabled {
+		return
+	}
+	base := filepath.Dir(filepath.Dir(archivePath))
+	for _, t := range gfsTiers {
+		p := filepath.Join(base, t.name, filepath.Base(archivePath))
+		if _, err := os.Stat(p); err != nil {
+			continue
+		}
+		for id, ok := range uploadToFTP(ctx, p, ftpRemoteRel(p)) {
+			if !ok {
+				log.Printf("%s⚠️  %s copy was not uploaded to %s%s", yellow, t.name, id, reset)
+			}
+		}
 	}
-	log.Printf("%s✅ Backup finished%s", green, reset)
+}
 
-	// 5) FTP
-	if ftpEnabled && archivePath != "" {
-		rel := strings.TrimPrefix(archivePath, backupPath)
-		rel = strings.TrimPrefix(rel, string(os.PathSeparator))
-		uploadToFTP(archivePath, rel)
+// checkUploads применяет --require-upload, --upload-fail-mode и --upload-mode.
+func checkUploads(archivePath string, uploads map[string]bool, targets int) error {
+	if targets == 0 {
+		return nil
+	}
+	// любой отказ — errUploadFailed (exitUpload): архив локально есть
+	n := countUploaded(uploads)
+	if n == 0 && requireUpload {
+		return fmt.Errorf("%w: archive %s was not uploaded anywhere (--require-upload)", errUploadFailed, archivePath)
+	}
+	if n < targets && uploadFailMode == "fast" {
+		return fmt.Errorf("%w: archive %s (--upload-fail-mode fast)", errUploadFailed, archivePath)
+	}
+	if n == 0 && uploadMode == "any" {
+		return fmt.Errorf("%w: archive %s was not uploaded anywhere (--upload-mode any)", errUploadFailed, archivePath)
 	}
+	if n < targets && uploadMode == "all" {
+		return fmt.Errorf("%w: archive %s reached %d of %d upload targets (--upload-mode all)", errUploadFailed, archivePath, n, targets)
+	}
+	// без --upload-mode архив должен дойти до всех целей
+	if uploadMode == "" && n < len(uploads) {
+		return fmt.Errorf("%w: archive %s reached %d of %d upload targets", errUploadFailed, archivePath, n, len(uploads))
+	}
+	return nil
 }
 
+// errUploadFailed — архив сохранён локально, но не загружен на все цели.
+var errUploadFailed = errors.New("upload failed")
+
 /******************** BACKUP HELPERS ********************/
 
-func backupCluster(dataDir, host string, now time.Time) string {
-	base := filepath.Join(backupPath, host, backupSubdir, "cluster")
+// startNonExclusiveBackup: exclusive-режим на standby запрещён (а в Pg 15
+// удалён совсем), поэтому non-exclusive вызовы (Pg ≥ 15, затем 9.6–14).
+// start и stop должны идти в одной сессии — conn закреплён.
+func startNonExclusiveBackup(ctx context.Context, conn *sql.Conn) (string, error) {
+	var lsn string
+	err := conn.QueryRowContext(ctx, `SELECT pg_backup_start('go-backup', true)::text`).Scan(&lsn)
+	if err != nil {
+		err = conn.QueryRowContext(ctx, `SELECT pg_start_backup('go-backup', true, false)::text`).Scan(&lsn)
+	}
+	return lsn, err
+}
+
+// stopNonExclusiveBackup завершает non-exclusive бэкап и возвращает
+// backup_label/tablespace_map. На реплике waitArchive=false: pg_switch_wal
+// там недоступен, ждать архивации нечего.
+func stopNonExclusiveBackup(ctx context.Context, conn *sql.Conn, waitArchive bool) (lsn, label, spcmap string, err error) {
+	err = conn.QueryRowContext(ctx,
+		`SELECT lsn::text, labelfile, coalesce(spcmapfile, '') FROM pg_backup_stop($1)`, waitArchive).Scan(&lsn, &label, &spcmap)
+	if err != nil {
+		err = conn.QueryRowContext(ctx,
+			`SELECT lsn::text, labelfile, coalesce(spcmapfile, '') FROM pg_stop_backup(false, $1)`, waitArchive).Scan(&lsn, &label, &spcmap)
+	}
+	return
+}
+
+func backupCluster(cl cluster, dataDir, host string, now time.Time, opts archiveOpts) (string, *archiveStats, error) {
+	if outputPath != "" {
+		return writeOutput(dataDir, opts)
+	}
+	base := filepath.Join(backupPath, host, backupSubdir, cl.Name)
 	daily := filepath.Join(base, "daily")
 	weekly := filepath.Join(base, "weekly")
 	monthly := filepath.Join(base, "monthly")
 	yearly := filepath.Join(base, "yearly")
 	for _, d := range []string{daily, weekly, monthly, yearly} {
-		if err := os.MkdirAll(d, 0o755); err != nil {
-			log.Printf("%smkdir %s: %v%s", red, d, err, reset)
-			return ""
+		if err := makeBackupDir(d); err != nil {
+			return "", nil, fmt.Errorf("mkdir %s: %w", d, err)
 		}
 	}
+	removePartials(daily)
 
-	ts := now.Format("2006-01-02_15-04-05")
-	archive := filepath.Join(daily, fmt.Sprintf("%s_cluster.tar.gz", ts))
+	if !noLocal {
+		if err := checkFreeSpace(dataDir, daily, opts); err != nil {
+			return "", nil, err
+		}
+	}
 
+	archive := archivePathFor(daily, now, opts.Base != nil)
 	log.Printf("%s📦 Archiving %s …%s", cyan, archive, reset)
-	if err := createTarGzFromDir(archive, dataDir); err != nil {
-		log.Printf("%sArchive error: %v%s", red, err, reset)
-		return ""
+	if opts.Stream != nil {
+		opts.Stream.start(ftpRemoteRel(archive))
+	}
+	var st *archiveStats
+	var err error
+	if pgbbCompat {
+		st, err = writeBaseBackup(archive, dataDir, opts)
+	} else {
+		st, err = createTarGzFromDir(archive, dataDir, opts)
+	}
+	if err != nil {
+		removeArchive(archive) // недописанный архив только сбил бы ротацию
+		return "", st, err
+	}
+	// --no-local: архива на диске нет — размер и sha256 пишет ftpStream.finish
+	if !noLocal {
+		printFileSize(archive)
+		chownBackup(archive)
+		if _, err := writeChecksum(archive); err != nil {
+			log.Printf("%sCannot write %s: %v%s", red, archive+checksumSuffix, err, reset)
+		}
+		writeDedupList(archive, st)
+		if !pgbbCompat { // у каталога pg_basebackup свой backup_manifest
+			writeManifest(archive, backupManifest{
+				Host: host, Cluster: cl.Name, Started: now, Finished: time.Now(),
+				StartLSN: opts.StartLSN, StopLSN: st.StopLSN, serverInfo: opts.Server,
+				DataDirectory: dataDir, Incremental: opts.Base != nil || sinceLSN > 0, Files: st.Manifest,
+			})
+		}
+	}
+	if incremental {
+		writeFileList(archive, st)
+	}
+	if reportToFile != "" {
+		report := reportToFile
+		if len(clusters) > 1 {
+			report += "." + cl.Name
+		}
+		writeSkippedReport(report, archive, st)
 	}
-	printFileSize(archive)
 
-	if now.Weekday() == time.Sunday {
-		copyFile(archive, filepath.Join(weekly, filepath.Base(archive)))
+	if noLocal {
+		return archive, st, nil // ротация — только на FTP
 	}
-	if now.Day() == 1 {
-		copyFile(archive, filepath.Join(monthly, filepath.Base(archive)))
+	// инкремент без базы бесполезен — в weekly/monthly/yearly не кладём
+	rotateTiers(archive, base, now, sinceLSN == 0 && opts.Base == nil)
+	pruneWAL(base)
+	pruneDedupPool(now)
+	return archive, st, nil
+}
+
+// archivePathFor — путь нового архива в каталоге daily.
+func archivePathFor(daily string, now time.Time, incr bool) string {
+	if pgbbCompat {
+		return filepath.Join(daily, now.Format(archiveTimeLayout)+baseBackupSuffix)
+	}
+	kind := "cluster"
+	if sinceLSN > 0 || incr {
+		kind = "cluster_incr"
 	}
-	if now.YearDay() == 1 {
-		copyFile(archive, filepath.Join(yearly, filepath.Base(archive)))
+	cl := filepath.Base(filepath.Dir(daily))
+	return filepath.Join(daily, archiveName(cl, kind, archiveExt(), now))
+}
+
+// gfsTiers — weekly (по воскресеньям), monthly (1-го числа) и yearly
+// (1 января) со своими --keep-* счётчиками.
+var gfsTiers = []struct {
+	name string
+	keep *int
+	due  func(time.Time) bool
+}{
+	{"weekly", &keepWeekly, func(t time.Time) bool { return t.Weekday() == time.Sunday }},
+	{"monthly", &keepMonthly, func(t time.Time) bool { return t.Day() == 1 }},
+	{"yearly", &keepYearly, func(t time.Time) bool { return t.YearDay() == 1 }},
+}
+
+// rotateTiers копирует свежий архив в weekly/monthly/yearly, если promote
+// и пришёл их день, и чистит все четыре каталога.
+func rotateTiers(archive, base string, now time.Time, promote bool) {
+	for _, t := range gfsTiers {
+		dir := filepath.Join(base, t.name)
+		copied := promote && t.due(now)
+		if copied {
+			copyArchive(archive, filepath.Join(dir, filepath.Base(archive)))
+		}
+		if keep := *t.keep; keep > 0 {
+			if dryRun && copied {
+				keep--
+			}
+			rotateCopies(dir, keep)
+		}
 	}
 
+	daily := filepath.Join(base, "daily")
 	if maxCopies > 0 {
-		rotateCopies(daily, maxCopies)
+		copies := maxCopies
+		if dryRun {
+			copies-- // архива этого прогона на диске нет, но место он займёт
+		}
+		rotateCopies(daily, copies)
 	} else {
 		cleanupOldFiles(daily, keepDays)
 	}
-	return archive
 }
 
-/* recursive tar.gz of a directory */
-func createTarGzFromDir(dst, dir string) error {
-	out, err := os.Create(dst)
+// archiveStats — итог архивации для сводки в конце прогона.
+type archiveStats struct {
+	Files   int
+	Bytes   int64
+	Skipped []skippedFile // --best-effort: что не попало в архив и почему
+	Stamps  []string      // --incremental: «путь<TAB>размер<TAB>mtime» всех файлов
+	Blobs   []string      // --dedup-dir: хеши блобов, на которые ссылается архив
+	// Manifest — файлы архива для .manifest.json (только при записи на диск)
+	Manifest []archivedFile
+	StopLSN  string
+}
+
+type skippedFile struct{ Path, Reason, Detail string }
+
+// skipSummary — «vanished 3, permission denied 1» для итоговой строки.
+func skipSummary(files []skippedFile) string {
+	counts := map[string]int{}
+	var reasons []string
+	for _, sf := range files {
+		if counts[sf.Reason] == 0 {
+			reasons = append(reasons, sf.Reason)
+		}
+		counts[sf.Reason]++
+	}
+	parts := make([]string, len(reasons))
+	for i, r := range reasons {
+		parts[i] = fmt.Sprintf("%s %d", r, counts[r])
+	}
+	return strings.Join(parts, ", ")
+}
+
+// skipReason классифицирует ошибку чтения для отчёта --best-effort.
+func skipReason(err error) string {
+	switch {
+	case os.IsPermission(err):
+		return "permission"
+	case os.IsNotExist(err):
+		return "vanished"
+	default:
+		return "error"
+	}
+}
+
+// archiveOpts — параметры одной архивации (у каждого кластера свои).
+type archiveOpts struct {
+	Cluster   string     // имя кластера — для /status
+	BlockSize int        // BLCKSZ кластера
+	Stream    *ftpStream // --stream-ftp: копия потока архива уходит на FTP
+	Output    io.Writer  // --output -: архив пишется сюда, а не в файл
+	// Stop останавливает бэкап и отдаёт backup_label/tablespace_map
+	// (--pgbasebackup-compatible: они пишутся в base.tar)
+	Stop func() (stopLSN, label, spcmap string, err error)
+	// Cancelled — не nil, если архивацию надо прервать (давление WAL)
+	Cancelled func() error
+	// IncludeOIDs — --include-db: каталоги base/<oid>, которые архивируются
+	IncludeOIDs map[string]bool
+	// KeepWAL — pg_wal архивируется целиком (--keep-wal)
+	KeepWAL bool
+	// Base — --incremental: архивируются только файлы, изменившиеся с Base
+	Base *incrBase
+	// Tablespaces — oid → каталог внешних табличных пространств
+	Tablespaces map[string]string
+	// StartLSN и Server — для <архив>.manifest.json; Server ещё и
+	// первой записью SERVER_INFO.txt
+	StartLSN string
+	Server   serverInfo
+}
+
+/* recursive compressed tar of a directory */
+func createTarGzFromDir(dst, dir string, opts archiveOpts) (st *archiveStats, err error) {
+	st = &archiveStats{}
+	// tar, сжатие, буфер и файл закрываются по порядку с проверкой ошибок:
+	// проглоченный Close (диск кончился на хвосте) дал бы обрезанный архив,
+	// который выглядит целым. Пишем в .partial и переименовываем только
+	// после успешного закрытия: недописанный архив не похож на готовый.
+	var closers []func() error
+	var out *os.File
+	tmp := dst + partialSuffix
+	defer func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			if cerr := closers[i](); err == nil {
+				err = cerr
+			}
+		}
+		if out == nil {
+			return
+		}
+		if err == nil {
+			err = os.Rename(tmp, dst)
+		}
+		if err != nil {
+			_ = os.Remove(tmp)
+			return
+		}
+		// архив — основная копия: rename должен пережить сбой питания
+		err = syncDir(filepath.Dir(dst))
+	}()
+	var w io.Writer = io.Discard // --dry-run: тот же обход, но без записи
+	if !dryRun && !noLocal && opts.Output == nil {
+		if out, err = os.Create(tmp); err != nil {
+			return st, err
+		}
+		closers = append(closers, out.Close, out.Sync)
+		w = out
+	}
+	switch {
+	case opts.Output != nil:
+		w = opts.Output
+	case opts.Stream != nil && out == nil:
+		w = opts.Stream // --no-local
+	case opts.Stream != nil:
+		w = io.MultiWriter(out, opts.Stream)
+	}
+	if partSize > 0 && out != nil {
+		// пишем на диск крупными выровненными блоками
+		bw := bufio.NewWriterSize(w, int(partSize))
+		closers = append(closers, bw.Flush)
+		w = bw
+	}
+	var tw entryWriter
+	if archiveContainer == "zip" {
+		// сжатие — внутри, по записи
+		if tw, err = newZipEntryWriter(w, zipZstdDict(dir)); err != nil {
+			return st, err
+		}
+	} else {
+		gw, err := newArchiveWriter(w)
+		if err != nil {
+			return st, err
+		}
+		closers = append(closers, gw.Close)
+		tw = tar.NewWriter(gw)
+	}
+	closers = append(closers, tw.Close)
+
+	// глобальный флаг только читаем: кластеры архивируются параллельно
+	bufSize := max(int(readBufferSize), minReadBufferSize)
+	buf := make([]byte, bufSize)
+	if opts.Server.VersionNum > 0 {
+		// первой записью: --restore читает версию, не распаковывая остальное
+		if err := writeTarEntry(tw, serverInfoEntry, opts.Server.text()); err != nil {
+			return st, err
+		}
+	}
+	prog := startProgress(opts.Cluster)
+	defer prog.stop()
+	skip := func(rel, reason string, err error) error {
+		if !bestEffort {
+			return err
+		}
+		log.Printf("%s⚠️  Skipping %s (%s): %v%s", yellow, rel, reason, err, reset)
+		st.Skipped = append(st.Skipped, skippedFile{filepath.ToSlash(rel), reason, err.Error()})
+		return nil
+	}
+	var listing []string // инкремент: полный список файлов кластера
+	var excludedDirs []string
+	excluded := 0
+	var trimmed []string // --trim-zeros: путь и исходный размер
+	// walk обходит root, записывая пути как prefix/<путь от root>: так
+	// вынесенный симлинком pg_wal попадает в архив обычным каталогом
+	// (пустым, если он исключён)
+	var walk func(root, prefix string) filepath.WalkFunc
+	walk = func(root, prefix string) filepath.WalkFunc {
+		return func(path string, info fs.FileInfo, err error) error {
+			rel, _ := filepath.Rel(root, path)
+			rel = filepath.Join(prefix, rel)
+			if err != nil {
+				return skip(rel, skipReason(err), err)
+			}
+			if opts.Cancelled != nil {
+				if err := opts.Cancelled(); err != nil {
+					return err
+				}
+			}
+			if rel != "." && isExcluded(rel, info.IsDir()) {
+				if info.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if rel == "pg_wal" && info.Mode()&os.ModeSymlink != 0 {
+				target, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					return skip(rel, skipReason(err), err)
+				}
+				return filepath.Walk(target, walk(target, rel))
+			}
+			if _, ok := opts.Tablespaces[tablespaceOID(rel)]; ok && info.Mode()&os.ModeSymlink != 0 {
+				// внешнее табличное пространство — содержимым, как pg_wal
+				target, err := filepath.EvalSymlinks(path)
+				if err != nil {
+					return skip(rel, skipReason(err), err)
+				}
+				return filepath.Walk(target, walk(target, rel))
+			}
+			if info.IsDir() {
+				if rel == "." {
+					return nil
+				}
+				if excludedDBDir(rel, opts.IncludeOIDs) {
+					return filepath.SkipDir
+				}
+				// каждый каталог — своей записью: пустые тоже восстановятся
+				hdr, err := tar.FileInfoHeader(info, "")
+				if err != nil {
+					return err
+				}
+				hdr.Name = filepath.ToSlash(rel) + "/"
+				if loc, ok := opts.Tablespaces[tablespaceOID(rel)]; ok {
+					hdr.PAXRecords = map[string]string{tablespacePAXKey: loc}
+				}
+				if err := tw.WriteHeader(hdr); err != nil {
+					return err
+				}
+				if !isExcludedDir(rel) || opts.KeepWAL && rel == "pg_wal" {
+					return nil
+				}
+				// исключённый каталог: в архиве пустой, содержимое — нет
+				if excludeNewerThan <= 0 {
+					if rel == "pg_wal" {
+						// как у pg_basebackup: пустой archive_status рядом
+						hdr.Name = "pg_wal/archive_status/"
+						if err := tw.WriteHeader(hdr); err != nil {
+							return err
+						}
+					}
+					return filepath.SkipDir
+				}
+				excludedDirs = append(excludedDirs, rel+string(filepath.Separator))
+				return nil
+			}
+			if info.Mode()&os.ModeSymlink != 0 {
+				// pg_tblspc/<oid> и прочие ссылки — ссылками, не содержимым
+				target, err := os.Readlink(path)
+				if err != nil {
+					return skip(rel, skipReason(err), err)
+				}
+				hdr, err := tar.FileInfoHeader(info, target)
+				if err != nil {
+					return err
+				}
+				hdr.Name = filepath.ToSlash(rel)
+				return tw.WriteHeader(hdr)
+			}
+			if !info.Mode().IsRegular() {
+				// сокеты (.s.PGSQL.5432), FIFO, устройства — не данные
+				return nil
+			}
+			if excludeNewerThan > 0 && info.ModTime().After(time.Now().Add(-excludeNewerThan)) {
+				for _, d := range excludedDirs {
+					if strings.HasPrefix(rel, d) {
+						excluded++
+						return nil
+					}
+				}
+			}
+			if opts.Base != nil {
+				listing = append(listing, fmt.Sprintf("%s\t%d", filepath.ToSlash(rel), info.Size()))
+				if opts.Base.unchanged(rel, info) {
+					st.Stamps = append(st.Stamps, stampLine(rel, info))
+					return nil
+				}
+			}
+			if sinceLSN > 0 {
+				listing = append(listing, fmt.Sprintf("%s\t%d", filepath.ToSlash(rel), info.Size()))
+				if isRelationFile(rel) {
+					changed, err := relationChangedSince(path, info.Size(), sinceLSN, opts.BlockSize)
+					if err != nil {
+						return skip(rel, skipReason(err), err)
+					}
+					if !changed {
+						return nil
+					}
+				}
+			}
+			if dryRun {
+				log.Printf("  %s (%d bytes)", filepath.ToSlash(rel), info.Size())
+				st.Files++
+				st.Bytes += info.Size()
+				return nil
+			}
+			// открываем до заголовка: пропустить файл можно только пока
+			// в tar ничего не записано
+			f, err := os.Open(path)
+			if err != nil {
+				return skip(rel, skipReason(err), err)
+			}
+			defer f.Close()
+			hdr, err := tar.FileInfoHeader(info, "")
+			if err != nil {
+				return err
+			}
+			hdr.Name = rel
+			deduped := false
+			if dedupDir != "" && hdr.Size >= int64(dedupMinSize) {
+				if sum, err := dedupFile(f, hdr.Size, buf); err == nil {
+					if out != nil {
+						st.Manifest = append(st.Manifest, archivedFile{filepath.ToSlash(rel), hdr.Size, info.ModTime(), sum})
+					}
+					hdr.PAXRecords = map[string]string{dedupPAXKey: sum}
+					hdr.Size, deduped = 0, true
+					st.Blobs = append(st.Blobs, sum)
+				} else if _, serr := f.Seek(0, io.SeekStart); serr != nil {
+					return skip(rel, skipReason(serr), serr)
+				} else {
+					log.Printf("%s⚠️  --dedup-dir: %s: %v — archiving it inline%s", yellow, rel, err, reset)
+				}
+			}
+			if trimZeros && !deduped && isRelationFile(rel) {
+				if n, err := trimmedSize(f, hdr.Size, opts.BlockSize); err == nil && n < hdr.Size {
+					trimmed = append(trimmed, fmt.Sprintf("%s\t%d", filepath.ToSlash(rel), hdr.Size))
+					hdr.PAXRecords = map[string]string{"PGBACKUP.orig_size": strconv.FormatInt(hdr.Size, 10)}
+					hdr.Size = n
+				}
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			if deduped {
+				st.Files++
+				prog.fileDone()
+				if incremental {
+					st.Stamps = append(st.Stamps, stampLine(rel, info))
+				}
+				return nil
+			}
+			adviseSequential(f)
+			// горячий бэкап: файл может расти или усыхать во время чтения.
+			// Пишем ровно hdr.Size байт, недостачу добиваем нулями — WAL replay
+			// всё равно перезапишет такие страницы. Прячем WriterTo у *os.File,
+			// иначе CopyBuffer проигнорирует буфер.
+			var w io.Writer = tw
+			var h hash.Hash
+			if out != nil {
+				h = sha256.New()
+				w = io.MultiWriter(tw, h)
+			}
+			n, err := copyBounded(prog.writer(w), f, hdr.Size, buf)
+			if err != nil {
+				return err
+			}
+			if h != nil {
+				// хеш — содержимого после --restore: обрезанные нули тоже
+				_, _ = io.CopyN(h, zeroReader{}, info.Size()-hdr.Size)
+				st.Manifest = append(st.Manifest, archivedFile{filepath.ToSlash(rel), info.Size(), info.ModTime(), hex.EncodeToString(h.Sum(nil))})
+			}
+			if n < hdr.Size {
+				_ = skip(rel, "changed", fmt.Errorf("shrank from %d to %d bytes during read, zero-padded", hdr.Size, n))
+			}
+			st.Files++
+			st.Bytes += hdr.Size
+			prog.fileDone()
+			if incremental {
+				st.Stamps = append(st.Stamps, stampLine(rel, info))
+			}
+			return nil
+		}
+	}
+	err = filepath.Walk(dir, walk(dir, ""))
+	if err != nil {
+		return st, err
+	}
+	if excluded > 0 {
+		log.Printf("%s🧹 Excluded %d recent file(s) from transient directories%s", cyan, excluded, reset)
+	}
+	// без backup_label non-exclusive бэкап не восстановить: PostgreSQL
+	// начнёт не с той контрольной точки
+	if opts.Stop != nil {
+		stopLSN, label, spcmap, err := opts.Stop()
+		if err != nil {
+			return st, fmt.Errorf("stop backup: %w", err)
+		}
+		st.StopLSN = stopLSN
+		for _, e := range []struct{ name, body string }{{"backup_label", label}, {"tablespace_map", spcmap}} {
+			if e.body == "" {
+				continue
+			}
+			if err := writeTarEntry(tw, e.name, e.body); err != nil {
+				return st, err
+			}
+			if out != nil {
+				sum := sha256.Sum256([]byte(e.body))
+				st.Manifest = append(st.Manifest, archivedFile{e.name, int64(len(e.body)), time.Now(), hex.EncodeToString(sum[:])})
+			}
+		}
+	}
+
+	if len(st.Skipped) > 0 {
+		var b strings.Builder
+		for _, sf := range st.Skipped {
+			fmt.Fprintf(&b, "%s\t%s\t%s\n", sf.Path, sf.Reason, sf.Detail)
+		}
+		if err := writeTarEntry(tw, skippedFilesEntry, b.String()); err != nil {
+			return st, err
+		}
+	}
+	if len(trimmed) > 0 {
+		// TRIMMED.txt: «путь<TAB>исходный размер» — после распаковки
+		// файлы нужно дорастить нулями (truncate -s), иначе PostgreSQL
+		// увидит отношения короче, чем они были
+		log.Printf("%s✂️  Trimmed trailing zero pages of %d file(s)%s", cyan, len(trimmed), reset)
+		if err := writeTarEntry(tw, trimmedEntry, strings.Join(trimmed, "\n")+"\n"); err != nil {
+			return st, err
+		}
+	}
+	if sinceLSN > 0 || opts.Base != nil {
+		// INCREMENTAL.txt: база (LSN или предыдущий архив) и все файлы на
+		// момент бэкапа, чтобы при восстановлении поверх полной копии
+		// можно было удалить лишнее.
+		head := "since-lsn " + formatLSN(sinceLSN)
+		if opts.Base != nil {
+			head = "base " + opts.Base.Name
+		}
+		body := fmt.Sprintf("%s\n%s\n", head, strings.Join(listing, "\n"))
+		if err := writeTarEntry(tw, incrementalEntry, body); err != nil {
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// writeTarEntry добавляет в архив служебный файл, сгенерированный в памяти.
+func writeTarEntry(tw entryWriter, name, body string) error {
+	hdr := &tar.Header{Name: name, Mode: 0o600, Size: int64(len(body)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := io.WriteString(tw, body)
+	return err
+}
+
+// copyBounded пишет ровно size байт из f, добивая нулями, если файл
+// усох во время чтения; возвращает, сколько байт реально прочитано.
+// Чтение идёт через --read-rate-limit, добивка нулями — нет.
+func copyBounded(w io.Writer, f *os.File, size int64, buf []byte) (int64, error) {
+	n, err := io.CopyBuffer(w, io.LimitReader(throttleRead(f), size), buf)
 	if err != nil {
-		return err
+		return n, err
 	}
-	defer out.Close()
-	gw := gzip.NewWriter(out)
-	defer gw.Close()
-	tw := tar.NewWriter(gw)
-	defer tw.Close()
-
-	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
-		if err != nil || info.IsDir() {
-			return err
-		}
-		rel, _ := filepath.Rel(dir, path)
-		hdr, err := tar.FileInfoHeader(info, "")
-		if err != nil {
-			return err
-		}
-		hdr.Name = rel
-		if err := tw.WriteHeader(hdr); err != nil {
-			return err
-		}
-		f, err := os.Open(path)
-		if err != nil {
-			return err
-		}
-		if _, err := io.Copy(tw, f); err != nil {
-			f.Close()
-			return err
+	if n < size {
+		if _, err := io.CopyBuffer(w, io.LimitReader(zeroReader{}, size-n), buf); err != nil {
+			return n, err
 		}
-		f.Close()
-		return nil
-	})
+	}
+	return n, nil
+}
+
+type zeroReader struct{}
+
+func (zeroReader) Read(p []byte) (int, error) {
+	clear(p)
+	return len(p), nil
+}
+
+// writeSkippedReport пишет отчёт --report-to-file: путь, причина, детали.
+func writeSkippedReport(path, archive string, st *archiveStats) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# archive: %s\n# archived files: %d, skipped: %d\n", archive, st.Files, len(st.Skipped))
+	for _, sf := range st.Skipped {
+		fmt.Fprintf(&b, "%s\t%s\t%s\n", sf.Path, sf.Reason, sf.Detail)
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0o600); err != nil {
+		log.Printf("%sCannot write skipped-files report: %v%s", red, err, reset)
+	}
 }
 
 /******************** FTP ****************************/
 
 func initFTP() {
 	// 1) from conf file
-	if _, err := os.Stat(ftpConfFile); err == nil {
-		_ = parseFTPConf(ftpConfFile)
+	if data, err := os.ReadFile(ftpConfFile); err == nil {
+		if !confEncrypted(data) {
+			warnReadable(ftpConfFile, "FTP credentials file")
+		}
+		accs, err := parseFTPConf(ftpConfFile)
+		if err != nil {
+			log.Printf("%sCannot read %s: %v%s", red, ftpConfFile, err, reset)
+		}
+		ftpAccounts = accs
+	}
+	// 2) ftp: из --config (если --ftp-conf не задан явно)
+	if configFTP != nil {
+		ftpAccounts = configFTP
 	}
-	// 2) override
+	// 3) override
 	if ftpHost != "" {
-		ftpAccounts = []ftpAccount{{Host: ftpHost, User: ftpUser, Pass: ftpPass}}
+		pass, err := resolveFTPPass()
+		if err != nil {
+			log.Fatalf("%sFTP password: %v%s", red, err, reset)
+		}
+		ftpAccounts = []ftpAccount{{Host: ftpHost, User: ftpUser, Pass: pass, TLS: ftpTLS}}
 	}
 	ftpEnabled = len(ftpAccounts) > 0
 	if !ftpEnabled {
@@ -290,19 +1791,22 @@ func initFTP() {
 	}
 	for _, acc := range ftpAccounts {
 		log.Printf("%s🌐 FTP target → %s (user %s)%s", cyan, acc.Host, acc.User, reset)
+		if !acc.TLS && !ftpTLS {
+			log.Printf("%s⚠️  FTP %s is plaintext: password and archives go unencrypted (use --ftp-tls or FTP_TLS=true)%s", yellow, acc.Host, reset)
+		}
 	}
 }
 
-func parseFTPConf(path string) error {
-	f, err := os.Open(path)
+func parseFTPConf(path string) ([]ftpAccount, error) {
+	f, err := openFTPConf(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	defer f.Close()
+	var accs []ftpAccount
 	var cur ftpAccount
 	commit := func() {
 		if cur.Host != "" && cur.User != "" && cur.Pass != "" {
-			ftpAccounts = append(ftpAccounts, cur)
+			accs = append(accs, cur)
 		}
 		cur = ftpAccount{}
 	}
@@ -325,64 +1829,329 @@ func parseFTPConf(path string) error {
 			cur.User = val
 		case "FTP_PASS":
 			cur.Pass = val
+		case "FTP_PASS_FILE":
+			if cur.Pass, err = readSecretFile(val); err != nil {
+				return nil, fmt.Errorf("FTP_PASS_FILE: %w", err)
+			}
+		case "FTP_PORT":
+			if cur.Port, err = strconv.Atoi(val); err != nil || cur.Port < 1 || cur.Port > 65535 {
+				return nil, fmt.Errorf("FTP_PORT %q: not a port number", val)
+			}
+		case "FTP_TLS":
+			cur.TLS, _ = strconv.ParseBool(val)
 		}
 	}
 	commit()
-	return scanner.Err()
+	return accs, scanner.Err()
+}
+
+// ftpRemoteRel — путь архива на FTP: тот же, что под --backup-path.
+func ftpRemoteRel(archivePath string) string {
+	rel := strings.TrimPrefix(archivePath, backupPath)
+	return strings.TrimPrefix(rel, string(os.PathSeparator))
 }
 
-func uploadToFTP(localPath, remoteRel string) {
+// uploadToFTP возвращает результат по каждому аккаунту (user@host → ok);
+// с --upload-fail-mode fast и --upload-mode any непопробованных аккаунтов
+// в нём нет. Аккаунты грузятся параллельно, по --upload-parallelism за раз;
+// --upload-mode any — список запасных, он идёт строго по порядку.
+func uploadToFTP(ctx context.Context, localPath, remoteRel string) map[string]bool {
+	if uploadMode == "any" || uploadParallelism < 2 || len(ftpAccounts) < 2 {
+		return uploadToFTPInOrder(ctx, localPath, remoteRel)
+	}
+	var (
+		mu     sync.Mutex
+		wg     sync.WaitGroup
+		res    = map[string]bool{}
+		failed bool
+	)
+	slots := make(chan struct{}, uploadParallelism)
 	for _, acc := range ftpAccounts {
-		uploadToSingleFTP(acc, localPath, remoteRel)
+		slots <- struct{}{}
+		mu.Lock()
+		stop := failed && uploadFailMode == "fast"
+		mu.Unlock()
+		if stop || ctxErr(ctx) != nil {
+			<-slots
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			// сбой одного аккаунта не мешает остальным догрузить
+			ok := uploadWithRetries(ctx, acc, localPath, remoteRel)
+			mu.Lock()
+			res[acc.id()] = ok
+			failed = failed || !ok
+			mu.Unlock()
+			<-slots
+		}()
+	}
+	wg.Wait()
+	if failed && uploadFailMode == "fast" && len(res) < len(ftpAccounts) {
+		log.Printf("%s--upload-fail-mode fast: not trying the remaining FTP accounts%s", red, reset)
+	}
+	return res
+}
+
+// uploadToFTPInOrder — по одному аккаунту в порядке ftp-conf.
+func uploadToFTPInOrder(ctx context.Context, localPath, remoteRel string) map[string]bool {
+	res := map[string]bool{}
+	for i, acc := range ftpAccounts {
+		if ctxErr(ctx) != nil {
+			break
+		}
+		res[acc.id()] = uploadWithRetries(ctx, acc, localPath, remoteRel)
+		if res[acc.id()] && uploadMode == "any" {
+			if rest := len(ftpAccounts) - i - 1; rest > 0 {
+				log.Printf("%s--upload-mode any: uploaded to %s, %d fallback account(s) not needed%s", cyan, acc.Host, rest, reset)
+			}
+			break
+		}
+		if !res[acc.id()] && uploadFailMode == "fast" {
+			log.Printf("%s--upload-fail-mode fast: not trying the remaining FTP accounts%s", red, reset)
+			break
+		}
+	}
+	return res
+}
+
+// uploadWithRetries — uploadToSingleFTP с --upload-retries попытками сверху,
+// пауза удваивается от 10 секунд.
+func uploadWithRetries(ctx context.Context, acc ftpAccount, localPath, remoteRel string) bool {
+	delay := 10 * time.Second
+	for attempt := 0; ; attempt++ {
+		if uploadToSingleFTP(ctx, acc, localPath, remoteRel) {
+			return true
+		}
+		if err := ctxErr(ctx); err != nil {
+			log.Printf("%sFTP %s: upload aborted: %v%s", red, acc.Host, err, reset)
+			return false
+		}
+		if attempt >= uploadRetries {
+			return false
+		}
+		log.Printf("%sFTP %s: retry %d/%d in %s%s", yellow, acc.Host, attempt+1, uploadRetries, delay, reset)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		delay *= 2
+	}
+}
+
+func countUploaded(res map[string]bool) int {
+	n := 0
+	for _, ok := range res {
+		if ok {
+			n++
+		}
 	}
+	return n
 }
 
-func uploadToSingleFTP(acc ftpAccount, localPath, remoteRel string) {
-	c, err := ftp.Dial(acc.Host + ":21")
+// ftpHostname — хост без :port (для проверки сертификата).
+func ftpHostname(host string) string {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		return h
+	}
+	return host
+}
+
+// dialFTP подключается и логинится. TCP keepalive держит control-соединение
+// живым для NAT/файрволов, пока по data-каналу идёт многочасовая передача:
+// сама библиотека синхронна, и NOOP во время STOR сломал бы поток ответов.
+//
+// С --ftp-tls / FTP_TLS=true — явный FTPS (AUTH TLS), data-каналы тоже
+// шифруются (PROT P).
+func dialFTP(acc ftpAccount) (*ftp.ServerConn, error) {
+	opts := []ftp.DialOption{
+		ftp.DialWithDialer(net.Dialer{Timeout: ftpTimeout, KeepAlive: ftpKeepAlive}),
+		ftp.DialWithShutTimeout(ftpTimeout),
+	}
+	if acc.TLS || ftpTLS {
+		opts = append(opts, ftp.DialWithExplicitTLS(&tls.Config{
+			ServerName:         ftpHostname(acc.Host),
+			InsecureSkipVerify: ftpTLSInsecure,
+		}))
+	}
+	c, err := ftp.Dial(acc.addr(), opts...)
 	if err != nil {
-		log.Printf("%sFTP dial %s: %v%s", red, acc.Host, err, reset)
-		return
+		return nil, fmt.Errorf("dial: %w", err)
 	}
-	defer c.Quit()
 	if err := c.Login(acc.User, acc.Pass); err != nil {
-		log.Printf("%sFTP login %s: %v%s", red, acc.Host, err, reset)
-		return
+		_ = c.Quit()
+		return nil, fmt.Errorf("login: %w", err)
 	}
+	return c, nil
+}
 
-	// create dirs
-	parts := strings.Split(filepath.Dir(remoteRel), string(os.PathSeparator))
-	cwd := "/"
-	for _, p := range parts {
-		if p == "" {
-			continue
+// uploadToSingleFTP сообщает, загружен ли архив; ошибки ротации
+// на результат не влияют.
+func uploadToSingleFTP(ctx context.Context, acc ftpAccount, localPath, remoteRel string) bool {
+	c, err := dialFTP(acc)
+	if err != nil {
+		log.Printf("%sFTP %s: %v%s", red, acc.Host, err, reset)
+		return false
+	}
+	defer func() {
+		if c != nil { // c может смениться при переподключении
+			_ = c.Quit()
+		}
+	}()
+
+	makeRemoteDirs(c, remoteRel)
+
+	remotePath := filepath.ToSlash(remoteRel)
+	// каталог --pgbasebackup-compatible загружаем пофайлово
+	locals, remotes := []string{localPath}, []string{remotePath}
+	if info, err := os.Stat(localPath); err == nil && info.IsDir() {
+		_ = c.MakeDir(remotePath)
+		entries, _ := os.ReadDir(localPath)
+		locals, remotes = nil, nil
+		for _, e := range entries {
+			locals = append(locals, filepath.Join(localPath, e.Name()))
+			remotes = append(remotes, remotePath+"/"+e.Name())
+		}
+	}
+	for i := range locals {
+		if !storFTP(ctx, c, acc, locals[i], remotes[i]) {
+			return false
 		}
-		cwd = filepath.Join(cwd, p)
-		_ = c.MakeDir(cwd)
 	}
+	storSidecars(ctx, c, acc, localPath, remotePath)
+
+	c = rotateAfterUpload(acc, c, remotePath)
+	return true
+}
 
+func storFTP(ctx context.Context, c *ftp.ServerConn, acc ftpAccount, localPath, remotePath string) bool {
 	f, err := os.Open(localPath)
 	if err != nil {
 		log.Printf("%sFTP open local: %v%s", red, err, reset)
-		return
+		return false
 	}
 	defer f.Close()
 
-	remotePath := filepath.ToSlash(remoteRel)
+	// на сервере осталась часть от прошлой попытки — докачиваем
+	if info, err := f.Stat(); err == nil {
+		if size, err := c.FileSize(remotePath); err == nil && size > 0 && size < info.Size() {
+			ok, err := resumeFTP(ctx, c, acc, f, remotePath, size)
+			if err != nil {
+				log.Printf("%sFTP upload %s: %v%s", red, acc.Host, err, reset)
+				return false
+			}
+			if ok {
+				return true
+			}
+			log.Printf("%s⚠️  %s: resumed %s does not match the local checksum, uploading from scratch%s",
+				yellow, acc.Host, remotePath, reset)
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				log.Printf("%sFTP open local: %v%s", red, err, reset)
+				return false
+			}
+		}
+	}
+
 	log.Printf("%s⇪ Uploading to %s: %s%s", cyan, acc.Host, remotePath, reset)
-	if err := c.Stor(remotePath, f); err != nil {
+	if err := c.Stor(remotePath, throttle(withContext(ctx, f))); err != nil {
 		log.Printf("%sFTP upload %s: %v%s", red, acc.Host, err, reset)
-		return
+		return false
+	}
+	return true
+}
+
+// resumeFTP дописывает файл с offset (REST + STOR) и скачивает результат
+// для сверки SHA-256: испорченная часть от прошлой попытки иначе дала бы
+// на сервере мусорный архив. false — не совпало, нужна полная загрузка.
+func resumeFTP(ctx context.Context, c *ftp.ServerConn, acc ftpAccount, f *os.File, remotePath string, offset int64) (bool, error) {
+	log.Printf("%s⇪ Resuming upload to %s: %s from %.2f MB%s", cyan, acc.Host, remotePath,
+		float64(offset)/(1024*1024), reset)
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return false, err
+	}
+	if err := c.StorFrom(remotePath, throttle(withContext(ctx, f)), uint64(offset)); err != nil {
+		return false, err
+	}
+	want, err := readChecksum(f.Name())
+	if err != nil {
+		if want, err = hashFile(f.Name()); err != nil {
+			return false, err
+		}
+	}
+	r, err := c.Retr(remotePath)
+	if err != nil {
+		return false, err
+	}
+	got, err := hashReader(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return false, err
+	}
+	return got == want, nil
+}
+
+// storSidecars загружает .sha256 и .manifest.json рядом с архивом. Их
+// сбой не проваливает загрузку: мониторинг увидит архив без sidecar.
+func storSidecars(ctx context.Context, c *ftp.ServerConn, acc ftpAccount, localPath, remotePath string) {
+	for _, ext := range []string{checksumSuffix, manifestSuffix} {
+		if _, err := os.Stat(localPath + ext); err != nil {
+			continue
+		}
+		if !storFTP(ctx, c, acc, localPath+ext, remotePath+ext) {
+			log.Printf("%s⚠️  %s: archive uploaded without its %s%s", yellow, acc.Host, ext, reset)
+		}
+	}
+}
+
+func makeRemoteDirs(c *ftp.ServerConn, remoteRel string) {
+	parts := strings.Split(filepath.Dir(remoteRel), string(os.PathSeparator))
+	cwd := "/"
+	for _, p := range parts {
+		if p == "" {
+			continue
+		}
+		cwd = filepath.Join(cwd, p)
+		_ = c.MakeDir(cwd)
+	}
+}
+
+// rotateAfterUpload чистит на FTP каталог загруженного архива (daily — ещё
+// и weekly/monthly/yearly рядом) и возвращает рабочее соединение (или nil,
+// если переподключиться не удалось).
+func rotateAfterUpload(acc ftpAccount, c *ftp.ServerConn, remotePath string) *ftp.ServerConn {
+	// строгие серверы рвут простаивавшее control-соединение — проверяем
+	// NOOP-ом и переподключаемся перед медленным листингом ротации
+	if err := c.NoOp(); err != nil {
+		log.Printf("%sFTP %s idle connection lost (%v), reconnecting for rotation%s", yellow, acc.Host, err, reset)
+		_ = c.Quit()
+		if c, err = dialFTP(acc); err != nil {
+			log.Printf("%sFTP %s: %v%s", red, acc.Host, err, reset)
+			return nil
+		}
 	}
 
-	// rotation for daily
-	if strings.Contains(remotePath, "/daily/") {
-		remoteDailyDir := filepath.ToSlash(filepath.Dir(remotePath))
+	remoteDir := filepath.ToSlash(filepath.Dir(remotePath))
+	if filepath.Base(remoteDir) == "daily" {
 		if maxCopies > 0 {
-			rotateCopiesFTP(c, remoteDailyDir, maxCopies*ftpKeepFactor)
+			rotateCopiesFTP(c, remoteDir, maxCopies*ftpKeepFactor)
 		} else {
-			cleanupOldFilesFTP(c, remoteDailyDir, keepDays*ftpKeepFactor)
+			cleanupOldFilesFTP(c, remoteDir, keepDays*ftpKeepFactor)
+		}
+		pruneWALFTP(c, filepath.ToSlash(filepath.Dir(remoteDir)))
+	}
+	// weekly/monthly/yearly: каждый со своим --keep-*; после daily —
+	// все соседние, после копии — только её каталог
+	for _, t := range gfsTiers {
+		dir := filepath.ToSlash(filepath.Join(filepath.Dir(remoteDir), t.name))
+		if *t.keep > 0 && (filepath.Base(remoteDir) == "daily" || dir == remoteDir) {
+			rotateCopiesFTP(c, dir, *t.keep*ftpKeepFactor)
 		}
 	}
+	return c
 }
 
 func rotateCopiesFTP(c *ftp.ServerConn, dir string, copies int) {
@@ -392,7 +2161,8 @@ func rotateCopiesFTP(c *ftp.ServerConn, dir string, copies int) {
 	}
 	var files []*ftp.Entry
 	for _, e := range entries {
-		if e.Type == ftp.EntryTypeFile && strings.HasSuffix(e.Name, ".tar.gz") {
+		if e.Type == ftp.EntryTypeFile && isArchiveFile(e.Name) ||
+			e.Type == ftp.EntryTypeFolder && strings.HasSuffix(e.Name, baseBackupSuffix) {
 			files = append(files, e)
 		}
 	}
@@ -405,7 +2175,7 @@ func rotateCopiesFTP(c *ftp.ServerConn, dir string, copies int) {
 	for _, e := range files[copies:] {
 		remoteFile := filepath.ToSlash(filepath.Join(dir, e.Name))
 		log.Printf("🧹 (FTP) Deleting extra archive %s", remoteFile)
-		_ = c.Delete(remoteFile)
+		deleteFTPArchive(c, e, remoteFile)
 	}
 }
 
@@ -416,17 +2186,28 @@ func cleanupOldFilesFTP(c *ftp.ServerConn, dir string, days int) {
 	}
 	cutoff := time.Now().AddDate(0, 0, -days)
 	for _, e := range entries {
-		if e.Type != ftp.EntryTypeFile {
+		if e.Type != ftp.EntryTypeFile &&
+			!(e.Type == ftp.EntryTypeFolder && strings.HasSuffix(e.Name, baseBackupSuffix)) {
 			continue
 		}
 		if e.Time.Before(cutoff) {
 			remoteFile := filepath.ToSlash(filepath.Join(dir, e.Name))
 			log.Printf("🧹 (FTP) Deleting old archive %s", remoteFile)
-			_ = c.Delete(remoteFile)
+			deleteFTPArchive(c, e, remoteFile)
 		}
 	}
 }
 
+func deleteFTPArchive(c *ftp.ServerConn, e *ftp.Entry, remotePath string) {
+	if e.Type == ftp.EntryTypeFolder {
+		_ = c.RemoveDirRecur(remotePath)
+		return
+	}
+	_ = c.Delete(remotePath)
+	_ = c.Delete(remotePath + checksumSuffix)
+	_ = c.Delete(remotePath + manifestSuffix)
+}
+
 /******************** FILE OPS ********************/
 
 func createTarGz(dst string, files []string) error {
@@ -434,12 +2215,23 @@ func createTarGz(dst string, files []string) error {
 	if err != nil {
 		return err
 	}
-	defer out.Close()
-	gw := gzip.NewWriter(out)
-	defer gw.Close()
+	gw, err := newArchiveWriter(out)
+	if err != nil {
+		out.Close()
+		return err
+	}
 	tw := tar.NewWriter(gw)
-	defer tw.Close()
+	err = writeTarFiles(tw, files)
+	// по порядку: tar, сжатие, файл; первая ошибка — причина
+	for _, c := range []func() error{tw.Close, gw.Close, out.Close} {
+		if cerr := c(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
 
+func writeTarFiles(tw *tar.Writer, files []string) error {
 	for _, file := range files {
 		info, err := os.Stat(file)
 		if err != nil {
@@ -466,34 +2258,124 @@ func createTarGz(dst string, files []string) error {
 	return nil
 }
 
-func copyFile(src, dst string) {
+// syncDir — fsync каталога, чтобы созданные и переименованные в нём файлы
+// пережили сбой питания. EINVAL (ФС не умеет fsync каталога) не ошибка.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, syscall.EINVAL) {
+		return fmt.Errorf("fsync %s: %w", dir, err)
+	}
+	return nil
+}
+
+// syncFile — fsync уже записанного файла.
+func syncFile(path string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	err = f.Sync()
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// copyFile копирует файл; недописанная копия удаляется, чтобы ротация не
+// приняла её за целый архив.
+func copyFile(src, dst string) error {
 	in, err := os.Open(src)
 	if err != nil {
-		log.Printf("open %s: %v", src, err)
-		return
+		return err
 	}
 	defer in.Close()
 	out, err := os.Create(dst)
 	if err != nil {
-		log.Printf("create %s: %v", dst, err)
-		return
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(dst)
+		return err
 	}
-	defer out.Close()
-	_, _ = io.Copy(out, in)
 	_ = os.Chmod(dst, 0644)
+	chownBackup(dst)
+	return nil
 }
 
-func printFileSize(path string) {
-	if info, err := os.Stat(path); err == nil {
-		size := float64(info.Size()) / (1024 * 1024)
-		log.Printf("%s💾 Archive size: %.2f MB%s", green, size, reset)
+// copyArchive копирует архив в другой уровень ротации (файл или каталог).
+func copyArchive(src, dst string) {
+	if dryRun {
+		log.Printf("📎 %sCopying to %s", dryRunTag(), dst)
+		return
+	}
+	info, err := os.Stat(src)
+	if err != nil || !info.IsDir() {
+		if err := copyFile(src, dst); err != nil {
+			log.Printf("%sCopy to %s: %v%s", red, dst, err, reset)
+			return
+		}
+		for _, ext := range []string{checksumSuffix, dedupSuffix, manifestSuffix} {
+			if _, err := os.Stat(src + ext); err != nil {
+				continue
+			}
+			if err := copyFile(src+ext, dst+ext); err != nil {
+				log.Printf("%sCopy to %s: %v%s", red, dst+ext, err, reset)
+			}
+		}
+		return
+	}
+	if err := makeBackupDir(dst); err != nil {
+		log.Printf("mkdir %s: %v", dst, err)
+		return
+	}
+	entries, _ := os.ReadDir(src)
+	for _, e := range entries {
+		if err := copyFile(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			log.Printf("%sCopy to %s: %v%s", red, dst, err, reset)
+		}
 	}
 }
 
+// archiveSize — размер архива; у каталога --pgbasebackup-compatible сумма файлов.
+func archiveSize(path string) int64 {
+	var total int64
+	_ = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if info, err := d.Info(); err == nil {
+				total += info.Size()
+			}
+		}
+		return nil
+	})
+	return total
+}
+
+func printFileSize(path string) {
+	size := archiveSize(path)
+	logEvent(green, map[string]any{"archive": path, "size_bytes": size},
+		"💾 Archive size: %.2f MB", float64(size)/(1024*1024))
+}
+
 /******************** ROTATION / CLEANUP ********************/
 
+// localArchives — архивы уровня ротации: *.tar.<ext> и каталоги
+// --pgbasebackup-compatible.
+func localArchives(dir string) []string {
+	files := globArchives(dir)
+	dirs, _ := filepath.Glob(filepath.Join(dir, "*"+baseBackupSuffix))
+	return append(files, dirs...)
+}
+
 func rotateCopies(dir string, copies int) {
-	files, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz"))
+	files := localArchives(dir)
 	if len(files) <= copies {
 		return
 	}
@@ -502,50 +2384,244 @@ func rotateCopies(dir string, copies int) {
 		fj, _ := os.Stat(files[j])
 		return fi.ModTime().After(fj.ModTime())
 	})
+	good := safeRotateBarrier(files)
 	for _, f := range files[copies:] {
-		log.Printf("🧹 Deleting extra archive %s", filepath.Base(f))
-		_ = os.Remove(f)
+		if !safeToDelete(f, good) {
+			continue
+		}
+		log.Printf("🧹 %sDeleting extra archive %s", dryRunTag(), filepath.Base(f))
+		removeArchive(f)
 	}
 }
 
 func cleanupOldFiles(dir string, days int) {
-	files, _ := filepath.Glob(filepath.Join(dir, "*.tar.gz"))
+	files := localArchives(dir)
 	cutoff := time.Now().AddDate(0, 0, -days)
+	var good time.Time
+	verified := false
 	for _, f := range files {
 		if info, err := os.Stat(f); err == nil && info.ModTime().Before(cutoff) {
-			log.Printf("🧹 Deleting old archive %s", filepath.Base(f))
-			_ = os.Remove(f)
+			if !verified { // проверяем лениво — только если есть что удалять
+				good, verified = safeRotateBarrier(files), true
+			}
+			if !safeToDelete(f, good) {
+				continue
+			}
+			log.Printf("🧹 %sDeleting old archive %s", dryRunTag(), filepath.Base(f))
+			removeArchive(f)
+		}
+	}
+}
+
+// safeRotateBarrier: при --safe-rotate — mtime самого свежего целого архива.
+func safeRotateBarrier(files []string) time.Time {
+	if !safeRotate {
+		return time.Time{}
+	}
+	good := newestVerifiedArchive(files)
+	if good.IsZero() {
+		log.Printf("%s⛔ No archive in %s passes verification — rotation skipped%s",
+			red, filepath.Dir(files[0]), reset)
+	}
+	return good
+}
+
+// safeToDelete: удалять можно только архивы старше проверенного.
+func safeToDelete(path string, good time.Time) bool {
+	if !safeRotate {
+		return true
+	}
+	info, err := os.Stat(path)
+	if err != nil || good.IsZero() || !info.ModTime().Before(good) {
+		if !good.IsZero() {
+			log.Printf("%s🛡  Keeping %s: no verified newer archive%s", yellow, filepath.Base(path), reset)
+		}
+		return false
+	}
+	return true
+}
+
+// partialSuffix — архив, который ещё пишется; --list и ротация его не видят.
+const partialSuffix = ".partial"
+
+// removePartials удаляет .partial, брошенные упавшим прогоном. Вызывается
+// под lock кластера, так что чужих недописанных архивов здесь нет.
+func removePartials(dir string) {
+	partials, _ := filepath.Glob(filepath.Join(dir, "*"+partialSuffix))
+	for _, p := range partials {
+		log.Printf("%s🧹 Removing unfinished %s left by an interrupted run%s", yellow, filepath.Base(p), reset)
+		if !dryRun {
+			_ = os.Remove(p)
 		}
 	}
 }
 
+// файлы, которые живут рядом с архивом и удаляются вместе с ним
+var archiveSidecars = []string{".backup_label", ".tablespace_map", checksumSuffix, fileListSuffix, dedupSuffix, manifestSuffix}
+
+func removeArchive(path string) {
+	if dryRun {
+		return
+	}
+	_ = os.RemoveAll(path) // каталог --pgbasebackup-compatible целиком
+	for _, ext := range archiveSidecars {
+		_ = os.Remove(path + ext)
+	}
+}
+
 /******************** LOCK ********************/
 
-func acquireLock() {
+// errLockHeld — кластер уже бэкапится другим процессом.
+var errLockHeld = errors.New("another backup is already running")
+
+var (
+	heldLocksMu sync.Mutex
+	heldLocks   = map[string]bool{} // для снятия по SIGINT/SIGTERM
+)
+
+// lockPath: у кластера по умолчанию прежний путь, у остальных свой файл,
+// чтобы параллельные прогоны по разным кластерам не мешали друг другу.
+// {cluster} в --lock-file задаёт место имени кластера явно.
+func lockPath(cl cluster) string {
+	if strings.Contains(lockFile, "{cluster}") {
+		return strings.ReplaceAll(lockFile, "{cluster}", cl.Name)
+	}
+	if cl.Name == defaultCluster {
+		return lockFile
+	}
+	return strings.TrimSuffix(lockFile, ".lock") + "." + cl.Name + ".lock"
+}
+
+// lockInfo — содержимое lock-файла: PID в первой строке (как раньше),
+// дальше «started <RFC3339>» и «proc_start <метка>» (см. processStart):
+// после перезагрузки с очищенным /tmp или без неё PID могут выдать другому
+// процессу, и живой PID ещё не значит, что бэкап идёт.
+type lockInfo struct {
+	PID       int
+	Started   time.Time
+	ProcStart string
+}
+
+func (l lockInfo) String() string {
+	s := strconv.Itoa(l.PID) + "\nstarted " + l.Started.Format(time.RFC3339) + "\n"
+	if l.ProcStart != "" {
+		s += "proc_start " + l.ProcStart + "\n"
+	}
+	return s
+}
+
+func parseLockInfo(data string) lockInfo {
+	lines := strings.Split(strings.TrimSpace(data), "\n")
+	var l lockInfo
+	l.PID, _ = strconv.Atoi(strings.TrimSpace(lines[0]))
+	for _, line := range lines[1:] {
+		k, v, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch k {
+		case "started":
+			l.Started, _ = time.Parse(time.RFC3339, v)
+		case "proc_start":
+			l.ProcStart = v
+		}
+	}
+	return l
+}
+
+// lockHolderAlive: PID жив и, если метка запуска известна, это тот же процесс.
+func lockHolderAlive(l lockInfo) bool {
+	if l.PID <= 0 || !processAlive(l.PID) {
+		return false
+	}
+	if l.ProcStart == "" {
+		return true // старый lock или ОС без /proc
+	}
+	cur, ok := processStart(l.PID)
+	if ok && cur != l.ProcStart {
+		log.Printf("%sPID %d from the lock file now belongs to another process, removing the stale lock%s", yellow, l.PID, reset)
+		return false
+	}
+	return true
+}
+
+func acquireLock(path string) error {
+	if noLock {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("cannot create lock directory: %w", err)
+	}
 	try := func() error {
-		f, err := os.OpenFile(lockFile, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
 		if err != nil {
 			return err
 		}
 		defer f.Close()
-		_, _ = f.WriteString(strconv.Itoa(os.Getpid()))
+		l := lockInfo{PID: os.Getpid(), Started: time.Now()}
+		l.ProcStart, _ = processStart(l.PID)
+		_, _ = f.WriteString(l.String())
 		return nil
 	}
 	if err := try(); err == nil {
-		return
+		markLock(path, true)
+		return nil
 	}
 	// stale?
-	data, _ := os.ReadFile(lockFile)
-	if pid, _ := strconv.Atoi(strings.TrimSpace(string(data))); pid > 0 {
-		if proc, _ := os.FindProcess(pid); proc != nil &&
-			proc.Signal(syscall.Signal(0)) == nil {
-			log.Fatalf("%sBackup already running (PID %d)%s", red, pid, reset)
+	data, _ := os.ReadFile(path)
+	if l := parseLockInfo(string(data)); lockHolderAlive(l) {
+		since := ""
+		if !l.Started.IsZero() {
+			since = ", started " + l.Started.Format("2006-01-02 15:04:05")
 		}
+		log.Printf("%sBackup already running (PID %d%s), skipping this run%s", yellow, l.PID, since, reset)
+		runLockHeldHook(path, l.PID)
+		return fmt.Errorf("%w (PID %d)", errLockHeld, l.PID)
 	}
-	_ = os.Remove(lockFile)
+	_ = os.Remove(path)
 	if err := try(); err != nil {
-		log.Fatalf("%sCannot create lock file: %v%s", red, err, reset)
+		return fmt.Errorf("cannot create lock file: %w", err)
+	}
+	markLock(path, true)
+	return nil
+}
+
+func releaseLock(path string) {
+	if noLock {
+		return
+	}
+	_ = os.Remove(path)
+	markLock(path, false)
+}
+
+func markLock(path string, held bool) {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	if held {
+		heldLocks[path] = true
+	} else {
+		delete(heldLocks, path)
+	}
+}
+
+func releaseAllLocks() {
+	heldLocksMu.Lock()
+	defer heldLocksMu.Unlock()
+	for path := range heldLocks {
+		_ = os.Remove(path)
 	}
 }
 
-func releaseLock() { _ = os.Remove(lockFile) }
+// runLockHeldHook даёт мониторингу отличить «пропущен из-за пересечения»
+// от «не запускался вовсе». PID владельца lock передаётся через окружение.
+func runLockHeldHook(path string, pid int) {
+	if onLockHeld == "" {
+		return
+	}
+	cmd := shellCommand(onLockHeld)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"PGBACKUP_EVENT=lock-held",
+		"PGBACKUP_LOCK_FILE="+path,
+		"PGBACKUP_LOCK_PID="+strconv.Itoa(pid))
+	if err := cmd.Run(); err != nil {
+		log.Printf("%s--on-lock-held hook failed: %v%s", red, err, reset)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+// processStart — метка запуска процесса: boot_id и starttime (поле 22
+// /proc/<pid>/stat, тики с загрузки). Совпадает только у того же процесса:
+// PID после перезагрузки или переполнения счётчика даст другую.
+func processStart(pid int) (string, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return "", false
+	}
+	// comm в скобках может содержать пробелы — считаем поля после ")"
+	i := strings.LastIndexByte(string(data), ')')
+	if i < 0 {
+		return "", false
+	}
+	fields := strings.Fields(string(data[i+1:]))
+	if len(fields) < 20 {
+		return "", false
+	}
+	boot, _ := os.ReadFile("/proc/sys/kernel/random/boot_id")
+	return strings.TrimSpace(string(boot)) + ":" + fields[19], true
+}
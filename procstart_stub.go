@@ -0,0 +1,7 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+// заглушка: без /proc lock сверяется только по PID.
+func processStart(pid int) (string, bool) { return "", false }
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+/******************** PROGRESS ********************/
+
+// --progress-interval: раз в N секунд архивация пишет в лог, сколько
+// файлов и байт уже прочитано и с какой скоростью — чтобы многочасовой
+// бэкап не выглядел зависшим. 0 — молчать.
+var progressInterval time.Duration
+
+type progress struct {
+	files atomic.Int64
+	bytes atomic.Int64
+	start time.Time
+	done  chan struct{}
+}
+
+// startProgress запускает периодический лог и отдаёт счётчик в /status
+// кластера; nil при --progress-interval 0 без --status-addr и --listen.
+func startProgress(cluster string) *progress {
+	if dryRun || progressInterval <= 0 && statusAddr == "" && listenAddr == "" {
+		return nil
+	}
+	p := &progress{start: time.Now(), done: make(chan struct{})}
+	statusProgress(cluster, p)
+	if progressInterval > 0 {
+		go p.loop()
+	}
+	return p
+}
+
+func (p *progress) loop() {
+	t := time.NewTicker(progressInterval)
+	defer t.Stop()
+	var last int64
+	lastAt := p.start
+	for {
+		select {
+		case <-p.done:
+			return
+		case now := <-t.C:
+			n, files := p.bytes.Load(), p.files.Load()
+			rate := mbps(n-last, now.Sub(lastAt))
+			logEvent(cyan, map[string]any{"files": files, "bytes_read": n, "mb_per_second": rate},
+				"⏳ %d file(s), %.2f GB read, %.1f MB/s now, %.1f MB/s average",
+				files, gb(n), rate, mbps(n, now.Sub(p.start)))
+			last, lastAt = n, now
+		}
+	}
+}
+
+func mbps(n int64, d time.Duration) float64 {
+	if d <= 0 {
+		return 0
+	}
+	return float64(n) / (1024 * 1024) / d.Seconds()
+}
+
+func (p *progress) stop() {
+	if p != nil {
+		close(p.done)
+	}
+}
+
+// fileDone засчитывает архивированный файл.
+func (p *progress) fileDone() {
+	if p != nil {
+		p.files.Add(1)
+	}
+}
+
+// writer считает байты, проходящие в архив.
+func (p *progress) writer(w io.Writer) io.Writer {
+	if p == nil {
+		return w
+	}
+	return progressWriter{w, p}
+}
+
+type progressWriter struct {
+	w io.Writer
+	p *progress
+}
+
+func (pw progressWriter) Write(b []byte) (int, error) {
+	n, err := pw.w.Write(b)
+	pw.p.bytes.Add(int64(n))
+	return n, err
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+/******************** QUIET WINDOW ********************/
+
+// currentLoad — значение сигнала --load-signal: число активных запросов
+// (pg_stat_activity, без нас самих) или loadavg за минуту.
+func currentLoad(db *sql.DB) (float64, error) {
+	switch loadSignal {
+	case "loadavg":
+		if l, ok := loadAvg(); ok {
+			return l, nil
+		}
+		return 0, fmt.Errorf("load average is not available on this platform")
+	default:
+		var n float64
+		err := db.QueryRow(`SELECT count(*) FROM pg_stat_activity
+			WHERE state = 'active' AND backend_type = 'client backend' AND pid <> pg_backend_pid()`).Scan(&n)
+		return n, err
+	}
+}
+
+// waitQuietWindow откладывает чтение data directory, пока нагрузка выше
+// --max-load, но не дольше --max-wait: потом бэкап идёт как есть.
+func waitQuietWindow(ctx context.Context, db *sql.DB) {
+	if maxLoad <= 0 {
+		return
+	}
+	deadline := time.Now().Add(maxWait)
+	logged := time.Time{}
+	for {
+		load, err := currentLoad(db)
+		if err != nil {
+			log.Printf("%s⚠️  --max-load: %v — not waiting%s", yellow, err, reset)
+			return
+		}
+		if load <= maxLoad {
+			if !logged.IsZero() {
+				log.Printf("%s🌙 Load %s is %.2f, starting%s", green, loadSignal, load, reset)
+			}
+			return
+		}
+		if !time.Now().Before(deadline) {
+			log.Printf("%s⚠️  Load %s still %.2f after --max-wait %s — backing up anyway%s", yellow, loadSignal, load, maxWait, reset)
+			return
+		}
+		if time.Since(logged) >= 5*time.Minute {
+			log.Printf("%s⏳ Load %s is %.2f (> --max-load %.2f), waiting up to %s%s",
+				yellow, loadSignal, load, maxLoad, time.Until(deadline).Round(time.Second), reset)
+			logged = time.Now()
+		}
+		select {
+		case <-time.After(min(30*time.Second, time.Until(deadline))):
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+/******************** RATE LIMITS ********************/
+
+// --upload-rate-limit — общий предел для всех загрузок разом (FTP, поток
+// --stream-ftp, S3, SFTP): token bucket, ведро — одна секунда трафика.
+// --read-rate-limit — то же для чтения data directory при архивации, чтобы
+// бэкап не отнимал диск у живой базы.
+var (
+	uploadRateLimit sizeFlag
+	uploadLimiter   *rateLimiter
+	readRateLimit   sizeFlag
+	readLimiter     *rateLimiter
+	limiterMu       sync.Mutex
+)
+
+type rateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // байт в секунду
+	tokens float64
+	last   time.Time
+}
+
+// wait списывает n байт и спит, если ведро ушло в минус. Сон под mu:
+// параллельные загрузки встают в очередь и вместе не превышают предел.
+func (l *rateLimiter) wait(n int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.rate {
+		l.tokens = l.rate
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		time.Sleep(time.Duration(-l.tokens / l.rate * float64(time.Second)))
+	}
+}
+
+type throttledReader struct {
+	r     io.Reader
+	l     *rateLimiter
+	chunk int
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > t.chunk {
+		p = p[:t.chunk]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		t.l.wait(n)
+	}
+	return n, err
+}
+
+// throttle ограничивает чтение r по --upload-rate-limit; без флага
+// возвращает r как есть.
+func throttle(r io.Reader) io.Reader {
+	return limitReader(r, uploadRateLimit, &uploadLimiter)
+}
+
+// throttleRead — то же по --read-rate-limit для файлов data directory.
+func throttleRead(r io.Reader) io.Reader {
+	return limitReader(r, readRateLimit, &readLimiter)
+}
+
+// limitReader оборачивает r общим для всех вызовов ведром *l.
+func limitReader(r io.Reader, limit sizeFlag, l **rateLimiter) io.Reader {
+	if limit <= 0 {
+		return r
+	}
+	limiterMu.Lock()
+	if *l == nil {
+		*l = &rateLimiter{rate: float64(limit), last: time.Now()}
+	}
+	limiterMu.Unlock()
+	chunk := 32 << 10
+	if int64(limit) < int64(chunk) {
+		chunk = int(limit)
+	}
+	return &throttledReader{r: r, l: *l, chunk: chunk}
+}
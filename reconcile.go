@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/jlaffaye/ftp"
+)
+
+/******************** RECONCILE ********************/
+
+// --reconcile сравнивает локальные уровни ротации с каждым FTP: чего нет
+// на сервере, чего нет локально и где размеры разошлись. Архив только на
+// FTP — обычно норма (FTP хранит дольше, см. --ftp-keep-factor), а вот
+// только локальный или другого размера — потерянная офсайт-копия:
+// с --reupload она загружается заново.
+
+type reconcileDiff struct {
+	Rel         string // <кластер>/<уровень>/<имя>
+	Kind        string // local-only, remote-only, size mismatch
+	Local, Size int64  // размер локально и на FTP; -1 — нет или каталог
+}
+
+func reconcile(reupload bool) int {
+	initFTP()
+	if !ftpEnabled {
+		log.Printf("%sNo FTP accounts configured%s", red, reset)
+		return exitFailure
+	}
+	if len(clusters) == 0 {
+		clusters = clusterList{{Name: defaultCluster}}
+	}
+	localRoot := catalogRoot()
+	local := localArchiveSizes(localRoot)
+	status := 0
+	for _, acc := range ftpAccounts {
+		c, err := dialFTP(acc)
+		if err != nil {
+			log.Printf("%sFTP %s: %v%s", red, acc.Host, err, reset)
+			status = exitFailure
+			continue
+		}
+		host, _ := os.Hostname()
+		remote := remoteArchiveSizes(c, path.Join("/", host, backupSubdir))
+		_ = c.Quit()
+
+		diffs := diffArchives(local, remote)
+		if len(diffs) == 0 {
+			log.Printf("%s✅ %s: in sync, %d archive(s)%s", green, acc.id(), len(local), reset)
+			continue
+		}
+		fmt.Printf("%s%s:%s\n", cyan, acc.id(), reset)
+		missing := 0
+		for _, d := range diffs {
+			switch d.Kind {
+			case "size mismatch":
+				fmt.Printf("  %-14s %-60s local %d B, FTP %d B\n", d.Kind, d.Rel, d.Local, d.Size)
+			default:
+				fmt.Printf("  %-14s %s\n", d.Kind, d.Rel)
+			}
+			if d.Kind != "remote-only" {
+				missing++
+			}
+		}
+		if missing == 0 {
+			continue // только лишнее на FTP: чистит ротация или --list-ftp-orphans
+		}
+		if !reupload {
+			log.Printf("%s⚠️  %s: %d archive(s) have no valid offsite copy (--reupload uploads them)%s", yellow, acc.id(), missing, reset)
+			status = exitFailure
+			continue
+		}
+		for _, d := range diffs {
+			if d.Kind == "remote-only" {
+				continue
+			}
+			p := filepath.Join(localRoot, filepath.FromSlash(d.Rel))
+			ok := uploadWithRetries(runCtx, acc, p, ftpRemoteRel(p))
+			setCatalogUpload(d.Rel, acc.id(), ok)
+			if !ok {
+				log.Printf("%s❌ %s: re-upload of %s failed%s", red, acc.id(), d.Rel, reset)
+				status = exitUpload
+				continue
+			}
+			log.Printf("%s📤 %s: re-uploaded %s%s", green, acc.id(), d.Rel, reset)
+		}
+	}
+	return status
+}
+
+// localArchiveSizes — архивы выбранных кластеров по уровням:
+// "<кластер>/<уровень>/<имя>" → размер (-1 для каталога pg_basebackup).
+func localArchiveSizes(root string) map[string]int64 {
+	out := map[string]int64{}
+	for _, cl := range clusters {
+		for _, tier := range catalogTiers {
+			for _, a := range localArchives(filepath.Join(root, cl.Name, tier)) {
+				info, err := os.Stat(a)
+				if err != nil {
+					continue
+				}
+				size := info.Size()
+				if info.IsDir() {
+					size = -1
+				}
+				out[path.Join(cl.Name, tier, filepath.Base(a))] = size
+			}
+		}
+	}
+	return out
+}
+
+func remoteArchiveSizes(c *ftp.ServerConn, root string) map[string]int64 {
+	out := map[string]int64{}
+	for _, cl := range clusters {
+		for _, tier := range catalogTiers {
+			list, err := c.List(path.Join(root, cl.Name, tier))
+			if err != nil {
+				continue // уровня на сервере ещё нет
+			}
+			for _, e := range list {
+				rel := path.Join(cl.Name, tier, e.Name)
+				switch {
+				case e.Type == ftp.EntryTypeFolder && baseBackupDirRe.MatchString(e.Name):
+					out[rel] = -1
+				case e.Type == ftp.EntryTypeFile && isArchiveFile(e.Name):
+					out[rel] = int64(e.Size)
+				}
+			}
+		}
+	}
+	return out
+}
+
+func diffArchives(local, remote map[string]int64) []reconcileDiff {
+	var diffs []reconcileDiff
+	for rel, size := range local {
+		rsize, ok := remote[rel]
+		switch {
+		case !ok:
+			diffs = append(diffs, reconcileDiff{rel, "local-only", size, -1})
+		case size >= 0 && rsize != size:
+			diffs = append(diffs, reconcileDiff{rel, "size mismatch", size, rsize})
+		}
+	}
+	for rel, rsize := range remote {
+		if _, ok := local[rel]; !ok {
+			diffs = append(diffs, reconcileDiff{rel, "remote-only", -1, rsize})
+		}
+	}
+	sort.Slice(diffs, func(i, j int) bool { return diffs[i].Rel < diffs[j].Rel })
+	return diffs
+}
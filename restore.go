@@ -0,0 +1,322 @@
+package main
+
+import (
+	"archive/tar"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/******************** SELECTIVE RESTORE ********************/
+
+// restoreFile извлекает из архива один файл или поддерево inside (путь
+// относительно data directory) в dest, не распаковывая остальное. Без
+// --from берётся самый свежий daily-архив первого кластера.
+func restoreFile(from, inside, dest string) int {
+	inside = strings.Trim(path.Clean(filepath.ToSlash(inside)), "/")
+	if inside == "" || inside == "." || dest == "" {
+		log.Printf("%s--restore-file needs a path inside the archive and --to <dest>%s", red, reset)
+		return exitFailure
+	}
+	if from == "" {
+		host, _ := os.Hostname()
+		name := defaultCluster
+		if len(clusters) > 0 {
+			name = clusters[0].Name
+		}
+		archives := localArchives(filepath.Join(backupPath, host, backupSubdir, name, "daily"))
+		sortArchives(archives)
+		if len(archives) == 0 {
+			log.Printf("%sNo archives for cluster %s%s", red, name, reset)
+			return exitFailure
+		}
+		from = archives[len(archives)-1]
+	}
+	log.Printf("%s📂 Restoring %s from %s into %s%s", cyan, inside, from, dest, reset)
+	restored, code := extractArchive(from, inside, dest)
+	if code == 0 {
+		log.Printf("%s✅ Restored %d file(s)%s", green, restored, reset)
+	}
+	return code
+}
+
+// extractArchive распаковывает из архива (или набора pg_basebackup)
+// поддерево inside; inside == "" — всё целиком.
+func extractArchive(from, inside, dest string) (int, int) {
+	if isDump(from) {
+		log.Printf("%s%s is a logical dump: restore it with psql, e.g. zcat %s | psql -d <db>%s",
+			red, from, filepath.Base(from), reset)
+		return 0, exitFailure
+	}
+	// набор pg_basebackup: base.tar.* и <oid>.tar.*, сверка с backup_manifest
+	type part struct{ tarPath, prefix string }
+	parts := []part{{from, ""}}
+	var manifest map[string]manifestEntry
+	if info, err := os.Stat(from); err == nil && info.IsDir() {
+		parts = nil
+		for _, t := range globArchives(from) {
+			if oid := archiveStem(filepath.Base(t)); oid == "base" {
+				parts = append([]part{{t, ""}}, parts...)
+			} else {
+				parts = append(parts, part{t, "pg_tblspc/" + oid + "/"})
+			}
+		}
+		if manifest, err = readManifest(filepath.Join(from, "backup_manifest")); err != nil {
+			log.Printf("%sbackup_manifest: %v — extracting without checksum verification%s", yellow, err, reset)
+		}
+	}
+
+	restored, bad := 0, 0
+	for _, p := range parts {
+		n, b, err := extractMatching(p.tarPath, p.prefix, inside, dest, manifest)
+		restored += n
+		bad += b
+		if err != nil {
+			log.Printf("%s%s: %v%s", red, p.tarPath, err, reset)
+			return restored, exitFailure
+		}
+	}
+	switch {
+	case restored == 0 && inside == "":
+		log.Printf("%s%s has no files%s", red, from, reset)
+		return 0, exitFailure
+	case restored == 0:
+		log.Printf("%s%s not found in the archive%s", red, inside, reset)
+		return 0, exitFailure
+	case bad > 0:
+		log.Printf("%s⛔ %d of %d file(s) do not match backup_manifest%s", red, bad, restored, reset)
+		return restored, exitFailure
+	}
+	return restored, 0
+}
+
+type manifestEntry struct {
+	Path     string `json:"Path"`
+	Size     int64  `json:"Size"`
+	Checksum string `json:"Checksum"`
+}
+
+func readManifest(p string) (map[string]manifestEntry, error) {
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil, err
+	}
+	var m struct{ Files []manifestEntry }
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	out := make(map[string]manifestEntry, len(m.Files))
+	for _, f := range m.Files {
+		out[f.Path] = f
+	}
+	return out, nil
+}
+
+// extractMatching распаковывает из одного архива записи под inside.
+// prefix — путь содержимого этого tar относительно data directory.
+func extractMatching(tarPath, prefix, inside, dest string, manifest map[string]manifestEntry) (restored, bad int, err error) {
+	gr, err := openArchive(tarPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer gr.Close()
+	tr := tar.NewReader(gr)
+	// одиночный файл в существующий каталог — кладём внутрь под своим именем
+	destDir := false
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		destDir = true
+	}
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return restored, bad, nil
+		}
+		if err != nil {
+			return restored, bad, err
+		}
+		// «..» в имени — попытка выйти за dest, такой архив не распаковываем
+		if slices.Contains(strings.Split(filepath.ToSlash(hdr.Name), "/"), "..") {
+			return restored, bad, fmt.Errorf("unsafe path %q in archive", hdr.Name)
+		}
+		name := strings.Trim(prefix+strings.Trim(path.Clean("/"+hdr.Name), "/"), "/")
+		var target string
+		switch {
+		case name == "":
+			continue
+		case inside == "" && archiveMetaEntry(name):
+			continue // SERVER_INFO.txt и прочее — не часть кластера
+		case inside == "":
+			target = filepath.Join(dest, filepath.FromSlash(name))
+		case name == inside && destDir:
+			target = filepath.Join(dest, path.Base(name))
+		case name == inside:
+			target = dest
+		case strings.HasPrefix(name, inside+"/"):
+			target = filepath.Join(dest, filepath.FromSlash(strings.TrimPrefix(name, inside+"/")))
+		default:
+			continue
+		}
+		if rel, err := filepath.Rel(dest, target); err != nil || strings.HasPrefix(rel, "..") {
+			return restored, bad, fmt.Errorf("unsafe path %q in archive", hdr.Name)
+		}
+		if hdr.Typeflag == tar.TypeDir {
+			if loc := hdr.PAXRecords[tablespacePAXKey]; loc != "" && inside == "" {
+				if err := linkTablespace(target, loc); err != nil {
+					return restored, bad, err
+				}
+			}
+			if err := os.MkdirAll(target, 0o700); err != nil {
+				return restored, bad, err
+			}
+			_ = os.Chmod(target, os.FileMode(hdr.Mode)&os.ModePerm)
+			continue
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			// симлинки (pg_tblspc/<oid>) вели бы за пределы dest
+			log.Printf("%s  ⚠️  %s: not a regular file, skipped%s", yellow, name, reset)
+			continue
+		}
+		ok, err := writeRestored(tr, hdr, target, manifest, name)
+		if err != nil {
+			return restored, bad, err
+		}
+		restored++
+		if !ok {
+			bad++
+		}
+	}
+}
+
+// overwriteRestored — накладываем инкремент: его файлы заменяют прежние.
+var overwriteRestored bool
+
+func writeRestored(r io.Reader, hdr *tar.Header, target string, manifest map[string]manifestEntry, name string) (bool, error) {
+	if _, err := os.Stat(target); err == nil && !restoreOverwrite && !overwriteRestored {
+		return false, fmt.Errorf("%s exists (use --restore-overwrite to overwrite)", target)
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return false, err
+	}
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(hdr.Mode)&os.ModePerm)
+	if err != nil {
+		return false, err
+	}
+	defer out.Close()
+	// --dedup-dir: содержимое в пуле, в архиве только ссылка
+	var blobHash hash.Hash
+	sum := hdr.PAXRecords[dedupPAXKey]
+	if sum != "" {
+		blob, err := openBlob(sum)
+		if err != nil {
+			return false, fmt.Errorf("%s: %w", name, err)
+		}
+		defer blob.Close()
+		blobHash = sha256.New()
+		r = io.TeeReader(blob, blobHash)
+	}
+	crc := crc32.New(crc32c)
+	if _, err := io.Copy(io.MultiWriter(out, crc), r); err != nil {
+		return false, err
+	}
+	if blobHash != nil && hex.EncodeToString(blobHash.Sum(nil)) != sum {
+		return false, fmt.Errorf("%s: blob %s is corrupt", name, sum)
+	}
+	// --trim-zeros: дорастить файл до исходного размера
+	if orig, err := strconv.ParseInt(hdr.PAXRecords["PGBACKUP.orig_size"], 10, 64); err == nil && orig > hdr.Size {
+		if err := out.Truncate(orig); err != nil {
+			return false, err
+		}
+	}
+	_ = os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+	log.Printf("  %s", target)
+	if manifest == nil {
+		return true, nil
+	}
+	e, found := manifest[name]
+	want := fmt.Sprintf("%x", binary.LittleEndian.AppendUint32(nil, crc.Sum32()))
+	if !found || e.Size != hdr.Size || e.Checksum != want {
+		log.Printf("%s  ⚠️  %s does not match backup_manifest%s", red, name, reset)
+		return false, nil
+	}
+	return true, nil
+}
+
+/******************** FULL RESTORE ********************/
+
+// restoreArchive — --restore: весь архив в пустой (или новый) каталог
+// dest с правами и mtime из заголовков tar. Инкремент --incremental
+// восстанавливается цепочкой: полный архив, затем инкременты по порядку.
+// Потом остаётся chown и recovery: backup_label лежит рядом с архивом
+// или внутри него.
+func restoreArchive(archive, dest string) int {
+	if archive == "" || dest == "" {
+		log.Printf("%s--restore needs an archive and --restore-to <dir>%s", red, reset)
+		return exitFailure
+	}
+	if entries, err := os.ReadDir(dest); err == nil && len(entries) > 0 && !restoreOverwrite {
+		log.Printf("%s%s is not empty (use --restore-overwrite to extract over it)%s", red, dest, reset)
+		return exitFailure
+	}
+	if err := os.MkdirAll(dest, 0o700); err != nil {
+		log.Printf("%s%v%s", red, err, reset)
+		return exitFailure
+	}
+	chain, files, err := incrementalChain(archive)
+	if err != nil {
+		log.Printf("%s%v%s", red, err, reset)
+		return exitFailure
+	}
+	checkRestoreVersion(archive)
+	start := time.Now()
+	restored := 0
+	defer func() { overwriteRestored = false }()
+	for i, a := range chain {
+		if i == 0 {
+			log.Printf("%s📂 Restoring %s into %s%s", cyan, a, dest, reset)
+		} else {
+			log.Printf("%s🧩 Applying incremental %s (%d of %d)%s", cyan, filepath.Base(a), i, len(chain)-1, reset)
+		}
+		overwriteRestored = i > 0
+		n, code := extractArchive(a, "", dest)
+		if code != 0 {
+			return code
+		}
+		restored += n
+	}
+	if err := fixTablespaceMap(dest); err != nil {
+		log.Printf("%stablespace_map: %v%s", red, err, reset)
+		return exitFailure
+	}
+	if files != nil {
+		n, err := pruneRestored(dest, files)
+		if err != nil {
+			log.Printf("%s%v%s", red, err, reset)
+			return exitFailure
+		}
+		if n > 0 {
+			log.Printf("%s🗑  Removed %d file(s) deleted since the full archive%s", cyan, n, reset)
+		}
+	}
+	log.Printf("%s✅ Restored %d file(s), %.2f MB in %s%s", green, restored,
+		float64(archiveSize(dest))/(1024*1024), time.Since(start).Round(time.Second), reset)
+	for _, ext := range []string{".backup_label", ".tablespace_map"} {
+		if _, err := os.Stat(archive + ext); err == nil {
+			log.Printf("%s📎 Copy %s into %s as %s before starting PostgreSQL%s",
+				yellow, archive+ext, dest, strings.TrimPrefix(ext, "."), reset)
+		}
+	}
+	return 0
+}
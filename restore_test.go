@@ -0,0 +1,44 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Служебные записи архива не попадают в восстановленный data directory,
+// а backup_label и tablespace_map — попадают.
+func TestRestoreArchiveSkipsMetaEntries(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "2026-01-01_03-00-00_cluster.tar.gz")
+	f, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gw := gzip.NewWriter(f)
+	tw := tar.NewWriter(gw)
+	entries := []string{serverInfoEntry, "PG_VERSION", "base/1/1234", "backup_label", "tablespace_map",
+		skippedFilesEntry, trimmedEntry}
+	for _, name := range entries {
+		if err := writeTarEntry(tw, name, name+"\n"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, c := range []interface{ Close() error }{tw, gw, f} {
+		if err := c.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dest := filepath.Join(t.TempDir(), "data")
+	if code := restoreArchive(archive, dest); code != 0 {
+		t.Fatalf("restoreArchive: exit %d", code)
+	}
+	for _, name := range entries {
+		_, err := os.Stat(filepath.Join(dest, filepath.FromSlash(name)))
+		if want := !archiveMetaEntry(name); (err == nil) != want {
+			t.Errorf("%s restored: %v, want %v", name, err == nil, want)
+		}
+	}
+}
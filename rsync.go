@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+/******************** RSYNC ********************/
+
+// --rsync-target user@host:/path — загрузка через rsync поверх SSH (SSH
+// настраивается как обычно: ~/.ssh/config или RSYNC_RSH). Раскладка та же,
+// что на FTP: <path>/<host>/postgresql-backup/<кластер>/daily/<архив>.
+// Первая попытка идёт с --fuzzy: у нового архива новое имя, и rsync берёт
+// за основу вчерашний архив из того же каталога и шлёт только разницу.
+// Недокачанное лежит в .rsync-partial/ (--partial-dir) и на вид не целый
+// архив; повторы дописывают его (--append-verify) и сверяют контрольную сумму.
+var (
+	rsyncTarget  string
+	rsyncEnabled bool
+)
+
+const rsyncPartialDir = ".rsync-partial"
+
+func rsyncTargetID() string { return "rsync://" + rsyncTarget }
+
+// checkRsyncFlags — цель удалённая и rsync установлен.
+func checkRsyncFlags() error {
+	if rsyncTarget == "" {
+		return nil
+	}
+	if !strings.Contains(rsyncTarget, ":") {
+		return fmt.Errorf("--rsync-target %q: want [user@]host:/path", rsyncTarget)
+	}
+	if _, err := exec.LookPath("rsync"); err != nil {
+		return fmt.Errorf("--rsync-target needs rsync: %w", err)
+	}
+	return nil
+}
+
+// rsyncDest — <target>/<rel> с завершающим «/» для каталогов.
+func rsyncDest(rel string) string {
+	return strings.TrimSuffix(rsyncTarget, "/") + "/" + rel
+}
+
+// rsyncBaseArgs — общие опции: время файлов, таймаут и --upload-rate-limit.
+func rsyncBaseArgs() []string {
+	args := []string{"--times", "--timeout=" + strconv.Itoa(int(ftpTimeout.Seconds()))}
+	if uploadRateLimit > 0 {
+		args = append(args, "--bwlimit="+strconv.FormatInt(max(int64(uploadRateLimit)/1024, 1), 10))
+	}
+	return args
+}
+
+// uploadToRsync загружает архив с sidecar-файлами (каталог
+// --pgbasebackup-compatible — целиком) и ротирует daily, как SFTP.
+func uploadToRsync(ctx context.Context, localPath string) bool {
+	rel := filepath.ToSlash(ftpRemoteRel(localPath))
+	// -R и «/./»: на цели создаются <host>/postgresql-backup/<кластер>/<уровень>/
+	root := filepath.ToSlash(strings.TrimSuffix(backupPath, string(os.PathSeparator)))
+	srcs := []string{root + "/./" + rel}
+	for _, ext := range archiveSidecars {
+		if _, err := os.Stat(localPath + ext); err == nil {
+			srcs = append(srcs, root+"/./"+rel+ext)
+		}
+	}
+	log.Printf("%s⇪ Uploading to %s: %s%s", cyan, rsyncTargetID(), rel, reset)
+	delay := 10 * time.Second
+	for attempt := 0; ; attempt++ {
+		args := append(rsyncBaseArgs(), "--recursive", "--relative", "--partial-dir="+rsyncPartialDir)
+		if attempt == 0 {
+			args = append(args, "--fuzzy")
+		} else {
+			args = append(args, "--append-verify")
+		}
+		args = append(append(args, srcs...), rsyncDest(""))
+		err := runRsync(ctx, args)
+		if err == nil {
+			break
+		}
+		log.Printf("%sRsync upload %s: %v%s", red, rel, err, reset)
+		if ctxErr(ctx) != nil || attempt >= uploadRetries {
+			return false
+		}
+		log.Printf("%sRsync: retry %d/%d in %s%s", yellow, attempt+1, uploadRetries, delay, reset)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return false
+		}
+		delay *= 2
+	}
+	log.Printf("%s✅ Uploaded to %s%s", green, rsyncTargetID(), reset)
+
+	if strings.Contains(rel, "/daily/") {
+		rotateRsync(ctx, path.Dir(rel))
+		pruneWALRsync(ctx, path.Dir(path.Dir(rel)))
+	}
+	return true
+}
+
+func runRsync(ctx context.Context, args []string) error {
+	cmd := exec.CommandContext(ctx, "rsync", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// listRsync — содержимое удалённого каталога (rsync --list-only).
+func listRsync(ctx context.Context, dir string) ([]remoteFile, error) {
+	cmd := exec.CommandContext(ctx, "rsync", append(rsyncBaseArgs(), "--list-only", rsyncDest(dir)+"/")...)
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+	// drwxr-xr-x          4,096 2026/01/02 03:00:00 name
+	var entries []remoteFile
+	sc := bufio.NewScanner(bytes.NewReader(out))
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 5 || f[4] == "." {
+			continue
+		}
+		t, err := time.ParseInLocation("2006/01/02 15:04:05", f[2]+" "+f[3], time.Local)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, remoteFile{Name: strings.Join(f[4:], " "), Time: t, Dir: f[0][0] == 'd'})
+	}
+	return entries, sc.Err()
+}
+
+// rotateRsync — аналог rotateSFTP. Удаляет тем же rsync: пустой каталог
+// с --delete и фильтром только на обречённые имена.
+func rotateRsync(ctx context.Context, dir string) {
+	entries, err := listRsync(ctx, dir)
+	if err != nil {
+		log.Printf("%sRsync: cannot list %s for rotation: %v%s", yellow, dir, err, reset)
+		return
+	}
+	var archives []remoteFile
+	for _, e := range entries {
+		if !e.Dir && isArchiveFile(e.Name) || e.Dir && strings.HasSuffix(e.Name, baseBackupSuffix) {
+			archives = append(archives, e)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].Time.After(archives[j].Time) })
+
+	var doomed []remoteFile
+	if maxCopies > 0 {
+		if keep := maxCopies * ftpKeepFactor; len(archives) > keep {
+			doomed = archives[keep:]
+		}
+	} else {
+		cutoff := time.Now().AddDate(0, 0, -keepDays*ftpKeepFactor)
+		for _, e := range archives {
+			if e.Time.Before(cutoff) {
+				doomed = append(doomed, e)
+			}
+		}
+	}
+	var names []string
+	for _, e := range doomed {
+		log.Printf("🧹 (rsync) Deleting old archive %s", path.Join(dir, e.Name))
+		names = append(names, e.Name)
+		for _, ext := range archiveSidecars {
+			names = append(names, e.Name+ext)
+		}
+	}
+	if err := deleteRsync(ctx, dir, names); err != nil {
+		log.Printf("%sRsync rotation of %s: %v%s", yellow, dir, err, reset)
+	}
+}
+
+// deleteRsync удаляет из удалённого dir файлы и каталоги names: пустой
+// каталог с --delete и фильтром только на эти имена.
+func deleteRsync(ctx context.Context, dir string, names []string) error {
+	if len(names) == 0 {
+		return nil
+	}
+	empty, err := os.MkdirTemp("", "pgbackup-rsync")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(empty)
+	args := append(rsyncBaseArgs(), "--recursive", "--delete")
+	for _, name := range names {
+		args = append(args, "--include=/"+name, "--include=/"+name+"/***")
+	}
+	args = append(args, "--exclude=*", empty+"/", rsyncDest(dir)+"/")
+	return runRsync(ctx, args)
+}
+
+// pruneWALRsync — pruneRemoteWAL через rsync.
+func pruneWALRsync(ctx context.Context, clusterDir string) {
+	pruneRemoteWAL(remoteWALPruner{
+		tag:  "rsync",
+		list: func(dir string) ([]remoteFile, error) { return listRsync(ctx, dir) },
+		remove: func(dir string, names []string) int {
+			if err := deleteRsync(ctx, dir, names); err != nil {
+				log.Printf("%sRsync: cannot prune WAL in %s: %v%s", yellow, dir, err, reset)
+				return 0
+			}
+			return len(names)
+		},
+	}, clusterDir)
+}
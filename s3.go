@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+/******************** S3 ********************/
+
+// s3Target — ключ результата загрузки в S3 (рядом с user@host у FTP).
+func s3Target() string { return "s3://" + s3Bucket }
+
+func newS3Client() (*minio.Client, error) {
+	endpoint, secure := s3Endpoint, true
+	switch {
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint, secure = strings.TrimPrefix(endpoint, "http://"), false
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = strings.TrimPrefix(endpoint, "https://")
+	}
+	return minio.New(strings.TrimSuffix(endpoint, "/"), &minio.Options{
+		Creds:  credentials.NewStaticV4(s3AccessKey, s3SecretKey, ""),
+		Secure: secure,
+		Region: s3Region,
+	})
+}
+
+// s3Key — ключ объекта: --s3-prefix плюс тот же путь, что на FTP.
+func s3Key(rel string) string {
+	return path.Join(s3Prefix, filepath.ToSlash(rel))
+}
+
+// uploadToS3 загружает архив (каталог --pgbasebackup-compatible — по
+// файлам) и ротирует daily так же, как FTP. Большие файлы уходят
+// multipart-загрузкой частями по --part-size.
+func uploadToS3(ctx context.Context, localPath string) bool {
+	c, err := newS3Client()
+	if err != nil {
+		log.Printf("%sS3: %v%s", red, err, reset)
+		return false
+	}
+	key := s3Key(ftpRemoteRel(localPath))
+	log.Printf("%s⇪ Uploading to %s: %s%s", cyan, s3Target(), key, reset)
+
+	opts := minio.PutObjectOptions{PartSize: uint64(partSize)}
+	err = filepath.WalkDir(localPath, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, _ := filepath.Rel(localPath, p)
+		return putS3File(ctx, c, path.Join(key, filepath.ToSlash(rel)), p, opts)
+	})
+	if err != nil {
+		log.Printf("%sS3 upload %s: %v%s", red, key, err, reset)
+		return false
+	}
+	for _, ext := range archiveSidecars {
+		if _, err := os.Stat(localPath + ext); err == nil {
+			if err := putS3File(ctx, c, key+ext, localPath+ext, opts); err != nil {
+				log.Printf("%sS3 upload %s: %v%s", yellow, key+ext, err, reset)
+			}
+		}
+	}
+	log.Printf("%s✅ Uploaded to %s%s", green, s3Target(), reset)
+
+	if strings.Contains(key, "/daily/") {
+		rotateS3(c, path.Dir(key)+"/")
+		pruneWALS3(c, path.Dir(path.Dir(key)))
+	}
+	return true
+}
+
+// putS3File — FPutObject через throttle (--upload-rate-limit).
+func putS3File(ctx context.Context, c *minio.Client, key, local string, opts minio.PutObjectOptions) error {
+	f, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	_, err = c.PutObject(ctx, s3Bucket, key, throttle(withContext(ctx, f)), info.Size(), opts)
+	return err
+}
+
+type s3Archive struct {
+	key  string // объект или «каталог» набора pg_basebackup (с /)
+	time time.Time
+}
+
+// rotateS3 — аналог rotateCopiesFTP/cleanupOldFilesFTP для префикса daily.
+func rotateS3(c *minio.Client, dir string) {
+	ctx := context.Background()
+	var archives []s3Archive
+	for o := range c.ListObjects(ctx, s3Bucket, minio.ListObjectsOptions{Prefix: dir}) {
+		if o.Err != nil {
+			log.Printf("%sS3 list %s: %v%s", yellow, dir, o.Err, reset)
+			return
+		}
+		name := strings.TrimSuffix(path.Base(o.Key), "/")
+		switch {
+		case strings.HasSuffix(o.Key, "/") && strings.HasSuffix(name, baseBackupSuffix):
+			// у префикса нет времени — берём из имени
+			if m := archiveTimeRe.FindString(name); m != "" {
+				t, _ := time.ParseInLocation(archiveTimeLayout, m, time.Local)
+				archives = append(archives, s3Archive{o.Key, t})
+			}
+		case isArchiveFile(name):
+			archives = append(archives, s3Archive{o.Key, o.LastModified})
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].time.After(archives[j].time) })
+
+	var doomed []s3Archive
+	if maxCopies > 0 {
+		if keep := maxCopies * ftpKeepFactor; len(archives) > keep {
+			doomed = archives[keep:]
+		}
+	} else {
+		cutoff := time.Now().AddDate(0, 0, -keepDays*ftpKeepFactor)
+		for _, a := range archives {
+			if a.time.Before(cutoff) {
+				doomed = append(doomed, a)
+			}
+		}
+	}
+	for _, a := range doomed {
+		log.Printf("🧹 (S3) Deleting old archive %s", a.key)
+		// вместе с архивом уходят его .backup_label/.tablespace_map
+		for o := range c.ListObjects(ctx, s3Bucket, minio.ListObjectsOptions{Prefix: a.key, Recursive: true}) {
+			if o.Err != nil {
+				break
+			}
+			if err := c.RemoveObject(ctx, s3Bucket, o.Key, minio.RemoveObjectOptions{}); err != nil {
+				log.Printf("%sS3 delete %s: %v%s", yellow, o.Key, err, reset)
+			}
+		}
+	}
+}
+
+// pruneWALS3 — pruneRemoteWAL в S3 для префикса кластера clusterDir.
+func pruneWALS3(c *minio.Client, clusterDir string) {
+	ctx := context.Background()
+	pruneRemoteWAL(remoteWALPruner{
+		tag: "S3",
+		list: func(dir string) ([]remoteFile, error) {
+			var files []remoteFile
+			for o := range c.ListObjects(ctx, s3Bucket, minio.ListObjectsOptions{Prefix: dir + "/"}) {
+				if o.Err != nil {
+					return nil, o.Err
+				}
+				files = append(files, remoteFile{path.Base(strings.TrimSuffix(o.Key, "/")), o.LastModified, strings.HasSuffix(o.Key, "/")})
+			}
+			return files, nil
+		},
+		remove: func(dir string, names []string) int {
+			removed := 0
+			for _, name := range names {
+				if err := c.RemoveObject(ctx, s3Bucket, dir+"/"+name, minio.RemoveObjectOptions{}); err != nil {
+					log.Printf("%sS3 delete %s: %v%s", yellow, dir+"/"+name, err, reset)
+					continue
+				}
+				removed++
+			}
+			return removed
+		},
+	}, clusterDir)
+}
+
+// checkS3Flags — все обязательные параметры S3 заданы.
+func checkS3Flags() error {
+	if s3Bucket == "" {
+		return nil
+	}
+	if s3Endpoint == "" || s3AccessKey == "" || s3SecretKey == "" {
+		return fmt.Errorf("--s3-bucket needs --s3-endpoint, --s3-access-key and --s3-secret-key")
+	}
+	return nil
+}
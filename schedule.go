@@ -0,0 +1,199 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"github.com/robfig/cron/v3"
+)
+
+/******************** SCHEDULER ********************/
+
+// runScheduled держит процесс запущенным и запускает бэкап по cron-выражению.
+// Прогоны не перекрываются: пока идёт предыдущий, очередной тик пропускается
+// (lock-файлы по-прежнему защищают от внешних запусков). SIGTERM/SIGINT
+// дожидаются текущего прогона; повторный сигнал прерывает его. SIGHUP
+// перечитывает FTP-аккаунты и ротацию (см. reloadFTPConf, reloadRetention).
+func runScheduled(expr string) int {
+	logger := cron.PrintfLogger(log.Default())
+	c := cron.New(cron.WithChain(cron.Recover(logger), cron.SkipIfStillRunning(logger)))
+	id, err := c.AddFunc(expr, func() {
+		runMu.Lock()
+		code := runClusters(clusters)
+		runMu.Unlock()
+		log.Printf("%s⏰ Scheduled run finished (exit code %d), next at %s%s",
+			cyan, code, c.Entries()[0].Next.Format("2006-01-02 15:04:05"), reset)
+	})
+	if err != nil {
+		log.Printf("%s--schedule %q: %v%s", red, expr, err, reset)
+		return exitFailure
+	}
+	c.Start()
+	log.Printf("%s⏰ Scheduled mode (%s), first run at %s%s",
+		cyan, expr, c.Entry(id).Next.Format("2006-01-02 15:04:05"), reset)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			reloadFTPConf()
+			reloadRetention()
+		}
+	}()
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	log.Printf("%sStopping: waiting for a running backup to finish (signal again to abort)%s", yellow, reset)
+	go func() {
+		<-sig
+		log.Printf("%sAborting the running backup cleanly (signal again to exit now)%s", yellow, reset)
+		cancelRun(errInterrupted)
+		<-sig
+		releaseAllLocks()
+		os.Exit(exitFailure)
+	}()
+	<-c.Stop().Done()
+	return 0
+}
+
+// retentionSettings — то, что перечитывает reloadRetention: имена флагов
+// (с синонимами) и переменные.
+var retentionSettings = []struct {
+	names []string
+	p     *int
+}{
+	{[]string{"days"}, &keepDays},
+	{[]string{"copies", "c", "keep-daily"}, &maxCopies},
+	{[]string{"keep-weekly"}, &keepWeekly},
+	{[]string{"keep-monthly"}, &keepMonthly},
+	{[]string{"keep-yearly"}, &keepYearly},
+	{[]string{"ftp-keep-factor"}, &ftpKeepFactor},
+}
+
+// reloadRetention перечитывает ротацию из --config по SIGHUP. Флаг из
+// командной строки по-прежнему важнее, ключ, убранный из файла, возвращает
+// значение по умолчанию. Новые значения проверяются целиком и подменяются
+// между прогонами; при ошибке остаются прежние.
+func reloadRetention() {
+	if configFile == "" {
+		return // без --config ротация задана флагами и не меняется
+	}
+	raw, err := readConfig(configFile)
+	if err != nil {
+		log.Printf("%sRetention reload failed, keeping the old settings: %v%s", red, err, reset)
+		return
+	}
+	next, err := retentionFromConfig(raw, explicitFlags())
+	if err != nil {
+		log.Printf("%sRetention reload failed, keeping the old settings: %s: %v%s", red, configFile, err, reset)
+		return
+	}
+	if !runMu.TryLock() {
+		log.Printf("%sA backup is running, the new retention applies after it finishes%s", yellow, reset)
+		runMu.Lock()
+	}
+	for i, s := range retentionSettings {
+		*s.p = next[i]
+	}
+	runMu.Unlock()
+	log.Printf("%s🔄 Config reloaded: retention days=%d copies=%d weekly=%d monthly=%d yearly=%d ftp-keep-factor=%d%s",
+		cyan, keepDays, maxCopies, keepWeekly, keepMonthly, keepYearly, ftpKeepFactor, reset)
+}
+
+// retentionFromConfig — новые значения retentionSettings по порядку.
+func retentionFromConfig(raw map[string]any, explicit map[string]bool) ([]int, error) {
+	next := make([]int, len(retentionSettings))
+	for i, s := range retentionSettings {
+		next[i] = *s.p
+		set := false
+		for _, name := range s.names {
+			set = set || explicit[name]
+		}
+		if set {
+			continue
+		}
+		next[i], _ = strconv.Atoi(flag.Lookup(s.names[0]).DefValue)
+		for _, name := range s.names {
+			v, ok := raw[name]
+			if !ok {
+				continue
+			}
+			n, err := strconv.Atoi(fmt.Sprint(v))
+			if err != nil {
+				return nil, fmt.Errorf("%s: want a number, got %v", name, v)
+			}
+			next[i] = n
+		}
+		if next[i] < 0 {
+			return nil, fmt.Errorf("%s must not be negative", s.names[0])
+		}
+	}
+	days, copies, factor := next[0], next[1], next[5]
+	switch {
+	case factor < 1:
+		return nil, fmt.Errorf("ftp-keep-factor must be at least 1")
+	case copies == 0 && days == 0:
+		// --days 0 без --copies удалил бы все daily
+		return nil, fmt.Errorf("days must be at least 1 unless copies is set")
+	}
+	return next, nil
+}
+
+// runMu занят, пока идёт прогон: новая конфигурация подменяется только
+// между прогонами.
+var runMu sync.Mutex
+
+// reloadFTPConf перечитывает FTP-аккаунты по SIGHUP с тем же старшинством,
+// что и initFTP: --ftp-conf, поверх него ftp: из --config. Новый список
+// сначала проверяется и только потом заменяет старый; при ошибке остаётся
+// прежний. Пустой список из пропавшего или нечитаемого ftp-conf не
+// принимается: это скорее сбой, чем желание выключить загрузку.
+func reloadFTPConf() {
+	if ftpHost != "" {
+		log.Printf("%s🔄 SIGHUP: --ftp-host overrides ftp-conf, nothing to reload%s", yellow, reset)
+		return
+	}
+	log.Printf("%s🔄 SIGHUP: reloading %s%s", cyan, ftpConfFile, reset)
+	accs, confErr := parseFTPConf(ftpConfFile)
+	if confErr != nil && !os.IsNotExist(confErr) {
+		log.Printf("%sCannot read %s: %v%s", red, ftpConfFile, confErr, reset)
+	}
+	if configFile != "" {
+		fromConfig, err := reloadConfigFTP(configFile)
+		if err != nil {
+			log.Printf("%sReload failed, keeping the old config: %v%s", red, err, reset)
+			return
+		}
+		if fromConfig != nil {
+			accs, confErr = fromConfig, nil
+		}
+	}
+	switch {
+	case confErr != nil:
+		log.Printf("%sReload failed, keeping the old config: %v%s", red, confErr, reset)
+		return
+	case len(accs) == 0 && requireUpload:
+		log.Printf("%sReload failed, keeping the old config: no FTP accounts and --require-upload is set%s", red, reset)
+		return
+	}
+	if !runMu.TryLock() {
+		log.Printf("%sA backup is running, the new config applies after it finishes%s", yellow, reset)
+		runMu.Lock()
+	}
+	ftpAccounts = accs
+	ftpEnabled = len(accs) > 0
+	runMu.Unlock()
+	if !ftpEnabled {
+		log.Printf("%s🔄 Config reloaded: FTP upload disabled (no accounts)%s", yellow, reset)
+	}
+	for _, acc := range accs {
+		log.Printf("%s🔄 Config reloaded: FTP target → %s (user %s)%s", cyan, acc.Host, acc.User, reset)
+	}
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+/******************** SFTP ********************/
+
+func sftpTarget() string { return "sftp://" + sftpUser + "@" + sftpHost }
+
+// dialSFTP подключается по SSH: ключ (--sftp-key) и/или пароль, ключ
+// сервера сверяется с --sftp-known-hosts.
+func dialSFTP() (*sftp.Client, error) {
+	var auth []ssh.AuthMethod
+	if sftpKey != "" {
+		pem, err := os.ReadFile(sftpKey)
+		if err != nil {
+			return nil, err
+		}
+		signer, err := ssh.ParsePrivateKey(pem)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", sftpKey, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if sftpPass != "" {
+		auth = append(auth, ssh.Password(sftpPass))
+	}
+	hostKey, err := knownhosts.New(sftpKnownHosts)
+	if err != nil {
+		return nil, fmt.Errorf("known_hosts: %w", err)
+	}
+	addr := sftpHost
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "22")
+	}
+	conn, err := ssh.Dial("tcp", addr, &ssh.ClientConfig{
+		User:            sftpUser,
+		Auth:            auth,
+		HostKeyCallback: hostKey,
+		Timeout:         ftpTimeout,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("dial: %w", err)
+	}
+	c, err := sftp.NewClient(conn)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// uploadToSFTP — как uploadToSingleFTP: создаёт каталоги, загружает архив
+// (каталог --pgbasebackup-compatible — по файлам) и ротирует daily.
+func uploadToSFTP(ctx context.Context, localPath string) bool {
+	c, err := dialSFTP()
+	if err != nil {
+		log.Printf("%sSFTP %s: %v%s", red, sftpHost, err, reset)
+		return false
+	}
+	defer c.Close()
+	remotePath := path.Join(sftpDir, filepath.ToSlash(ftpRemoteRel(localPath)))
+	log.Printf("%s⇪ Uploading to %s: %s%s", cyan, sftpTarget(), remotePath, reset)
+
+	files := []string{localPath}
+	for _, ext := range archiveSidecars {
+		if _, err := os.Stat(localPath + ext); err == nil {
+			files = append(files, localPath+ext)
+		}
+	}
+	for _, f := range files {
+		err = filepath.WalkDir(f, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(localPath, p)
+			return putSFTP(ctx, c, p, path.Join(remotePath, filepath.ToSlash(rel)))
+		})
+		if err != nil {
+			log.Printf("%sSFTP upload %s: %v%s", red, remotePath, err, reset)
+			return false
+		}
+	}
+	log.Printf("%s✅ Uploaded to %s%s", green, sftpTarget(), reset)
+
+	if strings.Contains(remotePath, "/daily/") {
+		rotateSFTP(c, path.Dir(remotePath))
+		pruneWALSFTP(c, path.Dir(path.Dir(remotePath)))
+	}
+	return true
+}
+
+// putSFTP пишет во временный файл и переименовывает: недокачанный архив
+// не примет вид целого.
+func putSFTP(ctx context.Context, c *sftp.Client, local, remote string) error {
+	remote = path.Clean(remote)
+	if err := c.MkdirAll(path.Dir(remote)); err != nil {
+		return err
+	}
+	in, err := os.Open(local)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := remote + ".part"
+	out, err := c.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, throttle(withContext(ctx, in))); err != nil {
+		out.Close()
+		_ = c.Remove(tmp)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		_ = c.Remove(tmp)
+		return err
+	}
+	_ = c.Remove(remote)
+	return c.Rename(tmp, remote)
+}
+
+// rotateSFTP — аналог rotateCopiesFTP/cleanupOldFilesFTP.
+func rotateSFTP(c *sftp.Client, dir string) {
+	entries, err := c.ReadDir(dir)
+	if err != nil {
+		return
+	}
+	var archives []os.FileInfo
+	for _, e := range entries {
+		if e.Mode().IsRegular() && isArchiveFile(e.Name()) ||
+			e.IsDir() && strings.HasSuffix(e.Name(), baseBackupSuffix) {
+			archives = append(archives, e)
+		}
+	}
+	sort.Slice(archives, func(i, j int) bool { return archives[i].ModTime().After(archives[j].ModTime()) })
+
+	var doomed []os.FileInfo
+	if maxCopies > 0 {
+		if keep := maxCopies * ftpKeepFactor; len(archives) > keep {
+			doomed = archives[keep:]
+		}
+	} else {
+		cutoff := time.Now().AddDate(0, 0, -keepDays*ftpKeepFactor)
+		for _, e := range archives {
+			if e.ModTime().Before(cutoff) {
+				doomed = append(doomed, e)
+			}
+		}
+	}
+	for _, e := range doomed {
+		p := path.Join(dir, e.Name())
+		log.Printf("🧹 (SFTP) Deleting old archive %s", p)
+		if e.IsDir() {
+			_ = c.RemoveAll(p)
+			continue
+		}
+		_ = c.Remove(p)
+		for _, ext := range archiveSidecars {
+			_ = c.Remove(p + ext)
+		}
+	}
+}
+
+// pruneWALSFTP — pruneRemoteWAL на SFTP.
+func pruneWALSFTP(c *sftp.Client, clusterDir string) {
+	pruneRemoteWAL(remoteWALPruner{
+		tag: "SFTP",
+		list: func(dir string) ([]remoteFile, error) {
+			entries, err := c.ReadDir(dir)
+			if err != nil {
+				return nil, err
+			}
+			files := make([]remoteFile, 0, len(entries))
+			for _, e := range entries {
+				files = append(files, remoteFile{e.Name(), e.ModTime(), !e.Mode().IsRegular()})
+			}
+			return files, nil
+		},
+		remove: func(dir string, names []string) int {
+			removed := 0
+			for _, name := range names {
+				if c.Remove(path.Join(dir, name)) == nil {
+					removed++
+				}
+			}
+			return removed
+		},
+	}, clusterDir)
+}
+
+// checkSFTPFlags — все обязательные параметры SFTP заданы.
+func checkSFTPFlags() error {
+	if sftpHost == "" {
+		return nil
+	}
+	if sftpUser == "" || (sftpKey == "" && sftpPass == "") {
+		return fmt.Errorf("--sftp-host needs --sftp-user and --sftp-key or --sftp-pass")
+	}
+	if _, err := os.Stat(sftpKnownHosts); err != nil {
+		return fmt.Errorf("--sftp-known-hosts: %w (the server key must be verifiable)", err)
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+/******************** STATUS ********************/
+
+// --status-addr :8080 — GET /status отдаёт JSON о текущем прогоне: фаза
+// (connecting, archiving, uploading, idle), сколько байт уже ушло в
+// архив, время последнего успеха и последняя ошибка. Тот же /status есть
+// и на --listen. Для проб супервизора и долгих прогонов, без разбора логов.
+var statusAddr string
+
+type clusterStatus struct {
+	Phase string    `json:"phase"`
+	Since time.Time `json:"since"`
+	Bytes int64     `json:"bytes"`
+
+	prog *progress
+}
+
+var (
+	statusMu sync.Mutex
+	statuses = map[string]*clusterStatus{}
+)
+
+// setPhase переключает фазу кластера; connecting начинает новый прогон.
+func setPhase(name, phase string) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	s := statuses[name]
+	if s == nil || phase == "connecting" {
+		s = &clusterStatus{}
+		statuses[name] = s
+	}
+	s.Phase, s.Since = phase, time.Now()
+}
+
+// statusProgress — счётчик байт архивации кластера для /status.
+func statusProgress(name string, p *progress) {
+	statusMu.Lock()
+	defer statusMu.Unlock()
+	if s := statuses[name]; s != nil {
+		s.prog = p
+	}
+}
+
+type statusReport struct {
+	Phase       string                    `json:"phase"` // первого по имени занятого кластера, иначе idle
+	Bytes       int64                     `json:"bytes"` // сумма по кластерам текущего прогона
+	LastSuccess *time.Time                `json:"last_success,omitempty"`
+	LastError   string                    `json:"last_error,omitempty"`
+	LastErrorAt *time.Time                `json:"last_error_time,omitempty"`
+	Clusters    map[string]*clusterStatus `json:"clusters"`
+}
+
+func currentStatus() statusReport {
+	r := statusReport{Phase: "idle", Clusters: map[string]*clusterStatus{}}
+	statusMu.Lock()
+	names := make([]string, 0, len(statuses))
+	for name, s := range statuses {
+		c := *s
+		if c.prog != nil {
+			c.Bytes = c.prog.bytes.Load()
+		}
+		r.Clusters[name] = &c
+		r.Bytes += c.Bytes
+		names = append(names, name)
+	}
+	statusMu.Unlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if p := r.Clusters[name].Phase; p != "idle" {
+			r.Phase = p
+			break
+		}
+	}
+
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	for _, m := range metrics {
+		if !m.LastSuccess.IsZero() && (r.LastSuccess == nil || m.LastSuccess.After(*r.LastSuccess)) {
+			t := m.LastSuccess
+			r.LastSuccess = &t
+		}
+		if m.LastError != "" && (r.LastErrorAt == nil || m.LastErrorAt.After(*r.LastErrorAt)) {
+			t := m.LastErrorAt
+			r.LastError, r.LastErrorAt = m.LastError, &t
+		}
+	}
+	return r
+}
+
+func serveStatus(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	_ = enc.Encode(currentStatus())
+}
+
+// startStatusServer — отдельный сервер для --status-addr, если он не
+// совпадает с --listen.
+func startStatusServer(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", serveStatus)
+	mux.HandleFunc("/{$}", serveStatus)
+	srv := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("%s--status-addr %s: %v%s", red, addr, err, reset)
+		}
+	}()
+	log.Printf("%s🩺 Status on http://%s/status%s", cyan, addr, reset)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+/******************** TABLESPACES ********************/
+
+// Табличные пространства из CREATE TABLESPACE лежат вне data directory,
+// в pg_tblspc/<oid> только симлинк. В архив они попадают содержимым под
+// pg_tblspc/<oid>/; исходный путь — в PAX-записи PGBACKUP.tablespace
+// каталога pg_tblspc/<oid>. --restore создаёт каталог по этому пути (или
+// по --tablespace-mapping) и симлинк на него.
+const tablespacePAXKey = "PGBACKUP.tablespace"
+
+// tablespaceMapping — --tablespace-mapping olddir=newdir (повторяемый).
+var tablespaceMapping listFlag
+
+// externalTablespaces — oid → каталог для табличных пространств вне data
+// directory; встроенные (allow_in_place_tablespaces) уже внутри неё.
+func externalTablespaces(ctx context.Context, db *sql.DB) (map[string]string, error) {
+	rows, err := db.QueryContext(ctx, `SELECT oid::text, pg_tablespace_location(oid) FROM pg_tablespace`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := map[string]string{}
+	for rows.Next() {
+		var oid, loc string
+		if err := rows.Scan(&oid, &loc); err != nil {
+			return nil, err
+		}
+		if filepath.IsAbs(loc) {
+			out[oid] = loc
+		}
+	}
+	return out, rows.Err()
+}
+
+// tablespaceOID — <oid> для пути pg_tblspc/<oid>, иначе "".
+func tablespaceOID(rel string) string {
+	if filepath.Dir(rel) != "pg_tblspc" {
+		return ""
+	}
+	return filepath.Base(rel)
+}
+
+func checkTablespaceMapping() error {
+	for _, m := range tablespaceMapping {
+		old, dir, ok := strings.Cut(m, "=")
+		if !ok || !filepath.IsAbs(old) || !filepath.IsAbs(dir) {
+			return fmt.Errorf("--tablespace-mapping %q: want /old/dir=/new/dir", m)
+		}
+	}
+	return nil
+}
+
+// mapTablespace применяет --tablespace-mapping к исходному пути.
+func mapTablespace(loc string) string {
+	for _, m := range tablespaceMapping {
+		if old, dir, _ := strings.Cut(m, "="); filepath.Clean(old) == filepath.Clean(loc) {
+			return dir
+		}
+	}
+	return loc
+}
+
+// restoredTablespaces — oid → каталог, куда --restore положил табличное
+// пространство: по нему правится tablespace_map.
+var restoredTablespaces = map[string]string{}
+
+// linkTablespace создаёт каталог табличного пространства и симлинк
+// pg_tblspc/<oid> на него; записи архива под pg_tblspc/<oid>/ дальше
+// пишутся через симлинк. Инкремент находит симлинк уже готовым.
+func linkTablespace(link, orig string) error {
+	loc := mapTablespace(orig)
+	if cur, err := os.Readlink(link); err == nil {
+		if filepath.Clean(cur) != filepath.Clean(loc) {
+			return fmt.Errorf("%s already points to %s, not %s", link, cur, loc)
+		}
+		return nil
+	}
+	if entries, err := os.ReadDir(loc); err == nil && len(entries) > 0 && !restoreOverwrite {
+		return fmt.Errorf("tablespace directory %s is not empty (use --tablespace-mapping %s=<dir> or --restore-overwrite)", loc, orig)
+	}
+	if err := os.MkdirAll(loc, 0o700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(link), 0o700); err != nil {
+		return err
+	}
+	if err := os.Symlink(loc, link); err != nil {
+		return err
+	}
+	restoredTablespaces[filepath.Base(link)] = loc
+	log.Printf("  🔗 %s → %s", filepath.Join("pg_tblspc", filepath.Base(link)), loc)
+	return nil
+}
+
+// fixTablespaceMap переписывает пути в dest/tablespace_map под
+// --tablespace-mapping: по нему PostgreSQL пересоздаёт симлинки при старте.
+func fixTablespaceMap(dest string) error {
+	p := filepath.Join(dest, "tablespace_map")
+	data, err := os.ReadFile(p)
+	if err != nil {
+		return nil // нет — симлинки уже на месте
+	}
+	lines := strings.Split(strings.TrimRight(string(data), "\n"), "\n")
+	changed := false
+	for i, l := range lines {
+		oid, orig, ok := strings.Cut(l, " ")
+		if loc, found := restoredTablespaces[oid]; ok && found && loc != orig {
+			lines[i] = oid + " " + loc
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	return os.WriteFile(p, []byte(strings.Join(lines, "\n")+"\n"), 0o600)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+/******************** TIMEOUT & CANCELLATION ********************/
+
+// --timeout ограничивает один прогон кластера (запросы к PostgreSQL,
+// архивацию, pg_dump и загрузки). По истечении или по SIGINT/SIGTERM
+// архивация обрывается, pg_backup_stop всё равно вызывается — иначе
+// открытая сессия бэкапа держала бы WAL на сервере.
+var backupTimeout time.Duration
+
+var errInterrupted = errors.New("interrupted by signal")
+
+// runCtx отменяется сигналом; контексты прогонов — его потомки.
+var runCtx, cancelRun = context.WithCancelCause(context.Background())
+
+// backupContext — контекст одного прогона с --timeout.
+func backupContext() (context.Context, context.CancelFunc) {
+	if backupTimeout <= 0 {
+		return context.WithCancel(runCtx)
+	}
+	return context.WithTimeoutCause(runCtx, backupTimeout,
+		fmt.Errorf("--timeout %s exceeded", backupTimeout))
+}
+
+// ctxErr — причина отмены (--timeout или сигнал) или nil.
+func ctxErr(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+// stopContext — на завершение бэкапа после отмены: свой короткий срок.
+func stopContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), time.Minute)
+}
+
+// withContext обрывает чтение r (а с ним загрузку) после отмены ctx.
+func withContext(ctx context.Context, r io.Reader) io.Reader {
+	return ctxReader{ctx, r}
+}
+
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c ctxReader) Read(p []byte) (int, error) {
+	if err := ctxErr(c.ctx); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"os"
+)
+
+// trimmedSize возвращает длину relation-файла без хвоста из целиком нулевых
+// страниц. Режем по границе страниц: файл остаётся кратным BLCKSZ, а
+// исходный размер восстанавливается по TRIMMED.txt.
+func trimmedSize(f *os.File, size int64, blockSize int) (int64, error) {
+	if size%int64(blockSize) != 0 {
+		return size, nil
+	}
+	page := make([]byte, blockSize)
+	end := size
+	for end > 0 {
+		if _, err := f.ReadAt(page, end-int64(blockSize)); err != nil {
+			return size, err
+		}
+		for _, b := range page {
+			if b != 0 {
+				return end, nil
+			}
+		}
+		end -= int64(blockSize)
+	}
+	return end, nil
+}
@@ -0,0 +1,35 @@
+package main
+
+import "testing"
+
+// Любой отказ загрузки — код 4, в каком бы режиме он ни случился.
+func TestCheckUploadsExitCode(t *testing.T) {
+	defer func(r bool, f, m string) { requireUpload, uploadFailMode, uploadMode = r, f, m }(requireUpload, uploadFailMode, uploadMode)
+	none := map[string]bool{"ftp://a": false, "ftp://b": false}
+	half := map[string]bool{"ftp://a": true, "ftp://b": false}
+	all := map[string]bool{"ftp://a": true, "ftp://b": true}
+	for _, tc := range []struct {
+		name    string
+		require bool
+		fail    string
+		mode    string
+		uploads map[string]bool
+		want    int
+	}{
+		{"default, one failed", false, "continue", "", half, exitUpload},
+		{"default, all uploaded", false, "continue", "", all, 0},
+		{"require-upload", true, "continue", "any", none, exitUpload},
+		{"fail-mode fast", false, "fast", "", half, exitUpload},
+		{"mode any, none", false, "continue", "any", none, exitUpload},
+		{"mode any, one", false, "continue", "any", half, 0},
+		{"mode all", false, "continue", "all", half, exitUpload},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			requireUpload, uploadFailMode, uploadMode = tc.require, tc.fail, tc.mode
+			err := checkUploads("a.tar.gz", tc.uploads, len(tc.uploads))
+			if got := exitCode(err); got != tc.want {
+				t.Errorf("exit code %d (%v), want %d", got, err, tc.want)
+			}
+		})
+	}
+}
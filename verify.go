@@ -0,0 +1,160 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+/******************** VERIFY ********************/
+
+// verifyArchive читает архив целиком: распаковщик проверяет свои контрольные
+// суммы (gzip — CRC32 и длину каждого члена), tar — структуру заголовков и
+// размеры записей.
+func verifyArchive(path string) error {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		return verifyBaseBackup(path)
+	}
+	if strings.HasSuffix(path, gpgSuffix) {
+		return verifyGPGArchive(path)
+	}
+	gr, err := openArchive(path)
+	if err != nil {
+		return err
+	}
+	defer gr.Close()
+	if isDump(path) {
+		// SQL-дамп: достаточно распаковать и сверить CRC
+		if _, err := io.Copy(io.Discard, gr); err != nil {
+			return fmt.Errorf("decompress: %w", err)
+		}
+		return nil
+	}
+	tr := tar.NewReader(gr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+		// --dedup-dir: без блоба в пуле файл не восстановить
+		if sum := hdr.PAXRecords[dedupPAXKey]; sum != "" && dedupDir != "" && findBlob(sum) == "" {
+			return fmt.Errorf("%s: blob %s is missing from %s", hdr.Name, sum, dedupDir)
+		}
+		if _, err := io.Copy(io.Discard, tr); err != nil {
+			return fmt.Errorf("tar: %w", err)
+		}
+	}
+	// дочитываем хвост потока, чтобы сверить CRC последнего члена
+	if _, err := io.Copy(io.Discard, gr); err != nil {
+		return fmt.Errorf("decompress: %w", err)
+	}
+	return nil
+}
+
+// verifyBaseBackup проверяет каталог --pgbasebackup-compatible: все его
+// tar-архивы и наличие backup_manifest.
+func verifyBaseBackup(dir string) error {
+	if _, err := os.Stat(filepath.Join(dir, "backup_manifest")); err != nil {
+		return fmt.Errorf("backup_manifest: %w", err)
+	}
+	tars := globArchives(dir)
+	if len(tars) == 0 {
+		return fmt.Errorf("no base.tar")
+	}
+	for _, t := range tars {
+		if err := verifyArchive(t); err != nil {
+			return fmt.Errorf("%s: %w", filepath.Base(t), err)
+		}
+	}
+	return nil
+}
+
+// newestVerifiedArchive возвращает mtime самого свежего архива, который
+// проходит проверку (ноль, если таких нет). --safe-rotate удаляет только то,
+// что старше него: последняя живая копия не пропадёт из-за битых новых.
+func newestVerifiedArchive(files []string) time.Time {
+	type cand struct {
+		path string
+		mt   time.Time
+	}
+	var cs []cand
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil {
+			cs = append(cs, cand{f, info.ModTime()})
+		}
+	}
+	sort.Slice(cs, func(i, j int) bool { return cs[i].mt.After(cs[j].mt) })
+	for _, c := range cs {
+		if err := verifyArchive(c.path); err != nil {
+			log.Printf("%s⚠️  %s fails verification: %v%s", yellow, c.path, err, reset)
+			continue
+		}
+		return c.mt
+	}
+	return time.Time{}
+}
+
+// verifyAll проверяет все локальные архивы всех кластеров и уровней
+// ротации (не больше jobs одновременно) и печатает таблицу результатов.
+func verifyAll(jobs int) int {
+	host, _ := os.Hostname()
+	root := filepath.Join(backupPath, host, backupSubdir)
+	files := globArchives(filepath.Join(root, "*", "*"))
+	dirs, _ := filepath.Glob(filepath.Join(root, "*", "*", "*"+baseBackupSuffix))
+	files = append(files, dirs...)
+	if len(files) == 0 {
+		log.Printf("%sNo archives under %s%s", yellow, root, reset)
+		return 0
+	}
+	if jobs < 1 {
+		jobs = 1
+	}
+	type result struct {
+		size int64
+		err  error
+	}
+	start := time.Now()
+	results := make([]result, len(files))
+	sem := make(chan struct{}, jobs)
+	var wg sync.WaitGroup
+	for i, f := range files {
+		wg.Add(1)
+		go func(i int, f string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			results[i].size = archiveSize(f)
+			results[i].err = verifyArchive(f)
+		}(i, f)
+	}
+	wg.Wait()
+
+	var total int64
+	failed := 0
+	for i, f := range files {
+		rel, _ := filepath.Rel(root, f)
+		total += results[i].size
+		if err := results[i].err; err != nil {
+			failed++
+			fmt.Printf("%sFAIL%s  %10.2f MB  %s: %v\n", red, reset, float64(results[i].size)/(1024*1024), rel, err)
+			continue
+		}
+		fmt.Printf("%sOK%s    %10.2f MB  %s\n", green, reset, float64(results[i].size)/(1024*1024), rel)
+	}
+	fmt.Printf("\n%d archive(s), %d failed, %.2f GB verified in %s\n",
+		len(files), failed, float64(total)/(1024*1024*1024), time.Since(start).Round(time.Second))
+	if failed > 0 {
+		return exitFailure
+	}
+	return 0
+}
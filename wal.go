@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/jlaffaye/ftp"
+)
+
+/******************** WAL ARCHIVE ********************/
+
+// --wal-archive %p %f — режим archive_command: сегмент WAL сжимается (и
+// шифруется) в <кластер>/wal/ рядом с daily и уходит на те же FTP, S3,
+// SFTP и rsync (<host>/postgresql-backup/<кластер>/wal/). Код 0 разрешает
+// PostgreSQL удалить сегмент, поэтому он возвращается только после fsync
+// локальной копии и загрузки на все цели (см. --upload-mode). Сегменты,
+// заархивированные раньше самого старого базового бэкапа, удаляются после
+// ротации бэкапов — локально и на каждой цели.
+var walArchivePath string
+
+const walDir = "wal"
+
+// walPruneMargin — запас при чистке: LIST на FTP отдаёт время с точностью
+// до минуты или дня, а базовый бэкап мог длиться дольше, чем кажется по mtime.
+const walPruneMargin = 24 * time.Hour
+
+func archiveWAL(src, name string) int {
+	if name == "" {
+		name = filepath.Base(src)
+	}
+	if name != filepath.Base(name) || name == "." || name == ".." {
+		log.Printf("%s--wal-archive: bad WAL file name %q%s", red, name, reset)
+		return exitFailure
+	}
+	if len(clusters) > 1 {
+		log.Printf("%s--wal-archive serves a single cluster: set one --cluster%s", red, reset)
+		return exitFailure
+	}
+	host, _ := os.Hostname()
+	dir := filepath.Join(backupPath, host, backupSubdir, clusters[0].Name, walDir)
+	dst := filepath.Join(dir, name+kindExt(""))
+	if dryRun {
+		log.Printf("%s🧪 [dry-run] Would archive %s → %s%s", cyan, src, dst, reset)
+		dryRunUploads(dst)
+		return 0
+	}
+	ctx, cancel := backupContext()
+	defer cancel()
+
+	exists, err := sameWAL(src, dst)
+	if err != nil {
+		log.Printf("%s❌ WAL %s: %v%s", red, name, err, reset)
+		return exitFailure
+	}
+	if !exists {
+		if err := makeBackupDir(dir); err != nil {
+			log.Printf("%s❌ WAL %s: %v%s", red, name, err, reset)
+			return exitFailure
+		}
+		if err := writeWAL(src, dst); err != nil {
+			log.Printf("%s❌ WAL %s: %v%s", red, name, err, reset)
+			return exitArchive
+		}
+		if _, err := writeChecksum(dst); err != nil {
+			log.Printf("%sCannot write %s: %v%s", yellow, dst+checksumSuffix, err, reset)
+		}
+	}
+	// повтор после сбоя загрузки: локальная копия уже есть — догружаем
+	uploads, targets := uploadArchive(ctx, dst, nil)
+	if err := checkUploads(dst, uploads, targets); err != nil {
+		log.Printf("%s❌ WAL %s: %v — PostgreSQL will retry%s", red, name, err, reset)
+		return exitUpload
+	}
+	logEvent(green, map[string]any{"wal": name, "archive": dst, "uploads": countUploaded(uploads)},
+		"✅ WAL %s archived", name)
+	return 0
+}
+
+// sameWAL: true — сегмент с тем же содержимым уже заархивирован (повтор
+// archive_command), ошибка — под этим именем лежит другой.
+func sameWAL(src, dst string) (bool, error) {
+	if _, err := os.Stat(dst); err != nil {
+		return false, nil
+	}
+	if strings.HasSuffix(dst, gpgSuffix) {
+		// без закрытого ключа не сравнить — считаем тем же сегментом
+		log.Printf("%s⚠️  %s already archived (GPG, contents not compared)%s", yellow, filepath.Base(dst), reset)
+		return true, nil
+	}
+	want, err := os.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+	r, err := openCompressed(dst, "")
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	if err != nil {
+		return false, fmt.Errorf("%s: %w", dst, err)
+	}
+	if !bytes.Equal(got, want) {
+		return false, fmt.Errorf("%s is already archived with different contents", dst)
+	}
+	return true, nil
+}
+
+// writeWAL пишет сжатую копию в .partial, fsync и rename.
+func writeWAL(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp := dst + partialSuffix
+	out, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			out.Close()
+			_ = os.Remove(tmp)
+		}
+	}()
+	w, err := newArchiveWriter(out)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, in); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	if err := out.Sync(); err != nil {
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	chownBackup(dst)
+	return syncDir(filepath.Dir(dst))
+}
+
+// isWALHistory — .history таймлайнов нужны всегда, их не чистим.
+func isWALHistory(name string) bool {
+	return strings.Contains(name, ".history")
+}
+
+// pruneWAL удаляет в <кластер>/wal сегменты, заархивированные раньше
+// самого старого оставшегося базового бэкапа кластера.
+func pruneWAL(base string) {
+	var oldest time.Time
+	for _, tier := range catalogTiers {
+		for _, a := range localArchives(filepath.Join(base, tier)) {
+			if t := archiveTime(a); oldest.IsZero() || t.Before(oldest) {
+				oldest = t
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return
+	}
+	cutoff := oldest.Add(-walPruneMargin)
+	entries, _ := os.ReadDir(filepath.Join(base, walDir))
+	removed := 0
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil || e.IsDir() || isWALHistory(e.Name()) || !info.ModTime().Before(cutoff) {
+			continue
+		}
+		if os.Remove(filepath.Join(base, walDir, e.Name())) == nil {
+			removed++
+		}
+	}
+	if removed > 0 {
+		log.Printf("🧹 Removed %d WAL file(s) older than the oldest base backup", removed)
+	}
+}
+
+// remoteFile — запись листинга удалённого каталога (FTP, S3, SFTP, rsync).
+type remoteFile struct {
+	Name string
+	Time time.Time
+	Dir  bool
+}
+
+// remoteWALPruner — как перечислить каталог кластера на цели и удалить
+// из него WAL; чистка сама по себе у всех целей общая (pruneRemoteWAL).
+type remoteWALPruner struct {
+	tag    string                                 // FTP, S3, SFTP, rsync — для лога
+	list   func(dir string) ([]remoteFile, error) // dir — <кластер>/<уровень>
+	remove func(dir string, names []string) int   // сколько удалено
+}
+
+// pruneRemoteWAL — pruneWAL для удалённого каталога кластера clusterDir:
+// самый старый базовый бэкап ищется по всем уровням, время берётся из
+// имени архива (точнее, чем mtime листинга).
+func pruneRemoteWAL(p remoteWALPruner, clusterDir string) {
+	var oldest time.Time
+	for _, tier := range catalogTiers {
+		entries, err := p.list(clusterDir + "/" + tier)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !isArchiveFile(e.Name) && !strings.HasSuffix(e.Name, baseBackupSuffix) {
+				continue
+			}
+			t := e.Time
+			if m := archiveTimeRe.FindString(e.Name); m != "" {
+				t, _ = time.ParseInLocation(archiveTimeLayout, m, time.Local)
+			}
+			if oldest.IsZero() || t.Before(oldest) {
+				oldest = t
+			}
+		}
+	}
+	if oldest.IsZero() {
+		return
+	}
+	cutoff := oldest.Add(-walPruneMargin)
+	dir := clusterDir + "/" + walDir
+	entries, err := p.list(dir)
+	if err != nil {
+		return
+	}
+	var doomed []string
+	for _, e := range entries {
+		if !e.Dir && !isWALHistory(e.Name) && e.Time.Before(cutoff) {
+			doomed = append(doomed, e.Name)
+		}
+	}
+	if len(doomed) == 0 {
+		return
+	}
+	if removed := p.remove(dir, doomed); removed > 0 {
+		log.Printf("🧹 (%s) Removed %d WAL file(s) older than the oldest base backup", p.tag, removed)
+	}
+}
+
+// pruneWALFTP — pruneRemoteWAL на FTP.
+func pruneWALFTP(c *ftp.ServerConn, clusterDir string) {
+	pruneRemoteWAL(remoteWALPruner{
+		tag: "FTP",
+		list: func(dir string) ([]remoteFile, error) {
+			entries, err := c.List(dir)
+			if err != nil {
+				return nil, err
+			}
+			files := make([]remoteFile, 0, len(entries))
+			for _, e := range entries {
+				files = append(files, remoteFile{e.Name, e.Time, e.Type != ftp.EntryTypeFile})
+			}
+			return files, nil
+		},
+		remove: func(dir string, names []string) int {
+			removed := 0
+			for _, name := range names {
+				if c.Delete(dir+"/"+name) == nil {
+					removed++
+				}
+			}
+			return removed
+		},
+	}, clusterDir)
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+/******************** WAL PRESSURE ********************/
+
+const (
+	walCheckInterval = 30 * time.Second
+	walQueryTimeout  = 10 * time.Second // зависший запрос не должен остановить монитор
+)
+
+// walMonitor следит во время архивации, не копится ли WAL: долгий бэкап
+// держит сегменты, и если архивация WAL не успевает, pg_wal может заполнить
+// диск и остановить primary.
+type walMonitor struct {
+	db       *sql.DB
+	startLSN string
+	walDir   string
+	maxWAL   int64
+	started  time.Time
+
+	mu        sync.Mutex
+	generated int64 // байт WAL с начала бэкапа
+	peak      int64 // максимальный размер pg_wal
+	abortErr  error
+	warned    bool
+
+	stop, done chan struct{}
+}
+
+func startWALMonitor(db *sql.DB, startLSN, dataDir string) *walMonitor {
+	m := &walMonitor{db: db, startLSN: startLSN, walDir: filepath.Join(dataDir, "pg_wal"),
+		started: time.Now(), stop: make(chan struct{}), done: make(chan struct{})}
+	ctx, cancel := context.WithTimeout(context.Background(), walQueryTimeout)
+	_ = db.QueryRowContext(ctx, `SELECT pg_size_bytes(current_setting('max_wal_size'))`).Scan(&m.maxWAL)
+	cancel()
+	go func() {
+		defer close(m.done)
+		t := time.NewTicker(walCheckInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-m.stop:
+				return
+			case <-t.C:
+				m.sample()
+			}
+		}
+	}()
+	return m
+}
+
+func (m *walMonitor) sample() {
+	ctx, cancel := context.WithTimeout(context.Background(), walQueryTimeout)
+	defer cancel()
+	var generated, walSize sql.NullInt64
+	_ = m.db.QueryRowContext(ctx, `SELECT pg_wal_lsn_diff(CASE WHEN pg_is_in_recovery()
+		THEN pg_last_wal_replay_lsn() ELSE pg_current_wal_lsn() END, $1::pg_lsn)::bigint`, m.startLSN).Scan(&generated)
+	// pg_ls_waldir требует pg_monitor — без него смотрим только на диск
+	_ = m.db.QueryRowContext(ctx, `SELECT coalesce(sum(size), 0)::bigint FROM pg_ls_waldir()`).Scan(&walSize)
+	total, freeBytes, err := diskUsage(m.walDir)
+	haveFree := err == nil && total > 0
+	free := float64(freeBytes) / float64(max(total, 1))
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if generated.Valid {
+		m.generated = generated.Int64
+	}
+	if walSize.Int64 > m.peak {
+		m.peak = walSize.Int64
+	}
+	var problem string
+	switch {
+	case haveFree && free < 0.05:
+		problem = fmt.Sprintf("only %.1f%% free on the pg_wal filesystem", free*100)
+	case m.maxWAL > 0 && walPressureFactor > 0 && walSize.Int64 > int64(walPressureFactor*float64(m.maxWAL)):
+		problem = fmt.Sprintf("pg_wal is %d MB, %.1f× max_wal_size", walSize.Int64>>20, float64(walSize.Int64)/float64(m.maxWAL))
+	default:
+		return
+	}
+	if abortOnWALPressure && m.abortErr == nil {
+		m.abortErr = fmt.Errorf("WAL pressure: %s (--abort-on-wal-pressure)", problem)
+		log.Printf("%s⛔ %v — aborting the backup%s", red, m.abortErr, reset)
+		return
+	}
+	if !m.warned || haveFree && free < 0.05 {
+		log.Printf("%s⚠️  WAL is accumulating: %s; WAL archiving may not keep up%s", yellow, problem, reset)
+		m.warned = true
+	}
+}
+
+// err — не nil, если бэкап нужно прервать (проверяется на каждом файле).
+func (m *walMonitor) err() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.abortErr
+}
+
+// finish останавливает мониторинг и печатает итог по WAL.
+func (m *walMonitor) finish() {
+	close(m.stop)
+	<-m.done
+	m.sample()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	mins := time.Since(m.started).Minutes()
+	if mins <= 0 {
+		return
+	}
+	log.Printf("%s📈 WAL during backup: %.1f MB (%.1f MB/min), pg_wal peak %.1f MB%s", cyan,
+		float64(m.generated)/(1<<20), float64(m.generated)/(1<<20)/mins, float64(m.peak)/(1<<20), reset)
+}
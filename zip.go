@@ -0,0 +1,219 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/flate"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+/******************** ZIP ********************/
+
+// --format zip — физический бэкап в обычный .zip вместо .tar.gz: каждая
+// запись сжата отдельно (deflate, --compression-level), и любой файл можно
+// достать unzip'ом без распаковки всего архива. Обход data directory тот
+// же: zipEntryWriter принимает tar-заголовки, а PAX-записи (табличные
+// пространства, --dedup-dir, --trim-zeros) кладёт в комментарий записи.
+// Сам инструмент читает .zip, пересобирая из него поток tar (openZipArchive).
+var archiveContainer = "tar"
+
+const zipKind = ".zip"
+
+// entryWriter — то, во что createTarGzFromDir пишет записи: *tar.Writer
+// или zipEntryWriter.
+type entryWriter interface {
+	WriteHeader(hdr *tar.Header) error
+	Write(p []byte) (int, error)
+	Close() error
+}
+
+func checkArchiveContainer() error {
+	switch archiveContainer {
+	case "tar":
+		if zstdDict {
+			return errors.New("--zstd-dict applies to --format zip only: a tar stream already shares one compressor context")
+		}
+		return nil
+	case "zip":
+	default:
+		return fmt.Errorf("unknown format %q (want tar or zip)", archiveContainer)
+	}
+	if pgbbCompat || logicalDump || encryptKeyFile != "" || gpgPubkeyFile != "" {
+		return errors.New("zip cannot be combined with --pgbasebackup-compatible, --logical, --encrypt-key-file or --gpg-pubkey-file")
+	}
+	if zstdDict && compression != "zstd" {
+		return errors.New("--zstd-dict needs --compression zstd")
+	}
+	return nil
+}
+
+type zipEntryWriter struct {
+	zw     *zip.Writer
+	cur    io.Writer
+	method uint16
+}
+
+// newZipEntryWriter: dict — словарь zstd (--zstd-dict), он пишется
+// первой записью zstdDictEntry.
+func newZipEntryWriter(w io.Writer, dict []byte) (*zipEntryWriter, error) {
+	zw := zip.NewWriter(w)
+	method := zip.Deflate
+	switch c := compressor.(type) {
+	case noneCompressor:
+		method = zip.Store
+	case gzipCompressor:
+		if c.level != 0 {
+			zw.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(out, c.level)
+			})
+		}
+	case zstdCompressor:
+		method = zipZstd
+		zw.RegisterCompressor(zipZstd, zstdEntryCompressor(c.level, dict))
+	}
+	if dict != nil {
+		dw, err := zw.CreateHeader(&zip.FileHeader{Name: zstdDictEntry, Method: zip.Store})
+		if err != nil {
+			return nil, err
+		}
+		if _, err := dw.Write(dict); err != nil {
+			return nil, err
+		}
+	}
+	return &zipEntryWriter{zw: zw, method: method}, nil
+}
+
+func (z *zipEntryWriter) WriteHeader(hdr *tar.Header) error {
+	fh := &zip.FileHeader{Name: hdr.Name, Modified: hdr.ModTime, Method: z.method, Comment: encodePAX(hdr.PAXRecords)}
+	fh.SetMode(hdr.FileInfo().Mode())
+	if hdr.Typeflag == tar.TypeDir {
+		fh.Method = zip.Store
+	}
+	w, err := z.zw.CreateHeader(fh)
+	if err != nil {
+		return err
+	}
+	z.cur = w
+	if hdr.Typeflag == tar.TypeSymlink {
+		// в zip цель ссылки — содержимое записи
+		_, err = io.WriteString(w, hdr.Linkname)
+	}
+	return err
+}
+
+func (z *zipEntryWriter) Write(p []byte) (int, error) {
+	if z.cur == nil {
+		return 0, errors.New("zip: write before header")
+	}
+	return z.cur.Write(p)
+}
+
+func (z *zipEntryWriter) Close() error { return z.zw.Close() }
+
+// encodePAX — PAX-записи строками key=value в комментарии записи zip.
+func encodePAX(recs map[string]string) string {
+	if len(recs) == 0 {
+		return ""
+	}
+	lines := make([]string, 0, len(recs))
+	for k, v := range recs {
+		lines = append(lines, k+"="+v)
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func decodePAX(comment string) map[string]string {
+	if comment == "" {
+		return nil
+	}
+	recs := map[string]string{}
+	for _, l := range strings.Split(comment, "\n") {
+		if k, v, ok := strings.Cut(l, "="); ok {
+			recs[k] = v
+		}
+	}
+	return recs
+}
+
+// openZipArchive отдаёт .zip потоком tar: --restore, --verify-all и
+// --incremental читают его так же, как .tar.gz.
+func openZipArchive(path string) (io.ReadCloser, error) {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return nil, err
+	}
+	var dict []byte
+	if len(zr.File) > 0 && zr.File[0].Name == zstdDictEntry {
+		if dict, err = readZipEntry(zr.File[0]); err != nil {
+			zr.Close()
+			return nil, fmt.Errorf("%s: %w", zstdDictEntry, err)
+		}
+	}
+	zr.RegisterDecompressor(zipZstd, zstdEntryDecompressor(dict))
+	pr, pw := io.Pipe()
+	go func() {
+		defer zr.Close()
+		pw.CloseWithError(zipToTar(&zr.Reader, pw))
+	}()
+	return pr, nil
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+func zipToTar(zr *zip.Reader, w io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range zr.File {
+		if f.Name == zstdDictEntry {
+			continue // словарь — не часть data directory
+		}
+		mode := f.Mode()
+		hdr := &tar.Header{
+			Name:       f.Name,
+			Mode:       int64(mode.Perm()),
+			ModTime:    f.Modified,
+			PAXRecords: decodePAX(f.Comment),
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return fmt.Errorf("%s: %w", f.Name, err)
+		}
+		switch {
+		case mode.IsDir():
+			hdr.Typeflag = tar.TypeDir
+		case mode&os.ModeSymlink != 0:
+			target, err := io.ReadAll(rc)
+			if err != nil {
+				rc.Close()
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+			hdr.Typeflag, hdr.Linkname = tar.TypeSymlink, string(target)
+		default:
+			hdr.Typeflag, hdr.Size = tar.TypeReg, int64(f.UncompressedSize64)
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			rc.Close()
+			return err
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			if _, err := io.Copy(tw, rc); err != nil {
+				rc.Close()
+				return fmt.Errorf("%s: %w", f.Name, err)
+			}
+		}
+		rc.Close()
+	}
+	return tw.Close()
+}
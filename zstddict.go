@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+/******************** ZSTD DICTIONARY ********************/
+
+// --zstd-dict (с --format zip --compression zstd): в tar.zst все файлы
+// сжимаются одним потоком и помогают друг другу, а в zip каждая запись
+// начинает с нуля — тысячи мелких файлов отношений маленьких баз почти
+// не сжимаются. Словарь обучается на мелких файлах base/ этого же
+// кластера, кладётся в архив первой записью и подставляется в каждую
+// следующую. Читает такой архив только сам инструмент (openZipArchive).
+var zstdDict bool
+
+const (
+	zstdDictEntry = ".zstd-dict" // первая запись zip со словарём
+	zipZstd       = 93           // метод Zstandard в спецификации zip
+
+	dictSampleMax   = 128 << 10 // файлы крупнее в обучение не идут
+	dictSampleIndex = 32 << 10  // от файла в обучение — начало, как у builddict
+	dictSamplesSize = 8 << 20   // всего байт образцов: обучение ~0.5 с на МиБ
+	dictMinSamples  = 16        // меньше — словарь не нужен
+	dictMaxSize     = 112 << 10 // размер словаря, как у zstd --train
+)
+
+// trainZstdDict обучает словарь на мелких файлах base/ в dataDir; nil —
+// образцов слишком мало.
+func trainZstdDict(dataDir string) ([]byte, error) {
+	var samples [][]byte
+	total := 0
+	errEnough := io.EOF
+	err := filepath.WalkDir(filepath.Join(dataDir, "base"), func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil // недоступное просто не попадёт в образцы
+		}
+		info, err := d.Info()
+		if err != nil || info.Size() == 0 || info.Size() > dictSampleMax {
+			return nil
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			return nil
+		}
+		samples = append(samples, data[:min(len(data), dictSampleIndex)])
+		if total += len(data); total >= dictSamplesSize {
+			return errEnough
+		}
+		return nil
+	})
+	if err != nil && err != errEnough {
+		return nil, err
+	}
+	if len(samples) < dictMinSamples {
+		return nil, nil
+	}
+	return dict.BuildZstdDict(samples, dict.Options{MaxDictSize: dictMaxSize, HashBytes: 6})
+}
+
+// zipZstdDict — словарь для архива из dataDir или nil без --zstd-dict;
+// сбой обучения не мешает бэкапу.
+func zipZstdDict(dataDir string) []byte {
+	if !zstdDict {
+		return nil
+	}
+	d, err := trainZstdDict(dataDir)
+	switch {
+	case err != nil:
+		log.Printf("%s--zstd-dict: cannot train a dictionary, compressing without: %v%s", yellow, err, reset)
+	case d == nil:
+		log.Printf("%s--zstd-dict: too few small files to train on, compressing without a dictionary%s", yellow, reset)
+	default:
+		log.Printf("%s📖 zstd dictionary: %d KiB%s", cyan, len(d)>>10, reset)
+	}
+	return d
+}
+
+// zstdEntryCompressor — фабрика для zip.RegisterCompressor: одна запись —
+// один кадр zstd, со словарём, если он есть. zip пишет записи по одной,
+// поэтому кодировщик на архив один и между записями только Reset: новый
+// на каждый мелкий файл стоил бы дороже самого сжатия.
+func zstdEntryCompressor(level int, d []byte) func(io.Writer) (io.WriteCloser, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level != 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	if d != nil {
+		opts = append(opts, zstd.WithEncoderDict(d))
+	}
+	var enc *zstd.Encoder
+	return func(w io.Writer) (io.WriteCloser, error) {
+		if enc == nil {
+			var err error
+			enc, err = zstd.NewWriter(w, opts...)
+			return enc, err
+		}
+		enc.Reset(w)
+		return enc, nil
+	}
+}
+
+// zstdEntryDecompressor — обратное для zip.RegisterDecompressor.
+func zstdEntryDecompressor(d []byte) func(io.Reader) io.ReadCloser {
+	var opts []zstd.DOption
+	if d != nil {
+		opts = append(opts, zstd.WithDecoderDicts(d))
+	}
+	return func(r io.Reader) io.ReadCloser {
+		zr, err := zstd.NewReader(r, opts...)
+		if err != nil {
+			return io.NopCloser(errReader{err})
+		}
+		return zr.IOReadCloser()
+	}
+}
+
+type errReader struct{ err error }
+
+func (r errReader) Read([]byte) (int, error) { return 0, r.err }
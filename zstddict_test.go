@@ -0,0 +1,117 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testSmallFilesDir — много мелких похожих файлов, как у кластера из
+// сотен маленьких баз.
+func testSmallFilesDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for db := 0; db < 50; db++ {
+		d := filepath.Join(dir, "base", fmt.Sprint(16384+db))
+		if err := os.MkdirAll(d, 0o700); err != nil {
+			t.Fatal(err)
+		}
+		for rel := 0; rel < 8; rel++ {
+			// у файлов общая схема и словарь значений, внутри файла повторов мало
+			var b bytes.Buffer
+			for row := 0; row < 4; row++ {
+				fmt.Fprintf(&b, "tenant_%03d|relation_%d|row %d|status=active;plan=enterprise;region=eu-central-1;currency=EUR|%08x\n",
+					db, rel, row, (db*8+rel)*2654435761+row)
+			}
+			if err := os.WriteFile(filepath.Join(d, fmt.Sprint(1247+rel)), b.Bytes(), 0o600); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return dir
+}
+
+func zipArchive(t *testing.T, dataDir string, dict bool) string {
+	t.Helper()
+	defer func(c Compressor, a string, d bool) { compressor, archiveContainer, zstdDict = c, a, d }(compressor, archiveContainer, zstdDict)
+	compressor, archiveContainer, zstdDict = zstdCompressor{threads: 1}, "zip", dict
+	dst := filepath.Join(t.TempDir(), "a.zip")
+	if _, err := createTarGzFromDir(dst, dataDir, archiveOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	return dst
+}
+
+// zipEntriesSize — сжатый размер записей без самого словаря.
+func zipEntriesSize(t *testing.T, archive string) uint64 {
+	t.Helper()
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zr.Close()
+	var n uint64
+	for _, f := range zr.File {
+		if f.Name != zstdDictEntry {
+			n += f.CompressedSize64
+		}
+	}
+	return n
+}
+
+func TestZstdDictRoundTrip(t *testing.T) {
+	dataDir := testSmallFilesDir(t)
+	plain := zipArchive(t, dataDir, false)
+	withDict := zipArchive(t, dataDir, true)
+
+	plainSize, dictSize := zipEntriesSize(t, plain), zipEntriesSize(t, withDict)
+	t.Logf("entries: %d bytes without a dictionary, %d with", plainSize, dictSize)
+	if dictSize >= plainSize*3/4 {
+		t.Errorf("dictionary saved too little: %d → %d bytes", plainSize, dictSize)
+	}
+
+	for _, archive := range []string{plain, withDict} {
+		r, err := openArchive(archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(r)
+		files := 0
+		for {
+			hdr, err := tr.Next()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("%s: %v", archive, err)
+			}
+			if hdr.Name == zstdDictEntry {
+				t.Errorf("%s: dictionary entry leaked into the tar stream", archive)
+			}
+			if hdr.Typeflag != tar.TypeReg {
+				continue
+			}
+			got, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatalf("%s: %s: %v", archive, hdr.Name, err)
+			}
+			want, err := os.ReadFile(filepath.Join(dataDir, hdr.Name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: %s differs after round trip", archive, hdr.Name)
+			}
+			files++
+		}
+		r.Close()
+		if files != 400 {
+			t.Errorf("%s: %d files, want 400", archive, files)
+		}
+	}
+}